@@ -0,0 +1,451 @@
+// Package awssm implements a hotload strategy that polls an AWS Secrets Manager
+// secret, and builds the connection string from the standard RDS secret JSON
+// (engine, host, port, username, password and dbname). The strategy is aware of the
+// rotation staging labels: it follows AWSCURRENT by default, and can validate the
+// AWSPENDING version of a rotation in progress before it is promoted.
+//
+// Import it to register the "awssm" strategy, the path is the secret id (name or ARN):
+//
+//	import _ "github.com/infobloxopen/hotload/awssm"
+//
+//	db, err := sql.Open("hotload", "awssm://postgres/prod/app/db?region=us-east-1&interval=1m")
+package awssm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/infobloxopen/hotload"
+	"github.com/infobloxopen/hotload/internal/awsconfig"
+	"github.com/infobloxopen/hotload/internal/backoff"
+	"github.com/infobloxopen/hotload/internal/fanout"
+	"github.com/infobloxopen/hotload/internal/pqdsn"
+	"github.com/infobloxopen/hotload/logger"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	hotload.RegisterStrategy("awssm", NewStrategy())
+}
+
+const (
+	regionParam          = "region"
+	endpointParam        = "endpoint"
+	stageParam           = "stage"
+	templateParam        = "template"
+	validatePendingParam = "validatePending"
+	intervalParam        = "interval"
+	maxBackoffParam      = "maxBackoff"
+)
+
+// The staging labels of the versions of a secret during a rotation
+const (
+	StageCurrent  = "AWSCURRENT"
+	StagePending  = "AWSPENDING"
+	StagePrevious = "AWSPREVIOUS"
+)
+
+// secretParams are the query params configuring the polling, the watches
+// of a secret with the same secretParams share the same polling
+var secretParams = []string{
+	regionParam, endpointParam, stageParam, templateParam,
+	validatePendingParam, intervalParam, maxBackoffParam,
+}
+
+var (
+	// ErrAWS is returned when a request to Secrets Manager fails
+	ErrAWS = errors.New("secrets manager request failed")
+	// ErrMissingSecretID is returned by Watch when the path is empty
+	ErrMissingSecretID = errors.New("missing secret id")
+	// ErrMissingRegion is returned by Watch when no region is set
+	ErrMissingRegion = errors.New("missing region")
+	// ErrInvalidParam is returned by Watch when a query param is invalid
+	ErrInvalidParam = errors.New("invalid query param")
+	// ErrInvalidSecret is returned when the secret is not a valid RDS secret JSON
+	ErrInvalidSecret = errors.New("invalid RDS secret")
+)
+
+// TemplateData is the data of the connection string template
+// (eg: "postgres://{{.Username}}:{{.Password | urlquery}}@{{.Host}}:{{.Port}}/{{.DBName}}")
+type TemplateData struct {
+	Engine   string
+	Host     string
+	Port     string
+	Username string
+	Password string
+	DBName   string
+	// Data is all the fields of the secret
+	Data map[string]any
+}
+
+// NewStrategy implements a hotload strategy that monitors config changes
+// by polling AWS Secrets Manager.
+func NewStrategy(opts ...Option) *Strategy {
+	defOpts := newDefaultOptions()
+	for _, opt := range opts {
+		opt(defOpts)
+	}
+	s := &Strategy{
+		credentials:      defOpts.credentials,
+		region:           defOpts.region,
+		endpoint:         defOpts.endpoint,
+		client:           defOpts.client,
+		interval:         defOpts.interval,
+		maxBackoff:       defOpts.maxBackoff,
+		timeout:          defOpts.timeout,
+		pendingValidator: defOpts.pendingValidator,
+		polls:            make(map[string]*secretPoll),
+	}
+	s.watches = fanout.New("awssm", s.onCloseWatch)
+	return s
+}
+
+// Strategy implements the hotload Strategy interface by periodically
+// getting the watched secrets.
+type Strategy struct {
+	mu               sync.Mutex
+	awsConfig        awsconfig.Loader
+	credentials      aws.CredentialsProvider
+	region           string
+	endpoint         string
+	client           *http.Client
+	interval         time.Duration
+	maxBackoff       time.Duration
+	timeout          time.Duration
+	pendingValidator PendingValidator
+	watches          *fanout.Watches
+	polls            map[string]*secretPoll
+}
+
+// secretConfig is the secret and the polling config of a watch
+type secretConfig struct {
+	secretID string
+	// region and endpoint are resolved by the AWS SDK default config when not set
+	region          string
+	endpoint        string
+	stage           string
+	template        string
+	validatePending bool
+	interval        time.Duration
+	maxBackoff      time.Duration
+	// key identifies the polling of the secret with this config
+	key string
+}
+
+// secretPoll is the polling state of a watched secret config
+type secretPoll struct {
+	cfg    *secretConfig
+	client *secretsmanager.Client
+	tmpl   *template.Template
+	cancel context.CancelFunc
+
+	// only accessed by the polling loop (once started)
+	versionID string
+	value     string
+	failures  int
+	// pendingVersionID is the last AWSPENDING version validated
+	pendingVersionID string
+}
+
+// Watch implements the hotload.Strategy interface.
+func (s *Strategy) Watch(ctx context.Context, pth string, pathQry string) (value string, values <-chan string, err error) {
+	pathQry = strings.TrimSpace(pathQry)
+	cfg, err := s.parseConfig(pth, pathQry)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return s.watches.Watch(cfg.key, pathQry, func() (string, error) {
+		client, err := s.newClient(ctx, cfg)
+		if err != nil {
+			return "", err
+		}
+		sp := &secretPoll{cfg: cfg, client: client}
+		if cfg.template != "" {
+			tmpl, err := template.New("dsn").Option("missingkey=error").Parse(cfg.template)
+			if err != nil {
+				return "", errors.Wrap(err, "could not parse template")
+			}
+			sp.tmpl = tmpl
+		}
+		if _, err := s.fetch(ctx, sp); err != nil {
+			return "", err
+		}
+		pollCtx, cancel := context.WithCancel(ctx)
+		sp.cancel = cancel
+		s.mu.Lock()
+		s.polls[cfg.key] = sp
+		s.mu.Unlock()
+		go s.pollLoop(pollCtx, sp)
+		return sp.value, nil
+	})
+}
+
+// CloseWatch implements the hotload.Strategy interface.
+// Closes the specified watch by closing its update channel,
+// the secret is no longer polled once all its watches are closed.
+func (s *Strategy) CloseWatch(pth string, pathQry string) error {
+	pathQry = strings.TrimSpace(pathQry)
+	cfg, err := s.parseConfig(pth, pathQry)
+	if err != nil {
+		return err
+	}
+	s.watches.CloseWatch(cfg.key, pathQry)
+	return nil
+}
+
+// onCloseWatch stops polling the secret once its last query is closed
+func (s *Strategy) onCloseWatch(key, pathQry string, last bool) {
+	if !last {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sp, ok := s.polls[key]
+	if !ok {
+		return
+	}
+	sp.cancel()
+	delete(s.polls, key)
+	logger.DebugC("awssm.processWatchClosure", "stopped polling secret", slog.String(logger.PathKey, sp.cfg.secretID))
+}
+
+// Close implements the hotload.Strategy interface.
+// Closes this strategy by stopping all the polling
+// and closing all the update channels.
+func (s *Strategy) Close() {
+	s.mu.Lock()
+	polls := s.polls
+	s.polls = make(map[string]*secretPoll)
+	s.mu.Unlock()
+	for _, sp := range polls {
+		sp.cancel()
+	}
+	s.watches.Close()
+}
+
+// parseConfig parses the secret id (the path) and the polling config (the query params)
+func (s *Strategy) parseConfig(pth, pathQry string) (*secretConfig, error) {
+	vs, err := url.ParseQuery(pathQry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse query %v", pathQry)
+	}
+	cfg := &secretConfig{
+		secretID: strings.TrimPrefix(pth, "/"),
+		region:   vs.Get(regionParam),
+		endpoint: vs.Get(endpointParam),
+		stage:    vs.Get(stageParam),
+		template: vs.Get(templateParam),
+	}
+	if cfg.secretID == "" {
+		return nil, ErrMissingSecretID
+	}
+	if cfg.region == "" {
+		cfg.region = s.region
+	}
+	if cfg.endpoint == "" {
+		cfg.endpoint = s.endpoint
+	}
+	if cfg.stage == "" {
+		cfg.stage = StageCurrent
+	}
+	if v := vs.Get(validatePendingParam); v != "" {
+		if cfg.validatePending, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("%w: %s=%q", ErrInvalidParam, validatePendingParam, v)
+		}
+	}
+	if cfg.interval, err = backoff.ParseDuration(vs, intervalParam, s.interval, ErrInvalidParam); err != nil {
+		return nil, err
+	}
+	if cfg.maxBackoff, err = backoff.ParseDuration(vs, maxBackoffParam, s.maxBackoff, ErrInvalidParam); err != nil {
+		return nil, err
+	}
+
+	keyVals := make(url.Values)
+	for _, param := range secretParams {
+		if v, ok := vs[param]; ok {
+			keyVals[param] = v
+		}
+	}
+	cfg.key = cfg.secretID + "?" + keyVals.Encode()
+	return cfg, nil
+}
+
+// fetch gets the version of the secret with the stage, and builds the connection
+// string if the version changed. Returns true if the connection string changed.
+func (s *Strategy) fetch(ctx context.Context, sp *secretPoll) (bool, error) {
+	sv, err := s.getSecretValue(ctx, sp, sp.cfg.stage)
+	if err != nil {
+		return false, err
+	}
+	if sv.VersionID != "" && sv.VersionID == sp.versionID {
+		return false, nil
+	}
+	val, err := sp.buildDSN(sv.SecretString)
+	if err != nil {
+		return false, err
+	}
+	sp.versionID = sv.VersionID
+	if val == sp.value {
+		return false, nil
+	}
+	sp.value = val
+	return true, nil
+}
+
+// checkPending validates the AWSPENDING version of the secret (once per version),
+// if a rotation is in progress. The failures are only logged, as the version
+// is not used until it is promoted.
+func (s *Strategy) checkPending(ctx context.Context, sp *secretPoll) {
+	sv, err := s.getSecretValue(ctx, sp, StagePending)
+	if isNotFound(err) {
+		// no rotation in progress
+		return
+	}
+	if err != nil {
+		logger.ErrorC("awssm.checkPending", "failed to get "+StagePending+" version",
+			slog.String(logger.PathKey, sp.cfg.secretID),
+			logger.Err(err),
+		)
+		return
+	}
+	if sv.VersionID == sp.versionID || sv.VersionID == sp.pendingVersionID {
+		return
+	}
+	sp.pendingVersionID = sv.VersionID
+
+	attrs := []slog.Attr{
+		slog.String(logger.PathKey, sp.cfg.secretID),
+		slog.String("versionId", sv.VersionID),
+	}
+	val, err := sp.buildDSN(sv.SecretString)
+	if err == nil && s.pendingValidator != nil {
+		err = s.pendingValidator(ctx, val)
+	}
+	if err != nil {
+		logger.ErrorC("awssm.checkPending", StagePending+" version is invalid", append(attrs, logger.Err(err))...)
+		return
+	}
+	logger.Info(StagePending+" version is valid", append([]slog.Attr{logger.Component("awssm.checkPending")}, attrs...)...)
+}
+
+// buildDSN parses the RDS secret JSON, and builds the connection string
+// from the template, or as libpq key/value pairs by default
+func (sp *secretPoll) buildDSN(secretString string) (string, error) {
+	data := make(map[string]any)
+	if err := json.Unmarshal([]byte(secretString), &data); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidSecret, err)
+	}
+	td := TemplateData{
+		Engine:   stringField(data, "engine"),
+		Host:     stringField(data, "host"),
+		Port:     stringField(data, "port"),
+		Username: stringField(data, "username"),
+		Password: stringField(data, "password"),
+		DBName:   stringField(data, "dbname"),
+		Data:     data,
+	}
+	if td.Username == "" || td.Password == "" {
+		return "", fmt.Errorf("%w: missing username or password", ErrInvalidSecret)
+	}
+
+	if sp.tmpl != nil {
+		var buf bytes.Buffer
+		if err := sp.tmpl.Execute(&buf, td); err != nil {
+			return "", errors.Wrap(err, "could not execute template")
+		}
+		return strings.TrimSpace(buf.String()), nil
+	}
+
+	var pairs pqdsn.Pairs
+	for _, p := range (pqdsn.Pairs{
+		{Key: "host", Value: td.Host},
+		{Key: "port", Value: td.Port},
+		{Key: "user", Value: td.Username},
+		{Key: "password", Value: td.Password},
+		{Key: "dbname", Value: td.DBName},
+	}) {
+		if p.Value != "" {
+			pairs = append(pairs, p)
+		}
+	}
+	return pairs.String(), nil
+}
+
+// stringField returns the string (or number, eg: the port) field of the secret
+func stringField(data map[string]any, name string) string {
+	switch v := data[name].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// nextDelay returns the interval, doubled for each consecutive failure up to maxBackoff
+func (sp *secretPoll) nextDelay() time.Duration {
+	return backoff.Delay(sp.cfg.interval, sp.cfg.maxBackoff, sp.failures)
+}
+
+// pollLoop polls the secret until ctx is done (the secret is no longer watched)
+func (s *Strategy) pollLoop(ctx context.Context, sp *secretPoll) {
+	logger.DebugC("awssm.pollLoop", "started", slog.String(logger.PathKey, sp.cfg.secretID))
+	for {
+		timer := time.NewTimer(sp.nextDelay())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.DebugC("awssm.pollLoop", "terminated", slog.String(logger.PathKey, sp.cfg.secretID))
+			return
+		case <-timer.C:
+			s.poll(ctx, sp)
+		}
+	}
+}
+
+// poll gets the secret, and sends its connection string to the watches if it changed
+func (s *Strategy) poll(ctx context.Context, sp *secretPoll) {
+	changed, err := s.fetch(ctx, sp)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		if sp.failures == 0 {
+			// only log the first failure, until the secret is got again
+			logger.ErrorC("awssm.poll", "poll failed",
+				slog.String(logger.PathKey, sp.cfg.secretID),
+				logger.Err(err),
+			)
+		}
+		sp.failures++
+		return
+	}
+	if sp.failures > 0 {
+		logger.DebugC("awssm.poll", "poll recovered", slog.String(logger.PathKey, sp.cfg.secretID))
+		sp.failures = 0
+	}
+	if changed {
+		logger.DebugC("awssm.poll", "secret changed",
+			slog.String(logger.PathKey, sp.cfg.secretID),
+			slog.String("versionId", sp.versionID),
+		)
+		s.watches.Set(sp.cfg.key, sp.value)
+	}
+	if sp.cfg.validatePending {
+		s.checkPending(ctx, sp)
+	}
+}
@@ -0,0 +1,122 @@
+package awssm
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+var (
+	// DefaultInterval is the default interval for polling secrets
+	// (when the hotload connection string has no interval query param)
+	DefaultInterval = time.Minute
+
+	// DefaultMaxBackoff is the default longest delay between polls of a failing secret
+	// (when the hotload connection string has no maxBackoff query param)
+	DefaultMaxBackoff = time.Minute * 10
+
+	// DefaultTimeout is the default timeout of the requests
+	DefaultTimeout = time.Second * 10
+)
+
+// PendingValidator validates the connection string of the AWSPENDING version
+// of a secret (eg: by connecting to the database with it)
+type PendingValidator func(ctx context.Context, dsn string) error
+
+type awssmOptions struct {
+	credentials      aws.CredentialsProvider
+	region           string
+	endpoint         string
+	client           *http.Client
+	interval         time.Duration
+	maxBackoff       time.Duration
+	timeout          time.Duration
+	pendingValidator PendingValidator
+}
+
+type Option func(*awssmOptions)
+
+func newDefaultOptions() *awssmOptions {
+	return &awssmOptions{
+		interval:   DefaultInterval,
+		maxBackoff: DefaultMaxBackoff,
+		timeout:    DefaultTimeout,
+	}
+}
+
+// WithCredentials is the option to set the AWS credentials provider
+// (by default, the credentials of the AWS SDK default config: env vars,
+// shared config files, web identity, container or instance role)
+func WithCredentials(credentials aws.CredentialsProvider) Option {
+	return func(opts *awssmOptions) {
+		opts.credentials = credentials
+	}
+}
+
+// WithRegion is the option to set the default AWS region
+// (by default, the region of the AWS SDK default config)
+func WithRegion(region string) Option {
+	return func(opts *awssmOptions) {
+		opts.region = region
+	}
+}
+
+// WithEndpoint is the option to set the default Secrets Manager endpoint
+// (by default, the endpoint the AWS SDK resolves for the region)
+func WithEndpoint(endpoint string) Option {
+	return func(opts *awssmOptions) {
+		opts.endpoint = endpoint
+	}
+}
+
+// WithHTTPClient is the option to set the HTTP client of the requests
+// (by default, the AWS SDK HTTP client)
+func WithHTTPClient(client *http.Client) Option {
+	return func(opts *awssmOptions) {
+		opts.client = client
+	}
+}
+
+// WithInterval is the option to set the default polling interval
+func WithInterval(interval time.Duration) Option {
+	return func(opts *awssmOptions) {
+		if interval <= 0 {
+			opts.interval = DefaultInterval
+		} else {
+			opts.interval = interval
+		}
+	}
+}
+
+// WithMaxBackoff is the option to set the default longest delay between polls of a failing secret
+func WithMaxBackoff(maxBackoff time.Duration) Option {
+	return func(opts *awssmOptions) {
+		if maxBackoff <= 0 {
+			opts.maxBackoff = DefaultMaxBackoff
+		} else {
+			opts.maxBackoff = maxBackoff
+		}
+	}
+}
+
+// WithTimeout is the option to set the timeout of the requests
+func WithTimeout(timeout time.Duration) Option {
+	return func(opts *awssmOptions) {
+		if timeout <= 0 {
+			opts.timeout = DefaultTimeout
+		} else {
+			opts.timeout = timeout
+		}
+	}
+}
+
+// WithPendingValidator is the option to set the validator of the AWSPENDING versions
+// of the secrets watched with validatePending=true (by default, the AWSPENDING
+// versions are only checked to be valid RDS secrets)
+func WithPendingValidator(validator PendingValidator) Option {
+	return func(opts *awssmOptions) {
+		opts.pendingValidator = validator
+	}
+}
@@ -0,0 +1,69 @@
+package awssm
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/pkg/errors"
+)
+
+// secretValue is the version of a secret returned by GetSecretValue
+type secretValue struct {
+	VersionID    string
+	SecretString string
+}
+
+// isNotFound returns true if the secret (or its version stage) does not exist
+func isNotFound(err error) bool {
+	var nfErr *types.ResourceNotFoundException
+	return errors.As(err, &nfErr)
+}
+
+// newClient returns the Secrets Manager client of the secret config,
+// with the AWS SDK default config and the strategy options
+func (s *Strategy) newClient(ctx context.Context, cfg *secretConfig) (*secretsmanager.Client, error) {
+	awsCfg, err := s.awsConfig.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	region := cfg.region
+	if region == "" {
+		region = awsCfg.Region
+	}
+	if region == "" {
+		return nil, ErrMissingRegion
+	}
+	return secretsmanager.NewFromConfig(awsCfg, func(opts *secretsmanager.Options) {
+		opts.Region = region
+		if cfg.endpoint != "" {
+			opts.BaseEndpoint = aws.String(cfg.endpoint)
+		}
+		if s.credentials != nil {
+			opts.Credentials = s.credentials
+		}
+		if s.client != nil {
+			opts.HTTPClient = s.client
+		}
+	}), nil
+}
+
+// getSecretValue gets the version of the secret with the stage
+func (s *Strategy) getSecretValue(ctx context.Context, sp *secretPoll, stage string) (*secretValue, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	out, err := sp.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId:     aws.String(sp.cfg.secretID),
+		VersionStage: aws.String(stage),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAWS, err)
+	}
+	return &secretValue{
+		VersionID:    aws.ToString(out.VersionId),
+		SecretString: aws.ToString(out.SecretString),
+	}, nil
+}
@@ -3,10 +3,14 @@ package hotload
 import (
 	"context"
 	"database/sql/driver"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -59,7 +63,6 @@ func (mw mockWatcher) sendValue(value string) {
 
 var _ = DescribeTableSubtree("Driver", Serial, func(forceKill bool) {
 	var pctx context.Context
-	var ctx context.Context
 	var cancel context.CancelFunc
 	var cg *chanGroup
 	var mgdConns []*managedConn
@@ -67,26 +70,18 @@ var _ = DescribeTableSubtree("Driver", Serial, func(forceKill bool) {
 	Context("chanGroup", func() {
 		BeforeEach(func(ginkgoCtx context.Context) {
 			// Do NOT use ginkgoCtx as it will be canceled when BeforeEach finishes
-			pctx = context.Background()
-			ctx, cancel = context.WithCancel(pctx)
+			pctx, cancel = context.WithCancel(context.Background())
 			mockw = newMockWatcher()
-			cg = &chanGroup{
-				name:       "fsnotify://postgres/tmp/mydsn.txt",
-				value:      "1st-dsn",
-				newValChan: mockw.getReceiveChan(),
-				parentCtx:  pctx,
-				ctx:        ctx,
-				cancel:     cancel,
-				sqlDriver:  nil,
-				mu:         sync.RWMutex{},
-				forceKill:  forceKill,
+			cg = newChanGroup(pctx, "fsnotify://postgres/tmp/mydsn.txt", "1st-dsn", mockw.getReceiveChan(), nil)
+			cg.forceKill = forceKill
+			gen := cg.active()
+			gen.conns = []*managedConn{
+				newManagedConn(gen.ctx, gen.id, cg.value, cg.value, &testConn{}, cg.removeMgdConn),
+				newManagedConn(gen.ctx, gen.id, cg.value, cg.value, &testConn{}, cg.removeMgdConn),
+				newManagedConn(gen.ctx, gen.id, cg.value, cg.value, &testConn{}, cg.removeMgdConn),
 			}
-			cg.conns = []*managedConn{
-				newManagedConn(ctx, cg.value, cg.value, &testConn{}, cg.removeMgdConn),
-				newManagedConn(ctx, cg.value, cg.value, &testConn{}, cg.removeMgdConn),
-				newManagedConn(ctx, cg.value, cg.value, &testConn{}, cg.removeMgdConn),
-			}
-			mgdConns = cg.conns
+			mgdConns = gen.conns
+			DeferCleanup(cancel)
 
 			metrics.ResetCollectors()
 		}, NodeTimeout(5*time.Second))
@@ -103,7 +98,7 @@ var _ = DescribeTableSubtree("Driver", Serial, func(forceKill bool) {
 			defer cg.mu.RUnlock()
 			Expect(cg.value).To(Equal(newVal))
 
-			Expect(len(cg.conns)).To(Equal(0), "number of managed conns should be reset to zero")
+			Expect(len(cg.active().conns)).To(Equal(0), "number of managed conns should be reset to zero")
 
 			for _, mc := range mgdConns {
 				Expect(mc.GetReset()).To(BeTrue(), "managed connection should be marked reset")
@@ -130,9 +125,9 @@ var _ = DescribeTableSubtree("Driver", Serial, func(forceKill bool) {
 
 			Expect(cg.value).To(Equal(sameVal))
 
-			Expect(len(cg.conns)).To(Equal(3), "number of managed conns should not be reset to zero")
+			Expect(len(cg.active().conns)).To(Equal(3), "number of managed conns should not be reset to zero")
 
-			for _, c := range cg.conns {
+			for _, c := range cg.active().conns {
 				Expect(c.GetReset()).To(BeFalse())
 				Expect(c.GetKill()).To(BeFalse())
 				Expect(c.conn.(*testConn).closed).To(BeFalse())
@@ -148,6 +143,48 @@ var _ = DescribeTableSubtree("Driver", Serial, func(forceKill bool) {
 			err := testutil.CollectAndCompare(metrics.HotloadChangeTotal,
 				strings.NewReader(""))
 			Expect(err).ShouldNot(HaveOccurred())
+
+			err = testutil.CollectAndCompare(metrics.HotloadSwitchoverTotal,
+				strings.NewReader(expectHotloadSwitchoverTotalHelp+
+					fmt.Sprintf(expectHotloadSwitchoverTotalMetric, cg.name, metrics.SwitchoverUnchanged, 1)))
+			Expect(err).ShouldNot(HaveOccurred())
+		}, NodeTimeout(5*time.Second))
+
+		It("Should reject an empty value and keep the current value", func(ginkgoCtx context.Context) {
+			cg.processNewValue(" \n")
+			Expect(cg.value).To(Equal("1st-dsn"))
+			Expect(len(cg.active().conns)).To(Equal(3), "number of managed conns should not be reset to zero")
+
+			for _, mc := range mgdConns {
+				Expect(mc.GetReset()).To(BeFalse())
+				Expect(mc.GetKill()).To(BeFalse())
+			}
+
+			err := testutil.CollectAndCompare(metrics.HotloadSwitchoverTotal,
+				strings.NewReader(expectHotloadSwitchoverTotalHelp+
+					fmt.Sprintf(expectHotloadSwitchoverTotalMetric, cg.name, metrics.SwitchoverRejected, 1)))
+			Expect(err).ShouldNot(HaveOccurred())
+		}, NodeTimeout(5*time.Second))
+
+		It("Should observe switchover latency from strategy emission", func(ginkgoCtx context.Context) {
+			go cg.runLoop()
+			MarkEmitted(mockw.getReceiveChan())
+			mockw.sendValue("2nd-dsn")
+
+			Eventually(func() int {
+				return testutil.CollectAndCount(metrics.HotloadSwitchoverTotal)
+			}).Should(Equal(1))
+			Expect(testutil.CollectAndCount(metrics.HotloadSwitchoverLatencyHistogram)).To(Equal(1))
+
+			_, found := emitTimes.Load(mockw.getReceiveChan())
+			Expect(found).To(BeFalse(), "emission time should be forgotten once processed")
+		}, NodeTimeout(5*time.Second))
+
+		It("Should forget the emission time of a value that is not sent", func(ginkgoCtx context.Context) {
+			MarkEmitted(mockw.getReceiveChan())
+			ForgetEmitted(mockw.getReceiveChan())
+			_, found := emitTimes.Load(mockw.getReceiveChan())
+			Expect(found).To(BeFalse(), "emission time should be forgotten")
 		}, NodeTimeout(5*time.Second))
 
 		It("Should change value and reset connections", func(ginkgoCtx context.Context) {
@@ -155,7 +192,7 @@ var _ = DescribeTableSubtree("Driver", Serial, func(forceKill bool) {
 			cg.processNewValue(newVal)
 			Expect(cg.value).To(Equal(newVal))
 
-			Expect(len(cg.conns)).To(Equal(0), "number of managed conns should be reset to zero")
+			Expect(len(cg.active().conns)).To(Equal(0), "number of managed conns should be reset to zero")
 
 			for _, mc := range mgdConns {
 				Expect(mc.GetReset()).To(BeTrue(), "managed connection should be marked reset")
@@ -175,6 +212,12 @@ var _ = DescribeTableSubtree("Driver", Serial, func(forceKill bool) {
 				strings.NewReader(expectHotloadLastChangedTimestampSecondsMetricRegexp),
 				metrics.HotloadLastChangedTimestampSecondsName)
 			Expect(err).ShouldNot(HaveOccurred())
+
+			err = testutil.CollectAndCompare(metrics.HotloadSwitchoverTotal,
+				strings.NewReader(expectHotloadSwitchoverTotalHelp+
+					fmt.Sprintf(expectHotloadSwitchoverTotalMetric, cg.name, metrics.SwitchoverApplied, 1)))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(testutil.CollectAndCount(metrics.HotloadSwitchoverLatencyHistogram)).To(Equal(1))
 		}, NodeTimeout(5*time.Second))
 	})
 },
@@ -191,8 +234,799 @@ var expectHotloadChangeTotalMetric = `
 hotload_change_total{url="%s"} %d
 `
 
+var expectHotloadSwitchoverTotalHelp = `
+# HELP hotload_switchover_total Hotload switchover total by url and outcome
+# TYPE hotload_switchover_total counter
+`
+
+var expectHotloadSwitchoverTotalMetric = `
+hotload_switchover_total{outcome="%[2]s",url="%[1]s"} %[3]d
+`
+
 var expectHotloadLastChangedTimestampSecondsMetricRegexp = `
 # HELP hotload_last_changed_timestamp_seconds Hotload last changed \(unix timestamp\), by url
 # TYPE hotload_last_changed_timestamp_seconds gauge
 hotload_last_changed_timestamp_seconds{url="fsnotify://postgres/tmp/mydsn.txt"} \d\.\d+e\+\d+
 `
+
+// gatedDriver blocks each Open until released, recording the dsn being dialed
+type gatedDriver struct {
+	dialing chan string
+	release chan struct{}
+}
+
+func (d *gatedDriver) Open(name string) (driver.Conn, error) {
+	d.dialing <- name
+	<-d.release
+	return &testConn{}, nil
+}
+
+var _ = Describe("chanGroup.Open", func() {
+	var cg *chanGroup
+	var gd *gatedDriver
+
+	BeforeEach(func() {
+		pctx, cancel := context.WithCancel(context.Background())
+		gd = &gatedDriver{
+			dialing: make(chan string, 1),
+			release: make(chan struct{}),
+		}
+		cg = newChanGroup(pctx, "fsnotify://postgres/tmp/mydsn.txt", "1st-dsn", make(chan string), &driverInstance{driver: gd})
+		DeferCleanup(func() {
+			cancel()
+			metrics.ResetCollectors()
+		})
+	})
+
+	It("Should not block dsn changes while dialing", func(ginkgoCtx context.Context) {
+		type openResult struct {
+			conn driver.Conn
+			err  error
+		}
+		opened := make(chan openResult, 1)
+		go func() {
+			conn, err := cg.Open(context.Background())
+			opened <- openResult{conn, err}
+		}()
+		Eventually(gd.dialing).Should(Receive(Equal("1st-dsn")))
+
+		// Dial is in progress: the dsn change must not wait for it
+		cg.processNewValue("2nd-dsn")
+		Expect(cg.active().id).To(Equal(uint64(1)))
+
+		// Conn dialed on the superseded generation is closed and dialed again
+		close(gd.release)
+		Eventually(gd.dialing).Should(Receive(Equal("2nd-dsn")))
+
+		var res openResult
+		Eventually(opened).Should(Receive(&res))
+		Expect(res.err).ShouldNot(HaveOccurred())
+		mc := res.conn.(*managedConn)
+		Expect(mc.dsn).To(Equal("2nd-dsn"))
+		Expect(mc.generation).To(Equal(uint64(1)))
+		Expect(mc.GetReset()).To(BeFalse())
+
+		cg.mu.RLock()
+		defer cg.mu.RUnlock()
+		Expect(cg.active().conns).To(ConsistOf(mc))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should set the conn expiry from the max age and jitter", func(ginkgoCtx context.Context) {
+		cg.parseUrlValues(url.Values{maxConnAge: []string{"1h"}, maxConnAgeJitter: []string{"10m"}})
+		Expect(cg.maxAge).To(Equal(time.Hour))
+		Expect(cg.maxAgeJitter).To(Equal(10 * time.Minute))
+
+		close(gd.release)
+		start := time.Now()
+		conn, err := cg.Open(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		mc := conn.(*managedConn)
+		Expect(mc.expiresAt).To(BeTemporally(">", start.Add(50*time.Minute)))
+		Expect(mc.expiresAt).To(BeTemporally("<=", time.Now().Add(time.Hour)))
+
+		for i := 0; i < 100; i++ {
+			expiry := cg.connExpiry(start)
+			Expect(expiry).To(BeTemporally(">", start.Add(50*time.Minute)))
+			Expect(expiry).To(BeTemporally("<=", start.Add(time.Hour)))
+		}
+	}, NodeTimeout(5*time.Second))
+
+	It("Should ignore a max age jitter not less than the max age", func() {
+		cg.parseUrlValues(url.Values{maxConnAge: []string{"1m"}, maxConnAgeJitter: []string{"1m"}})
+		Expect(cg.maxAge).To(Equal(time.Minute))
+		Expect(cg.maxAgeJitter).To(BeZero())
+		Expect(cg.connExpiry(time.Time{})).To(Equal(time.Time{}.Add(time.Minute)))
+	})
+
+	It("Should return a rotation error when the generation keeps changing while dialing", func(ginkgoCtx context.Context) {
+		errs := make(chan error, 1)
+		go func() {
+			_, err := cg.Open(context.Background())
+			errs <- err
+		}()
+		for i := 1; i <= maxDialAttempts; i++ {
+			Eventually(gd.dialing).Should(Receive())
+			cg.processNewValue(fmt.Sprintf("dsn-%d", i))
+			gd.release <- struct{}{}
+		}
+
+		var err error
+		Eventually(errs).Should(Receive(&err))
+		Expect(errors.Is(err, ErrConnectionRotated)).To(BeTrue())
+		Expect(errors.Is(err, driver.ErrBadConn)).To(BeTrue())
+		Expect(cg.active().conns).To(BeEmpty())
+	}, NodeTimeout(5*time.Second))
+})
+
+// eventConn is a mock driver conn whose ExecContext blocks until released
+// (or until the ctx is done if it honors the ctx), recording events in order
+type eventConn struct {
+	honorCtx bool
+	release  chan struct{}
+	started  chan struct{}
+	mu       *sync.Mutex
+	events   *[]string
+}
+
+func (ec *eventConn) record(event string) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	*ec.events = append(*ec.events, event)
+}
+
+func (ec *eventConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, nil
+}
+
+func (ec *eventConn) Begin() (driver.Tx, error) {
+	return nil, nil
+}
+
+func (ec *eventConn) Close() error {
+	ec.record("close")
+	return nil
+}
+
+func (ec *eventConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	close(ec.started)
+	if ec.honorCtx {
+		<-ctx.Done()
+		// acknowledge the cancellation slowly
+		time.Sleep(50 * time.Millisecond)
+		ec.record("exec returned")
+		return nil, ctx.Err()
+	}
+	<-ec.release
+	ec.record("exec returned")
+	return driver.RowsAffected(1), nil
+}
+
+var _ = Describe("chanGroup cancellation handshake", func() {
+	var cg *chanGroup
+	var ec *eventConn
+	var events []string
+	var eventsMu sync.Mutex
+
+	BeforeEach(func() {
+		pctx, cancel := context.WithCancel(context.Background())
+		events = nil
+		ec = &eventConn{
+			release: make(chan struct{}),
+			started: make(chan struct{}),
+			mu:      &eventsMu,
+			events:  &events,
+		}
+		cg = newChanGroup(pctx, "fsnotify://postgres/tmp/mydsn.txt", "1st-dsn", make(chan string), nil)
+		cg.forceKill = true
+		gen := cg.active()
+		gen.conns = []*managedConn{
+			newManagedConn(gen.ctx, gen.id, cg.value, cg.value, ec, cg.removeMgdConn),
+		}
+		DeferCleanup(func() {
+			cancel()
+			metrics.ResetCollectors()
+		})
+	})
+
+	getEvents := func() []string {
+		eventsMu.Lock()
+		defer eventsMu.Unlock()
+		return append([]string{}, events...)
+	}
+
+	It("Should wait for in-flight operations to acknowledge cancellation before killing conns", func(ginkgoCtx context.Context) {
+		ec.honorCtx = true
+		mc := cg.active().conns[0]
+		execErrs := make(chan error, 1)
+		go func() {
+			_, err := mc.ExecContext(context.Background(), "INSERT INTO table (column) VALUES (1)", nil)
+			execErrs <- err
+		}()
+		Eventually(ec.started).Should(BeClosed())
+
+		cg.processNewValue("2nd-dsn")
+		Expect(getEvents()).To(Equal([]string{"exec returned", "close"}))
+
+		var err error
+		Eventually(execErrs).Should(Receive(&err))
+		Expect(err).To(MatchError(ErrConnectionRotated))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should kill conns once the cancel wait timeout expires", func(ginkgoCtx context.Context) {
+		cg.cancelWait = 50 * time.Millisecond
+		mc := cg.active().conns[0]
+		go mc.ExecContext(context.Background(), "INSERT INTO table (column) VALUES (1)", nil)
+		Eventually(ec.started).Should(BeClosed())
+
+		start := time.Now()
+		cg.processNewValue("2nd-dsn")
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(getEvents()).To(Equal([]string{"close"}))
+
+		close(ec.release)
+		Eventually(getEvents).Should(Equal([]string{"close", "exec returned"}))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should parse the cancel wait timeout from the url", func() {
+		cg.parseUrlValues(url.Values{cancelWaitTimeout: []string{"250ms"}})
+		Expect(cg.cancelWait).To(Equal(250 * time.Millisecond))
+
+		cg.parseUrlValues(url.Values{cancelWaitTimeout: []string{"bogus"}})
+		Expect(cg.cancelWait).To(Equal(250 * time.Millisecond))
+	})
+})
+
+var _ = Describe("chanGroup generations", func() {
+	var cg *chanGroup
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		var pctx context.Context
+		pctx, cancel = context.WithCancel(context.Background())
+		cg = newChanGroup(pctx, "fsnotify://postgres/tmp/mydsn.txt", "dsn-0", make(chan string), nil)
+		metrics.ResetCollectors()
+		DeferCleanup(func() {
+			cancel()
+			metrics.ResetCollectors()
+		})
+	})
+
+	// addConn adds a managed conn to the active generation
+	addConn := func() *managedConn {
+		cg.mu.Lock()
+		defer cg.mu.Unlock()
+		gen := cg.active()
+		mc := newManagedConn(gen.ctx, gen.id, cg.value, cg.value, &testConn{}, cg.removeMgdConn)
+		gen.conns = append(gen.conns, mc)
+		cg.updateGenerationMetrics()
+		return mc
+	}
+
+	states := func() []GenerationState {
+		var sts []GenerationState
+		for _, gs := range cg.status().Generations {
+			sts = append(sts, gs.State)
+		}
+		return sts
+	}
+
+	It("Should keep the previous generation draining by default", func() {
+		mc0 := addConn()
+		cg.processNewValue("dsn-1")
+		Expect(mc0.GetReset()).To(BeTrue())
+		Expect(mc0.GetKill()).To(BeFalse())
+		Expect(states()).To(Equal([]GenerationState{GenerationDraining, GenerationActive}))
+
+		cg.processNewValue("dsn-2")
+		Expect(mc0.GetKill()).To(BeTrue())
+		Expect(mc0.ctx.Err()).To(HaveOccurred())
+		Expect(states()).To(Equal([]GenerationState{GenerationDraining, GenerationActive}))
+	})
+
+	It("Should keep the configured number of generations", func() {
+		cg.parseUrlValues(url.Values{keepGenerations: []string{"3"}})
+		Expect(cg.keepGenerations).To(Equal(3))
+
+		mc0 := addConn()
+		cg.processNewValue("dsn-1")
+		mc1 := addConn()
+		cg.processNewValue("dsn-2")
+		Expect(mc0.GetKill()).To(BeFalse(), "conns two generations old should still be draining")
+		Expect(mc0.ctx.Err()).ShouldNot(HaveOccurred())
+		Expect(states()).To(Equal([]GenerationState{GenerationDraining, GenerationDraining, GenerationActive}))
+
+		cg.processNewValue("dsn-3")
+		Expect(mc0.GetKill()).To(BeTrue())
+		Expect(mc1.GetKill()).To(BeFalse())
+		Expect(mc1.GetReset()).To(BeTrue())
+
+		sts := cg.status()
+		Expect(sts.Name).To(Equal(cg.name))
+		Expect(sts.Generations).To(HaveLen(3))
+		Expect(sts.Generations[0].Generation).To(Equal(uint64(1)))
+		Expect(sts.Generations[0].RedactDsn).To(Equal(internal.RedactUrl("dsn-1")))
+		Expect(sts.Generations[0].Conns).To(Equal(1))
+		Expect(sts.Generations[2].Generation).To(Equal(uint64(3)))
+		Expect(sts.Generations[2].State).To(Equal(GenerationActive))
+		Expect(sts.Generations[2].StartTime).NotTo(BeZero())
+	})
+
+	It("Should keep only the active generation with forceKill", func() {
+		cg.forceKill = true
+		cg.keepGenerations = 3
+		mc0 := addConn()
+		cg.processNewValue("dsn-1")
+		Expect(mc0.GetKill()).To(BeTrue())
+		Expect(states()).To(Equal([]GenerationState{GenerationActive}))
+	})
+
+	It("Should ignore an invalid keepGenerations", func() {
+		cg.parseUrlValues(url.Values{keepGenerations: []string{"0"}})
+		Expect(cg.keep()).To(Equal(DefaultKeepGenerations))
+	})
+
+	It("Should report every live generation in metrics", func() {
+		addConn()
+		addConn()
+		cg.processNewValue("dsn-1")
+		mc1 := addConn()
+
+		err := testutil.CollectAndCompare(metrics.HotloadGenerationConns,
+			strings.NewReader(expectHotloadGenerationConnsHelp+
+				fmt.Sprintf(expectHotloadGenerationConnsMetric, cg.name, 0, GenerationDraining, 2)+
+				fmt.Sprintf(expectHotloadGenerationConnsMetric, cg.name, 1, GenerationActive, 1)))
+		Expect(err).ShouldNot(HaveOccurred())
+
+		mc1.Close()
+		cg.processNewValue("dsn-2")
+		err = testutil.CollectAndCompare(metrics.HotloadGenerationConns,
+			strings.NewReader(expectHotloadGenerationConnsHelp+
+				fmt.Sprintf(expectHotloadGenerationConnsMetric, cg.name, 1, GenerationDraining, 0)+
+				fmt.Sprintf(expectHotloadGenerationConnsMetric, cg.name, 2, GenerationActive, 0)))
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Should return the status of opened connection strings", func() {
+		_, err := GetStatus(cg.name)
+		Expect(err).To(MatchError(ErrNotOpened))
+
+		hotloadDriver.mu.Lock()
+		hotloadDriver.cgroup[cg.name] = cg
+		hotloadDriver.mu.Unlock()
+		DeferCleanup(func() {
+			hotloadDriver.mu.Lock()
+			delete(hotloadDriver.cgroup, cg.name)
+			hotloadDriver.mu.Unlock()
+		})
+
+		sts, err := GetStatus(cg.name)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(sts.Generations).To(HaveLen(1))
+		Expect(sts.Generations[0].State.String()).To(Equal("active"))
+	})
+})
+
+var expectHotloadGenerationConnsHelp = `
+# HELP hotload_generation_conns Hotload managed conns of each live generation, by url, generation and state
+# TYPE hotload_generation_conns gauge
+`
+
+var expectHotloadGenerationConnsMetric = `
+hotload_generation_conns{generation="%[2]d",state="%[3]s",url="%[1]s"} %[4]d
+`
+
+// pingConn is a mock driver conn implementing driver.Pinger
+type pingConn struct {
+	dsn      string
+	pingErr  error
+	closed   atomic.Bool
+	pingedAt time.Time
+}
+
+func (pc *pingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, nil
+}
+
+func (pc *pingConn) Begin() (driver.Tx, error) {
+	return nil, nil
+}
+
+func (pc *pingConn) Close() error {
+	pc.closed.Store(true)
+	return nil
+}
+
+func (pc *pingConn) Ping(ctx context.Context) error {
+	pc.pingedAt = time.Now()
+	return pc.pingErr
+}
+
+// warmDriver is a mock driver recording the conns it opened
+type warmDriver struct {
+	mu      sync.Mutex
+	opened  []*pingConn
+	pingErr error
+	block   chan struct{}
+}
+
+func (wd *warmDriver) Open(name string) (driver.Conn, error) {
+	if wd.block != nil {
+		<-wd.block
+	}
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	pc := &pingConn{dsn: name, pingErr: wd.pingErr}
+	wd.opened = append(wd.opened, pc)
+	return pc, nil
+}
+
+func (wd *warmDriver) getOpened() []*pingConn {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	return append([]*pingConn{}, wd.opened...)
+}
+
+var _ = Describe("chanGroup warm-up", func() {
+	var cg *chanGroup
+	var wd *warmDriver
+
+	BeforeEach(func() {
+		pctx, cancel := context.WithCancel(context.Background())
+		wd = &warmDriver{}
+		cg = newChanGroup(pctx, "fsnotify://postgres/tmp/mydsn.txt", "1st-dsn", make(chan string), &driverInstance{driver: wd})
+		cg.parseUrlValues(url.Values{warmConns: []string{"2"}})
+		Expect(cg.warmConns).To(Equal(2))
+		DeferCleanup(func() {
+			cancel()
+			metrics.ResetCollectors()
+		})
+	})
+
+	It("Should open verified warm conns before switching over and hand them out first", func(ginkgoCtx context.Context) {
+		mc0, err := cg.Open(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cg.processNewValue("2nd-dsn")
+		opened := wd.getOpened()
+		Expect(opened).To(HaveLen(3))
+		for _, pc := range opened[1:] {
+			Expect(pc.dsn).To(Equal("2nd-dsn"))
+			Expect(pc.pingedAt).NotTo(BeZero(), "warm conns should be verified")
+		}
+		Expect(mc0.(*managedConn).GetReset()).To(BeTrue())
+
+		sts := cg.status()
+		Expect(sts.Generations[1].Conns).To(Equal(2))
+		Expect(sts.Generations[1].ReadyConns).To(Equal(2))
+
+		for i := 0; i < 2; i++ {
+			conn, err := cg.Open(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(conn.(*managedConn).conn).To(BeIdenticalTo(opened[1+i]))
+			Expect(conn.(*managedConn).generation).To(Equal(uint64(1)))
+		}
+		Expect(wd.getOpened()).To(HaveLen(3), "ready conns should be handed out without dialing")
+
+		_, err = cg.Open(context.Background())
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(wd.getOpened()).To(HaveLen(4))
+		Expect(cg.status().Generations[1].ReadyConns).To(BeZero())
+	}, NodeTimeout(5*time.Second))
+
+	It("Should discard warm conns that fail verification", func(ginkgoCtx context.Context) {
+		wd.pingErr = errors.New("auth failed")
+		cg.processNewValue("2nd-dsn")
+		opened := wd.getOpened()
+		Expect(opened).To(HaveLen(2))
+		for _, pc := range opened {
+			Expect(pc.closed.Load()).To(BeTrue())
+		}
+		Expect(cg.value).To(Equal("2nd-dsn"), "the switchover should not be prevented")
+		Expect(cg.status().Generations[1].ReadyConns).To(BeZero())
+	}, NodeTimeout(5*time.Second))
+
+	It("Should close unused warm conns of the previous generation", func(ginkgoCtx context.Context) {
+		cg.processNewValue("2nd-dsn")
+		cg.processNewValue("3rd-dsn")
+		opened := wd.getOpened()
+		Expect(opened).To(HaveLen(4))
+		Expect(opened[0].closed.Load()).To(BeTrue())
+		Expect(opened[1].closed.Load()).To(BeTrue())
+		Expect(opened[2].closed.Load()).To(BeFalse())
+		Expect(opened[3].closed.Load()).To(BeFalse())
+
+		sts := cg.status()
+		Expect(sts.Generations[0].Conns).To(BeZero())
+		Expect(sts.Generations[1].ReadyConns).To(Equal(2))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should not wait for warm conns longer than the warm timeout", func(ginkgoCtx context.Context) {
+		cg.parseUrlValues(url.Values{warmTimeout: []string{"50ms"}})
+		wd.block = make(chan struct{})
+
+		start := time.Now()
+		cg.processNewValue("2nd-dsn")
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(cg.status().Generations[1].ReadyConns).To(BeZero())
+
+		close(wd.block)
+		Eventually(func() int {
+			closed := 0
+			for _, pc := range wd.getOpened() {
+				if pc.closed.Load() {
+					closed++
+				}
+			}
+			return closed
+		}).Should(Equal(2), "late warm conns should be closed")
+	}, NodeTimeout(5*time.Second))
+})
+
+// closeTimeConn is a mock driver conn recording when it was closed
+type closeTimeConn struct {
+	closedAt atomic.Int64
+}
+
+func (ctc *closeTimeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, nil
+}
+
+func (ctc *closeTimeConn) Begin() (driver.Tx, error) {
+	return nil, nil
+}
+
+func (ctc *closeTimeConn) Close() error {
+	ctc.closedAt.Store(time.Now().UnixNano())
+	return nil
+}
+
+var _ = Describe("chanGroup pacing", func() {
+	var cg *chanGroup
+	var mockw *mockWatcher
+
+	BeforeEach(func() {
+		pctx, cancel := context.WithCancel(context.Background())
+		mockw = newMockWatcher()
+		cg = newChanGroup(pctx, "fsnotify://postgres/tmp/mydsn.txt", "1st-dsn", mockw.getReceiveChan(), nil)
+		metrics.ResetCollectors()
+		DeferCleanup(func() {
+			cancel()
+			metrics.ResetCollectors()
+		})
+	})
+
+	It("Should spread the closes of retired conns over the close window", func(ginkgoCtx context.Context) {
+		cg.parseUrlValues(url.Values{forceKill: []string{"true"}, closeWindow: []string{"300ms"}})
+		Expect(cg.closeWindow).To(Equal(300 * time.Millisecond))
+
+		var conns []*closeTimeConn
+		var mgdConns []*managedConn
+		cg.mu.Lock()
+		for i := 0; i < 10; i++ {
+			ctc := &closeTimeConn{}
+			conns = append(conns, ctc)
+			mgdConns = append(mgdConns, cg.addMgdConn(cg.active(), "1st-dsn", "1st-dsn", ctc, time.Now()))
+		}
+		retiredGen := cg.active()
+		cg.mu.Unlock()
+
+		start := time.Now()
+		cg.processNewValue("2nd-dsn")
+		Expect(time.Since(start)).To(BeNumerically("<", 100*time.Millisecond), "closes should be paced in the background")
+
+		Eventually(retiredGen.ctx.Done()).Should(BeClosed())
+		var first, last time.Duration
+		for i, ctc := range conns {
+			closedAt := time.Duration(ctc.closedAt.Load() - start.UnixNano())
+			Expect(closedAt).To(BeNumerically(">", 0))
+			Expect(closedAt).To(BeNumerically("<", 300*time.Millisecond+200*time.Millisecond))
+			if i == 0 || closedAt < first {
+				first = closedAt
+			}
+			if closedAt > last {
+				last = closedAt
+			}
+			Expect(mgdConns[i].GetReset()).To(BeTrue())
+		}
+		Expect(last-first).To(BeNumerically(">", 30*time.Millisecond), "closes should not happen all at once")
+	}, NodeTimeout(5*time.Second))
+
+	It("Should apply only the latest value received during the apply delay", func(ginkgoCtx context.Context) {
+		cg.parseUrlValues(url.Values{applyDelay: []string{"200ms"}})
+		Expect(cg.applyDelay).To(Equal(200 * time.Millisecond))
+		// use the max delay
+		randInt63n = func(n int64) int64 { return n - 1 }
+		DeferCleanup(func() { randInt63n = rand.Int63n })
+
+		go cg.runLoop()
+		mockw.sendValue("2nd-dsn")
+		mockw.sendValue("3rd-dsn")
+
+		Eventually(func() string {
+			cg.mu.RLock()
+			defer cg.mu.RUnlock()
+			return cg.value
+		}).Should(Equal("3rd-dsn"))
+
+		err := testutil.CollectAndCompare(metrics.HotloadChangeTotal,
+			strings.NewReader(expectHotloadChangeTotalHelp+
+				fmt.Sprintf(expectHotloadChangeTotalMetric, cg.name, 1)))
+		Expect(err).ShouldNot(HaveOccurred())
+	}, NodeTimeout(5*time.Second))
+})
+
+// txConn is a mock driver conn supporting transactions and exec
+type txConn struct {
+	closed atomic.Bool
+}
+
+func (tc *txConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, nil
+}
+
+func (tc *txConn) Begin() (driver.Tx, error) {
+	return &txStub{}, nil
+}
+
+func (tc *txConn) Close() error {
+	tc.closed.Store(true)
+	return nil
+}
+
+func (tc *txConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type txStub struct{}
+
+func (ts *txStub) Commit() error   { return nil }
+func (ts *txStub) Rollback() error { return nil }
+
+var _ = Describe("chanGroup quiesce", func() {
+	var cg *chanGroup
+
+	BeforeEach(func() {
+		pctx, cancel := context.WithCancel(context.Background())
+		cg = newChanGroup(pctx, "fsnotify://postgres/tmp/mydsn.txt", "1st-dsn", nil, nil)
+		metrics.ResetCollectors()
+		DeferCleanup(func() {
+			cancel()
+			metrics.ResetCollectors()
+		})
+	})
+
+	addConns := func(n int) []*managedConn {
+		cg.mu.Lock()
+		defer cg.mu.Unlock()
+		var conns []*managedConn
+		for i := 0; i < n; i++ {
+			conns = append(conns, cg.addMgdConn(cg.active(), "1st-dsn", "1st-dsn", &txConn{}, time.Now()))
+		}
+		return conns
+	}
+
+	It("Should hold new work until the transactions of the old generation finish", func(ginkgoCtx context.Context) {
+		cg.parseUrlValues(url.Values{switchoverMode: []string{"quiesce"}, quiesceTimeout: []string{"2s"}})
+		Expect(cg.switchoverMode).To(Equal(switchoverQuiesce))
+		Expect(cg.quiesceTimeout).To(Equal(2 * time.Second))
+
+		conns := addConns(2)
+		oldGen := conns[0].gen
+		tx, err := conns[0].BeginTx(ginkgoCtx, driver.TxOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		switched := make(chan struct{})
+		go func() {
+			defer close(switched)
+			cg.processNewValue("2nd-dsn")
+		}()
+		Eventually(func() bool { return oldGen.gate.Load() != nil }).Should(BeTrue())
+
+		held := make(chan error, 1)
+		go func() {
+			_, err := conns[1].ExecContext(ginkgoCtx, "INSERT", nil)
+			held <- err
+		}()
+		Consistently(held, 100*time.Millisecond).ShouldNot(Receive())
+		Consistently(switched, 100*time.Millisecond).ShouldNot(BeClosed())
+
+		// the open transaction is not held
+		_, err = conns[0].ExecContext(ginkgoCtx, "UPDATE", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(tx.Commit()).To(Succeed())
+
+		Eventually(switched).Should(BeClosed())
+		Eventually(held).Should(Receive(&err))
+		Expect(errors.Is(err, driver.ErrBadConn)).To(BeTrue())
+		Expect(errors.Is(err, ErrConnectionRotated)).To(BeTrue())
+
+		cg.mu.RLock()
+		Expect(cg.value).To(Equal("2nd-dsn"))
+		Expect(cg.gens).To(HaveLen(1))
+		cg.mu.RUnlock()
+		Expect(conns[0].conn.(*txConn).closed.Load()).To(BeTrue())
+
+		// new work on the old generation is not held anymore, but still rejected
+		_, err = conns[1].BeginTx(ginkgoCtx, driver.TxOptions{})
+		Expect(errors.Is(err, driver.ErrBadConn)).To(BeTrue())
+
+		Expect(testutil.CollectAndCount(metrics.HotloadQuiesceHoldHistogram)).To(Equal(1))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should stop holding a new conn when the caller's ctx is done", func(ginkgoCtx context.Context) {
+		cg.parseUrlValues(url.Values{switchoverMode: []string{"quiesce"}, quiesceTimeout: []string{"2s"}})
+		conns := addConns(1)
+		_, err := conns[0].BeginTx(ginkgoCtx, driver.TxOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		go cg.processNewValue("2nd-dsn")
+		Eventually(func() bool { return conns[0].gen.gate.Load() != nil }).Should(BeTrue())
+
+		ctx, cancel := context.WithTimeout(ginkgoCtx, 50*time.Millisecond)
+		defer cancel()
+		_, err = cg.Open(ctx)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should register a transaction before checking the quiesce gate", func(ginkgoCtx context.Context) {
+		cg.parseUrlValues(url.Values{switchoverMode: []string{"quiesce"}, quiesceTimeout: []string{"2s"}})
+		conns := addConns(2)
+		gen := conns[0].gen
+
+		// registered before the quiesce: the switchover waits for it
+		Expect(conns[0].holdBeginTx(ginkgoCtx)).To(Succeed())
+		switched := make(chan struct{})
+		go func() {
+			defer close(switched)
+			cg.processNewValue("2nd-dsn")
+		}()
+		Eventually(func() bool { return gen.gate.Load() != nil }).Should(BeTrue())
+		Consistently(switched, 100*time.Millisecond).ShouldNot(BeClosed())
+
+		// begun once quiesced: the registration is undone and the transaction is held
+		held := make(chan error, 1)
+		go func() {
+			_, err := conns[1].BeginTx(ginkgoCtx, driver.TxOptions{})
+			held <- err
+		}()
+		Consistently(held, 100*time.Millisecond).ShouldNot(Receive())
+		Expect(gen.openTxs()).To(Equal(1))
+
+		conns[0].endTx()
+		Eventually(switched).Should(BeClosed())
+		var err error
+		Eventually(held).Should(Receive(&err))
+		Expect(errors.Is(err, ErrConnectionRotated)).To(BeTrue())
+		Expect(gen.openTxs()).To(BeZero())
+	}, NodeTimeout(5*time.Second))
+
+	It("Should switch over after the quiesce timeout", func(ginkgoCtx context.Context) {
+		cg.parseUrlValues(url.Values{switchoverMode: []string{"quiesce"}, quiesceTimeout: []string{"200ms"}})
+
+		conns := addConns(1)
+		_, err := conns[0].BeginTx(ginkgoCtx, driver.TxOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		start := time.Now()
+		cg.processNewValue("2nd-dsn")
+		Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+		Expect(conns[0].ctx.Err()).To(HaveOccurred(), "the open transaction is killed")
+	}, NodeTimeout(5*time.Second))
+
+	It("Should not hold new work in the default switchover mode", func(ginkgoCtx context.Context) {
+		cg.parseUrlValues(url.Values{switchoverMode: []string{"bogus"}})
+		Expect(cg.switchoverMode).To(BeEmpty())
+
+		conns := addConns(2)
+		_, err := conns[0].BeginTx(ginkgoCtx, driver.TxOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		cg.processNewValue("2nd-dsn")
+		Expect(conns[0].gen.gate.Load()).To(BeNil())
+		_, err = conns[1].ExecContext(ginkgoCtx, "INSERT", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+	}, NodeTimeout(5*time.Second))
+})
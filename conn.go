@@ -5,25 +5,39 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"errors"
-	"fmt"
+	"log/slog"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/infobloxopen/hotload/logger"
-	"github.com/teivah/onecontext"
 )
 
 // managedConn wraps a sql/driver.Conn so that it can be closed by
 // a supervising context.
 type managedConn struct {
-	ctx       context.Context
-	dsn       string
-	redactDsn string
-	conn      driver.Conn
+	ctx context.Context
+	// cancel cancels ctx (only this conn), nil if ctx is not owned by the conn
+	cancel     context.CancelFunc
+	generation uint64
+	dsn        string
+	redactDsn  string
+	conn       driver.Conn
+	// gen is the generation of the conn, nil if the conn is not managed by a chanGroup
+	gen *generation
+	// inTx is true while a transaction is open on the conn
+	inTx atomic.Bool
+	// expiresAt is the time after which the conn is discarded (zero means never)
+	expiresAt time.Time
 	reset     bool
 	killed    bool
 	mu        sync.RWMutex
 
+	// in-flight operations, waited on (see waitIdle) when the generation is canceled
+	opsMu   sync.Mutex
+	ops     int
+	opsIdle chan struct{}
+
 	// callback function to be called after the connection is closed
 	afterClose func(*managedConn)
 
@@ -39,20 +53,31 @@ type managedConn struct {
 // package.
 // If the context is canceled by the user this method will call Tx.Rollback.
 func (c *managedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.holdBeginTx(ctx); err != nil {
+		return nil, err
+	}
+	tx, err := c.beginTxOnce(ctx, opts)
+	if err != nil {
+		c.endTx()
+		return nil, err
+	}
+	return &managedTx{tx: tx, conn: c, ctx: ctx}, nil
+}
+
+// beginTxOnce begins the transaction on the underlying conn,
+// once it is registered on the conn
+func (c *managedConn) beginTxOnce(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.beginOp()
+	defer c.endOp()
 	select {
 	case <-c.ctx.Done():
 		c.close()
-		return nil, driver.ErrBadConn
+		return nil, c.rotationErr(driver.ErrBadConn)
 	default:
 	}
 
 	if conn, ok := c.conn.(driver.ConnBeginTx); ok {
-		tx, err := conn.BeginTx(ctx, opts)
-		if err != nil {
-			return nil, err
-		}
-
-		return &managedTx{tx: tx, conn: c, ctx: ctx}, nil
+		return conn.BeginTx(ctx, opts)
 	}
 
 	// same as is defined in go sql package to call Begin method if the TxOptions are default
@@ -65,21 +90,22 @@ func (c *managedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (drive
 	}
 
 	tx, err := c.conn.Begin()
-	if err == nil {
-		select {
-		default:
-		case <-ctx.Done():
-			tx.Rollback()
-			return nil, ctx.Err()
-		}
+	if err != nil {
+		return nil, err
 	}
-
-	return tx, err
+	select {
+	default:
+	case <-ctx.Done():
+		tx.Rollback()
+		return nil, ctx.Err()
+	}
+	return tx, nil
 }
 
-func newManagedConn(ctx context.Context, dsn, redactDsn string, conn driver.Conn, afterClose func(*managedConn)) *managedConn {
+func newManagedConn(ctx context.Context, generation uint64, dsn, redactDsn string, conn driver.Conn, afterClose func(*managedConn)) *managedConn {
 	return &managedConn{
 		ctx:        ctx,
+		generation: generation,
 		dsn:        dsn,
 		redactDsn:  redactDsn,
 		conn:       conn,
@@ -88,7 +114,15 @@ func newManagedConn(ctx context.Context, dsn, redactDsn string, conn driver.Conn
 }
 
 func (c *managedConn) Exec(query string, args []driver.Value) (driver.Result, error) {
-	c.logf("managedConn.Exec", "Exec")
+	if err := c.hold(context.Background()); err != nil {
+		return nil, err
+	}
+	c.beginOp()
+	defer c.endOp()
+	logger.DebugC("managedConn.Exec", "Exec",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
 
 	connCtx, ok := c.conn.(driver.ExecerContext)
 	if ok {
@@ -99,14 +133,21 @@ func (c *managedConn) Exec(query string, args []driver.Value) (driver.Result, er
 			namedArgs[i].Value = args[i]
 		}
 		c.incExecStmtsCounter() //increment the exec counter to keep track of the number of exec calls
-		c.logf("managedConn.Exec", "calling underlying conn.ExecContext()")
-		return connCtx.ExecContext(c.ctx, query, namedArgs)
+		logger.DebugC("managedConn.Exec", "calling underlying conn.ExecContext()",
+			slog.String(logger.DsnKey, c.redactDsn),
+			slog.Uint64(logger.GenerationKey, c.generation),
+		)
+		res, err := connCtx.ExecContext(c.ctx, query, namedArgs)
+		return res, c.ctxErr(context.Background(), err)
 	}
 
 	connExr, ok := c.conn.(driver.Execer)
 	if ok {
 		c.incExecStmtsCounter() //increment the exec counter to keep track of the number of exec calls
-		c.logf("managedConn.Exec", "calling underlying conn.Exec()")
+		logger.DebugC("managedConn.Exec", "calling underlying conn.Exec()",
+			slog.String(logger.DsnKey, c.redactDsn),
+			slog.Uint64(logger.GenerationKey, c.generation),
+		)
 		return connExr.Exec(query, args)
 	}
 
@@ -114,15 +155,28 @@ func (c *managedConn) Exec(query string, args []driver.Value) (driver.Result, er
 }
 
 func (c *managedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
-	c.logf("managedConn.ExecContext", "ExecContext")
+	if err := c.hold(ctx); err != nil {
+		return nil, err
+	}
+	c.beginOp()
+	defer c.endOp()
+	logger.DebugC("managedConn.ExecContext", "ExecContext",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
 	conn, ok := c.conn.(driver.ExecerContext)
 	if !ok {
 		return nil, driver.ErrSkip
 	}
 	c.incExecStmtsCounter() //increment the exec counter to keep track of the number of exec calls
-	c.logf("managedConn.ExecContext", "calling underlying conn.ExecContext()")
-	mergedCtx, _ := onecontext.Merge(c.ctx, ctx)
-	return conn.ExecContext(mergedCtx, query, args)
+	logger.DebugC("managedConn.ExecContext", "calling underlying conn.ExecContext()",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
+	mergedCtx, release := mergeContext(ctx, c.ctx)
+	defer release()
+	res, err := conn.ExecContext(mergedCtx, query, args)
+	return res, c.ctxErr(ctx, err)
 }
 
 func (c *managedConn) CheckNamedValue(namedValue *driver.NamedValue) error {
@@ -134,7 +188,15 @@ func (c *managedConn) CheckNamedValue(namedValue *driver.NamedValue) error {
 }
 
 func (c *managedConn) Query(query string, args []driver.Value) (driver.Rows, error) {
-	c.logf("managedConn.Query", "Query")
+	if err := c.hold(context.Background()); err != nil {
+		return nil, err
+	}
+	c.beginOp()
+	defer c.endOp()
+	logger.DebugC("managedConn.Query", "Query",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
 
 	connCtx, ok := c.conn.(driver.QueryerContext)
 	if ok {
@@ -145,8 +207,12 @@ func (c *managedConn) Query(query string, args []driver.Value) (driver.Rows, err
 			namedArgs[i].Value = args[i]
 		}
 		c.incQueryStmtsCounter() //increment the query counter to keep track of the number of query calls
-		c.logf("managedConn.Query", "calling underlying conn.QueryContext()")
-		return connCtx.QueryContext(c.ctx, query, namedArgs)
+		logger.DebugC("managedConn.Query", "calling underlying conn.QueryContext()",
+			slog.String(logger.DsnKey, c.redactDsn),
+			slog.Uint64(logger.GenerationKey, c.generation),
+		)
+		rows, err := connCtx.QueryContext(c.ctx, query, namedArgs)
+		return rows, c.ctxErr(context.Background(), err)
 	}
 
 	connQyr, ok := c.conn.(driver.Queryer)
@@ -158,7 +224,10 @@ func (c *managedConn) Query(query string, args []driver.Value) (driver.Rows, err
 			namedArgs[i].Value = args[i]
 		}
 		c.incQueryStmtsCounter() //increment the query counter to keep track of the number of query calls
-		c.logf("managedConn.Query", "calling underlying conn.Query()")
+		logger.DebugC("managedConn.Query", "calling underlying conn.Query()",
+			slog.String(logger.DsnKey, c.redactDsn),
+			slog.Uint64(logger.GenerationKey, c.generation),
+		)
 		return connQyr.Query(query, args)
 	}
 
@@ -166,60 +235,122 @@ func (c *managedConn) Query(query string, args []driver.Value) (driver.Rows, err
 }
 
 func (c *managedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
-	c.logf("managedConn.QueryContext", "QueryContext")
+	if err := c.hold(ctx); err != nil {
+		return nil, err
+	}
+	c.beginOp()
+	defer c.endOp()
+	logger.DebugC("managedConn.QueryContext", "QueryContext",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
 	conn, ok := c.conn.(driver.QueryerContext)
 	if !ok {
 		return nil, driver.ErrSkip
 	}
 	c.incQueryStmtsCounter() //increment the query counter to keep track of the number of query calls
-	c.logf("managedConn.QueryContext", "calling underlying conn.QueryContext()")
-	mergedCtx, _ := onecontext.Merge(c.ctx, ctx)
-	return conn.QueryContext(mergedCtx, query, args)
+	logger.DebugC("managedConn.QueryContext", "calling underlying conn.QueryContext()",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
+	mergedCtx, release := mergeContext(ctx, c.ctx)
+	rows, err := conn.QueryContext(mergedCtx, query, args)
+	if err != nil || rows == nil {
+		release()
+		return rows, c.ctxErr(ctx, err)
+	}
+	// the merged context is released once the rows are closed
+	return newManagedRows(rows, release), nil
 }
 
 func (c *managedConn) Prepare(query string) (driver.Stmt, error) {
+	if err := c.hold(context.Background()); err != nil {
+		return nil, err
+	}
+	c.beginOp()
+	defer c.endOp()
 	select {
 	case <-c.ctx.Done():
-		c.logf("managedConn.Prepare", "ctx done, calling close()")
+		logger.DebugC("managedConn.Prepare", "ctx done, calling close()",
+			slog.String(logger.DsnKey, c.redactDsn),
+			slog.Uint64(logger.GenerationKey, c.generation),
+		)
 		c.close()
-		return nil, driver.ErrBadConn
+		return nil, c.rotationErr(driver.ErrBadConn)
 	default:
 	}
-	c.logf("managedConn.Prepare", "calling underlying Prepare()")
+	logger.DebugC("managedConn.Prepare", "calling underlying Prepare()",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
 	return c.conn.Prepare(query)
 }
 
 // Begin calls the underlying Begin method unless the supervising
 // context is closed.
 func (c *managedConn) Begin() (driver.Tx, error) {
+	if err := c.holdBeginTx(context.Background()); err != nil {
+		return nil, err
+	}
+	c.beginOp()
+	defer c.endOp()
 	select {
 	case <-c.ctx.Done():
+		c.endTx()
 		c.close()
-		return nil, driver.ErrBadConn
+		return nil, c.rotationErr(driver.ErrBadConn)
 	default:
 	}
-	return c.conn.Begin()
+	tx, err := c.conn.Begin()
+	if err != nil {
+		c.endTx()
+		return nil, err
+	}
+	return &managedTx{tx: tx, conn: c, ctx: context.Background()}, nil
 }
 
 func (c *managedConn) IsValid() bool {
 	select {
 	case <-c.ctx.Done():
-		c.logf("managedConn.IsValid", "ctx done, calling close()")
+		logger.DebugC("managedConn.IsValid", "ctx done, calling close()",
+			slog.String(logger.DsnKey, c.redactDsn),
+			slog.Uint64(logger.GenerationKey, c.generation),
+		)
 		c.close()
 		return false
 	default:
 	}
+	if c.expired() {
+		logger.DebugC("managedConn.IsValid", "max age exceeded",
+			slog.String(logger.DsnKey, c.redactDsn),
+			slog.Uint64(logger.GenerationKey, c.generation),
+		)
+		return false
+	}
 	s, ok := c.conn.(driver.Validator)
 	if !ok {
 		return true
 	}
-	c.logf("managedConn.IsValid", "calling underlying IsValid()")
+	logger.DebugC("managedConn.IsValid", "calling underlying IsValid()",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
 	return s.IsValid()
 }
 
 func (c *managedConn) ResetSession(ctx context.Context) error {
 	if c.GetReset() {
-		c.logf("managedConn.ResetSession", "already reset")
+		logger.DebugC("managedConn.ResetSession", "already reset",
+			slog.String(logger.DsnKey, c.redactDsn),
+			slog.Uint64(logger.GenerationKey, c.generation),
+		)
+		return c.rotationErr(driver.ErrBadConn)
+	}
+	if c.expired() {
+		logger.DebugC("managedConn.ResetSession", "max age exceeded",
+			slog.String(logger.DsnKey, c.redactDsn),
+			slog.Uint64(logger.GenerationKey, c.generation),
+		)
 		return driver.ErrBadConn
 	}
 
@@ -228,7 +359,10 @@ func (c *managedConn) ResetSession(ctx context.Context) error {
 		return nil
 	}
 
-	c.logf("managedConn.ResetSession", "calling underlying ResetSession()")
+	logger.DebugC("managedConn.ResetSession", "calling underlying ResetSession()",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
 	return s.ResetSession(ctx)
 }
 
@@ -240,7 +374,10 @@ func (c *managedConn) Close() error {
 	if err == nil {
 		c.killed = true
 	}
-	c.logf("managedConn.Close", "closed")
+	logger.DebugC("managedConn.Close", "closed",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
 
 	return err
 }
@@ -249,29 +386,116 @@ func (c *managedConn) close() error {
 	if c.afterClose != nil {
 		defer c.afterClose(c)
 	}
-	c.logf("managedConn.close", "calling underlying Close()")
+	if c.cancel != nil {
+		// release the conn's ctx
+		defer c.cancel()
+	}
+	logger.DebugC("managedConn.close", "calling underlying Close()",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
 	return c.conn.Close()
 }
 
 func (c *managedConn) GetReset() bool {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-	c.logf("managedConn.GetReset", "reset=%v", c.reset)
-	return c.reset
+	reset := c.reset
+	c.mu.RUnlock()
+	logger.DebugC("managedConn.GetReset", "get reset",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+		slog.Bool("reset", reset),
+	)
+	return reset
 }
 
 func (c *managedConn) Reset(v bool) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	c.reset = v
-	c.logf("managedConn.Reset", "reset=%v", v)
+	c.mu.Unlock()
+	logger.DebugC("managedConn.Reset", "set reset",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+		slog.Bool("reset", v),
+	)
 }
 
 func (c *managedConn) GetKill() bool {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
-	c.logf("managedConn.GetKill", "killed=%v", c.killed)
-	return c.killed
+	killed := c.killed
+	c.mu.RUnlock()
+	logger.DebugC("managedConn.GetKill", "get killed",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+		slog.Bool("killed", killed),
+	)
+	return killed
+}
+
+// expired returns true if the conn is older than its max age
+func (c *managedConn) expired() bool {
+	return !c.expiresAt.IsZero() && time.Now().After(c.expiresAt)
+}
+
+// beginOp registers an in-flight operation.
+// It must be called before checking whether the generation is canceled,
+// so that waitIdle (called after canceling) cannot miss the operation.
+func (c *managedConn) beginOp() {
+	c.opsMu.Lock()
+	c.ops++
+	c.opsMu.Unlock()
+}
+
+// endOp unregisters an in-flight operation, waking up waitIdle once there are none
+func (c *managedConn) endOp() {
+	c.opsMu.Lock()
+	c.ops--
+	if c.ops == 0 && c.opsIdle != nil {
+		close(c.opsIdle)
+		c.opsIdle = nil
+	}
+	c.opsMu.Unlock()
+}
+
+// waitIdle waits until there are no in-flight operations, or until ctx is done.
+// Returns false if ctx is done first.
+func (c *managedConn) waitIdle(ctx context.Context) bool {
+	c.opsMu.Lock()
+	if c.ops == 0 {
+		c.opsMu.Unlock()
+		return true
+	}
+	if c.opsIdle == nil {
+		c.opsIdle = make(chan struct{})
+	}
+	idle := c.opsIdle
+	c.opsMu.Unlock()
+
+	select {
+	case <-idle:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// rotationErr wraps err as a RotationError for this conn's generation
+func (c *managedConn) rotationErr(err error) error {
+	return &RotationError{
+		Generation: c.generation,
+		RedactDsn:  c.redactDsn,
+		Err:        err,
+	}
+}
+
+// ctxErr wraps err as a RotationError if the call failed after
+// this conn's generation was canceled (and not because the
+// caller's own ctx is done)
+func (c *managedConn) ctxErr(ctx context.Context, err error) error {
+	if err == nil || c.ctx.Err() == nil || ctx.Err() != nil {
+		return err
+	}
+	return c.rotationErr(err)
 }
 
 func (c *managedConn) incExecStmtsCounter() {
@@ -289,8 +513,3 @@ func (c *managedConn) incQueryStmtsCounter() {
 func (c *managedConn) resetQueryStmtsCounter() {
 	c.queryStmtsCounter.Store(0)
 }
-
-func (c *managedConn) logf(prefix, format string, args ...any) {
-	logPrefix := fmt.Sprintf("%s[%s]:", prefix, c.redactDsn)
-	logger.Logf(logPrefix, format, args...)
-}
@@ -0,0 +1,76 @@
+package hotload
+
+import (
+	"context"
+	"testing"
+
+	"github.com/infobloxopen/hotload/logger"
+)
+
+// withDebugLogging sets the global standard logger for the benchmark,
+// enabled (discarding) or disabled (DefaultLogger)
+func withDebugLogging(b *testing.B, enabled bool) {
+	prevLogger := logger.GetLogger()
+	b.Cleanup(func() {
+		logger.WithLogger(prevLogger)
+	})
+	if enabled {
+		logger.WithLogger(func(args ...any) {})
+	} else {
+		logger.WithLogger(nil)
+	}
+}
+
+func benchmarkManagedConn(b *testing.B, debugEnabled bool, fn func(mc *managedConn)) {
+	withDebugLogging(b, debugEnabled)
+	mc := newManagedConn(context.Background(), 0, "dsn", "redactDsn", mockDriverConn{}, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fn(mc)
+	}
+}
+
+func BenchmarkManagedConnExec(b *testing.B) {
+	exec := func(mc *managedConn) {
+		mc.Exec("INSERT INTO table (column) VALUES (1)", nil)
+	}
+	b.Run("debug=off", func(b *testing.B) { benchmarkManagedConn(b, false, exec) })
+	b.Run("debug=on", func(b *testing.B) { benchmarkManagedConn(b, true, exec) })
+}
+
+func BenchmarkManagedConnQuery(b *testing.B) {
+	query := func(mc *managedConn) {
+		mc.Query("SELECT 1", nil)
+	}
+	b.Run("debug=off", func(b *testing.B) { benchmarkManagedConn(b, false, query) })
+	b.Run("debug=on", func(b *testing.B) { benchmarkManagedConn(b, true, query) })
+}
+
+func BenchmarkManagedConnExecContext(b *testing.B) {
+	ctx := context.Background()
+	execContext := func(mc *managedConn) {
+		mc.ExecContext(ctx, "INSERT INTO table (column) VALUES (1)", nil)
+	}
+	b.Run("debug=off", func(b *testing.B) { benchmarkManagedConn(b, false, execContext) })
+	b.Run("debug=on", func(b *testing.B) { benchmarkManagedConn(b, true, execContext) })
+}
+
+func BenchmarkManagedConnQueryContext(b *testing.B) {
+	ctx := context.Background()
+	queryContext := func(mc *managedConn) {
+		mc.QueryContext(ctx, "SELECT 1", nil)
+	}
+	b.Run("debug=off", func(b *testing.B) { benchmarkManagedConn(b, false, queryContext) })
+	b.Run("debug=on", func(b *testing.B) { benchmarkManagedConn(b, true, queryContext) })
+}
+
+func BenchmarkManagedConnResetSession(b *testing.B) {
+	ctx := context.Background()
+	resetSession := func(mc *managedConn) {
+		mc.ResetSession(ctx)
+		mc.IsValid()
+	}
+	b.Run("debug=off", func(b *testing.B) { benchmarkManagedConn(b, false, resetSession) })
+	b.Run("debug=on", func(b *testing.B) { benchmarkManagedConn(b, true, resetSession) })
+}
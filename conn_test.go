@@ -3,14 +3,19 @@ package hotload
 import (
 	"context"
 	"database/sql/driver"
+	"errors"
 	"io"
+	"runtime"
 	"strings"
 	"sync"
+	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 
+	"github.com/infobloxopen/hotload/logger"
 	"github.com/infobloxopen/hotload/metrics"
 )
 
@@ -43,6 +48,245 @@ var _ = Describe("managedConn", func() {
 	})
 })
 
+// ctxDriverConn is a mock driver conn whose exec/query calls
+// block until the ctx is done, then fail with the ctx error
+type ctxDriverConn struct {
+	mockDriverConn
+}
+
+func (ctxDriverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (ctxDriverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+var _ = Describe("managedConn rotation errors", func() {
+	var mc *managedConn
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		mc = newManagedConn(ctx, 7, "dsn", "redactDsn", ctxDriverConn{}, nil)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	expectRotationErr := func(err error, origErr error) {
+		GinkgoHelper()
+		Expect(err).To(MatchError(ErrConnectionRotated))
+		Expect(err).To(MatchError(origErr))
+		var rotErr *RotationError
+		Expect(errors.As(err, &rotErr)).To(BeTrue())
+		Expect(rotErr.Generation).To(Equal(uint64(7)))
+		Expect(rotErr.RedactDsn).To(Equal("redactDsn"))
+	}
+
+	It("Should not return rotation errors while the generation is live", func() {
+		_, err := mc.BeginTx(context.Background(), driver.TxOptions{})
+		Expect(err).ShouldNot(HaveOccurred())
+		_, err = mc.Prepare("SELECT 1")
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Should return rotation errors wrapping driver.ErrBadConn once the generation is canceled", func() {
+		cancel()
+
+		_, err := mc.BeginTx(context.Background(), driver.TxOptions{})
+		expectRotationErr(err, driver.ErrBadConn)
+
+		_, err = mc.Prepare("SELECT 1")
+		expectRotationErr(err, driver.ErrBadConn)
+
+		mc.Reset(true)
+		err = mc.ResetSession(context.Background())
+		expectRotationErr(err, driver.ErrBadConn)
+	})
+
+	It("Should return rotation errors wrapping the context error once the generation is canceled", func() {
+		cancel()
+
+		_, err := mc.ExecContext(context.Background(), "SELECT 1", nil)
+		expectRotationErr(err, context.Canceled)
+
+		_, err = mc.QueryContext(context.Background(), "SELECT 1", nil)
+		expectRotationErr(err, context.Canceled)
+	})
+
+	It("Should not return rotation errors when the caller's context is canceled", func() {
+		cancel()
+
+		callerCtx, callerCancel := context.WithCancel(context.Background())
+		callerCancel()
+		_, err := mc.ExecContext(callerCtx, "SELECT 1", nil)
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(err).ToNot(MatchError(ErrConnectionRotated))
+	})
+})
+
+var _ = Describe("managedConn logging", func() {
+	It("Should not allocate on the per-query path when debug logging is disabled", func() {
+		prevLogger := logger.GetLogger()
+		logger.WithLogger(nil)
+		defer logger.WithLogger(prevLogger)
+		Expect(logger.DebugEnabled()).To(BeFalse())
+
+		ctx := context.Background()
+		mc := newManagedConn(ctx, 0, "dsn", "redactDsn", mockDriverConn{}, nil)
+		allocs := testing.AllocsPerRun(100, func() {
+			mc.Exec("INSERT INTO table (column) VALUES (1)", nil)
+			mc.Query("SELECT 1", nil)
+			mc.ResetSession(ctx)
+			mc.IsValid()
+			mc.GetReset()
+			mc.GetKill()
+		})
+		Expect(allocs).To(BeZero())
+	})
+})
+
+// capturingDriverConn is a mock driver conn that records
+// the ctx passed to its exec/query calls
+type capturingDriverConn struct {
+	mockDriverConn
+	ctxs chan context.Context
+}
+
+func (c capturingDriverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.ctxs <- ctx
+	return driver.RowsAffected(1), nil
+}
+
+func (c capturingDriverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.ctxs <- ctx
+	return mockRows{}, nil
+}
+
+type mockRows struct{}
+
+func (mockRows) Columns() []string {
+	return []string{"column"}
+}
+
+func (mockRows) Close() error {
+	return nil
+}
+
+func (mockRows) Next(dest []driver.Value) error {
+	return io.EOF
+}
+
+// customCtx is a context implementation unknown to the context package
+// (deriving from it requires a goroutine to propagate cancellation)
+type customCtx struct {
+	context.Context
+	done chan struct{}
+}
+
+func (c customCtx) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c customCtx) Err() error {
+	select {
+	case <-c.done:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+var _ = Describe("managedConn merged context", func() {
+	var mc *managedConn
+	var cancel context.CancelFunc
+	var ctxs chan context.Context
+
+	BeforeEach(func() {
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		ctxs = make(chan context.Context, 1)
+		mc = newManagedConn(ctx, 0, "dsn", "redactDsn", capturingDriverConn{ctxs: ctxs}, nil)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("Should release the merged context when ExecContext returns", func() {
+		_, err := mc.ExecContext(context.Background(), "INSERT INTO table (column) VALUES (1)", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		mergedCtx := <-ctxs
+		Expect(mergedCtx.Done()).To(BeClosed())
+		Expect(mc.ctx.Done()).NotTo(BeClosed())
+	})
+
+	It("Should cancel the merged context of open rows when the generation is canceled", func() {
+		rows, err := mc.QueryContext(context.Background(), "SELECT 1", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		mergedCtx := <-ctxs
+		Expect(mergedCtx.Done()).NotTo(BeClosed(), "rows may still use the context")
+
+		cancel()
+		Eventually(mergedCtx.Done()).Should(BeClosed())
+		Expect(rows.Close()).To(Succeed())
+	})
+
+	It("Should release the merged context when the rows are closed", func() {
+		rows, err := mc.QueryContext(context.Background(), "SELECT 1", nil)
+		Expect(err).ShouldNot(HaveOccurred())
+		mergedCtx := <-ctxs
+		Expect(rows.Close()).To(Succeed())
+		Expect(mergedCtx.Done()).To(BeClosed())
+		Expect(rows.Close()).To(Succeed(), "closing twice should be harmless")
+	})
+
+	It("Should not leak goroutines under sustained query load", func() {
+		ctx := customCtx{Context: context.Background(), done: make(chan struct{})}
+		defer close(ctx.done)
+
+		before := runtime.NumGoroutine()
+		for i := 0; i < 2000; i++ {
+			_, err := mc.ExecContext(ctx, "INSERT INTO table (column) VALUES (1)", nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-ctxs
+			rows, err := mc.QueryContext(ctx, "SELECT 1", nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			<-ctxs
+			Expect(rows.Close()).To(Succeed())
+		}
+		Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", before+2))
+	})
+})
+
+var _ = Describe("managedConn max age", func() {
+	It("Should be discarded by database/sql once the max age is exceeded", func() {
+		ctx := context.Background()
+		mc := newManagedConn(ctx, 0, "dsn", "redactDsn", mockDriverConn{}, nil)
+		mc.expiresAt = time.Now().Add(time.Hour)
+		Expect(mc.ResetSession(ctx)).To(Succeed())
+		Expect(mc.IsValid()).To(BeTrue())
+
+		mc.expiresAt = time.Now().Add(-time.Millisecond)
+		err := mc.ResetSession(ctx)
+		Expect(err).To(MatchError(driver.ErrBadConn))
+		Expect(err).NotTo(MatchError(ErrConnectionRotated))
+		Expect(mc.IsValid()).To(BeFalse())
+	})
+
+	It("Should never expire without a max age", func() {
+		ctx := context.Background()
+		mc := newManagedConn(ctx, 0, "dsn", "redactDsn", mockDriverConn{}, nil)
+		Expect(mc.ResetSession(ctx)).To(Succeed())
+		Expect(mc.IsValid()).To(BeTrue())
+	})
+})
+
 /**** Mocks for Prometheus Metrics ****/
 
 type mockDriverConn struct{}
@@ -130,7 +374,7 @@ var _ = Describe("PrometheusMetrics", func() {
 	`
 
 	It("Should emit the correct metrics", func() {
-		mc := newManagedConn(context.Background(), "dsn", "redactDsn", mockDriverConn{}, nil)
+		mc := newManagedConn(context.Background(), 0, "dsn", "redactDsn", mockDriverConn{}, nil)
 
 		ctx := ContextWithExecLabels(context.Background(), map[string]string{"grpc_method": "method_1", "grpc_service": "service_1"})
 
@@ -51,8 +51,10 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -76,8 +78,17 @@ type Strategy interface {
 }
 
 const forceKill = "forceKill"
+const cancelWaitTimeout = "cancelWaitTimeout"
+const maxConnAge = "maxConnAge"
+const maxConnAgeJitter = "maxConnAgeJitter"
+const warmConns = "warmConns"
+const warmTimeout = "warmTimeout"
 const driverOptions = "driverOptions"
 
+// DefaultCancelWaitTimeout is the default maximum time a dsn change waits for
+// the in-flight operations of canceled conns to finish, before closing them
+const DefaultCancelWaitTimeout = 1 * time.Second
+
 var (
 	ErrUnsupportedStrategy       = fmt.Errorf("unsupported hotload strategy")
 	ErrMalformedConnectionString = fmt.Errorf("malformed hotload connection string")
@@ -191,122 +202,207 @@ func Strategies() []string {
 	return list
 }
 
+// hotloadDriver is the hotload driver registered with database/sql
+var hotloadDriver = newHdriver(context.Background())
+
 func init() {
-	ctx := context.Background()
-	sql.Register("hotload", &hdriver{
-		ctx:    ctx,
-		cgroup: make(map[string]*chanGroup),
-	})
+	sql.Register("hotload", hotloadDriver)
 }
 
 // hdriver is the hotload driver.
 type hdriver struct {
 	ctx    context.Context
 	cgroup map[string]*chanGroup
-	mu     sync.Mutex
+	inits  map[string]*chanGroupInit
+	mu     sync.RWMutex
+}
+
+// chanGroupInit is an in-progress initialization of a chanGroup,
+// shared by all the callers opening the same name concurrently
+type chanGroupInit struct {
+	done   chan struct{}
+	cgroup *chanGroup
+	err    error
+}
+
+func newHdriver(ctx context.Context) *hdriver {
+	return &hdriver{
+		ctx:    ctx,
+		cgroup: make(map[string]*chanGroup),
+		inits:  make(map[string]*chanGroupInit),
+	}
 }
 
 // chanGroup represents a hotload location that is being monitored
 type chanGroup struct {
-	name          string
-	value         string
-	redactVal     string
-	newValChan    <-chan string
-	parentCtx     context.Context
-	ctx           context.Context
-	cancel        context.CancelFunc
-	sqlDriver     *driverInstance
-	mu            sync.RWMutex
-	forceKill     bool
-	conns         []*managedConn
-	prevCancel    context.CancelFunc
-	prevRedactVal string
-	prevConns     []*managedConn
+	name            string
+	value           string
+	redactVal       string
+	newValChan      <-chan string
+	parentCtx       context.Context
+	sqlDriver       *driverInstance
+	mu              sync.RWMutex
+	forceKill       bool
+	cancelWait      time.Duration
+	keepGenerations int
+	maxAge          time.Duration
+	maxAgeJitter    time.Duration
+	warmConns       int
+	warmTimeout     time.Duration
+	closeWindow     time.Duration
+	applyDelay      time.Duration
+	switchoverMode  string
+	quiesceTimeout  time.Duration
+	// gens are the live generations, oldest first (the last is the active one)
+	gens []*generation
+}
+
+func newChanGroup(parentCtx context.Context, name, value string, newValChan <-chan string, sqlDriver *driverInstance) *chanGroup {
+	redactVal := internal.RedactUrl(value)
+	cg := &chanGroup{
+		name:       name,
+		value:      value,
+		redactVal:  redactVal,
+		newValChan: newValChan,
+		parentCtx:  parentCtx,
+		sqlDriver:  sqlDriver,
+		gens:       []*generation{newGeneration(parentCtx, 0, redactVal)},
+	}
+	cg.updateGenerationMetrics()
+	return cg
+}
+
+// active returns the active generation, cg.mu MUST be held
+func (cg *chanGroup) active() *generation {
+	return cg.gens[len(cg.gens)-1]
+}
+
+// keep returns the number of live generations to keep
+func (cg *chanGroup) keep() int {
+	if cg.forceKill || cg.switchoverMode == switchoverQuiesce {
+		// previous generations are killed immediately
+		return 1
+	}
+	if cg.keepGenerations <= 0 {
+		return DefaultKeepGenerations
+	}
+	return cg.keepGenerations
+}
+
+// connExpiry returns the time after which a conn opened at openedAt is discarded,
+// randomly up to the max age jitter earlier so that conns opened together do not
+// all expire at the same time (zero if there is no max age)
+func (cg *chanGroup) connExpiry(openedAt time.Time) time.Time {
+	if cg.maxAge <= 0 {
+		return time.Time{}
+	}
+	return openedAt.Add(cg.maxAge - randDuration(cg.maxAgeJitter))
 }
 
 // monitor the location for changes
 func (cg *chanGroup) runLoop() {
 	for {
-		cg.logf("chanGroup.runLoop", "select waiting...")
+		logger.DebugC("chanGroup.runLoop", "select waiting...", slog.String(logger.NameKey, cg.name))
 		select {
 		case <-cg.parentCtx.Done():
-			cg.cancel()
-			cg.logf("chanGroup.runLoop", "parent context done, canceled chanGroup context, terminating")
+			cg.mu.RLock()
+			for _, gen := range cg.gens {
+				gen.cancel()
+			}
+			cg.mu.RUnlock()
+			logger.DebugC("chanGroup.runLoop", "parent context done, canceled generation contexts, terminating",
+				slog.String(logger.NameKey, cg.name),
+			)
 			return
 
 		case newValue, ok := <-cg.newValChan:
+			if ok {
+				newValue, ok = cg.delayApply(newValue)
+				if cg.parentCtx.Err() == nil {
+					cg.processNewValue(newValue)
+				}
+			}
 			if !ok {
-				cg.logf("chanGroup.runLoop", "newValChan closed, terminating")
+				ForgetEmitted(cg.newValChan)
+				logger.DebugC("chanGroup.runLoop", "newValChan closed, terminating",
+					slog.String(logger.NameKey, cg.name),
+				)
 				return
 			}
-			cg.processNewValue(newValue)
 		}
 	}
 }
 
 func (cg *chanGroup) processNewValue(newValue string) {
 	type oldInfo struct {
-		changedFlag       bool
-		prevPrevCancel    context.CancelFunc
-		prevPrevRedactVal string
-		prevPrevConns     []*managedConn
-		prevCancel        context.CancelFunc
-		prevRedactVal     string
-		prevConns         []*managedConn
+		// retired generations, to be canceled and closed
+		retired []*generation
+		// previous active generation, to be drained (unless retired)
+		draining *generation
+		// unused ready conns of the previous active generation, to be closed
+		unused []*managedConn
 	}
 
-	criticalSection := func() oldInfo {
-		cg.mu.Lock()
-		defer cg.mu.Unlock()
-
-		prevValue := cg.value
-		prevRedactVal := cg.redactVal
-
-		newRedactVal := internal.RedactUrl(newValue)
-		cg.logf("chanGroup.processNewValue", "old conn dsn: '%s'", prevRedactVal)
-		cg.logf("chanGroup.processNewValue", "new conn dsn: '%s'", newRedactVal)
+	emitTime := emittedTime(cg.newValChan, time.Now())
 
-		if newValue == prevValue {
-			// next update is the same, just ignore it
-			cg.logf("chanGroup.processNewValue", "conn dsn not changed")
-			return oldInfo{}
-		}
-		cg.logf("chanGroup.processNewValue", "conn dsn changed")
+	if !cg.acceptNewValue(newValue) {
+		return
+	}
+	newRedactVal := internal.RedactUrl(newValue)
 
-		result := oldInfo{
-			changedFlag:       true,
-			prevPrevConns:     cg.prevConns,
-			prevPrevCancel:    cg.prevCancel,
-			prevPrevRedactVal: cg.prevRedactVal,
-		}
+	// Open the warm conns with the new value before switching over,
+	// so they are ready to use as soon as the previous generation is canceled
+	warmed := cg.warmUp(newValue)
 
-		// Prepare shallow copy of existing connections,
-		// and reset new connections to zero
-		cg.prevConns = cg.conns
-		cg.conns = make([]*managedConn, 0)
+	// In quiesce mode, hold new work until the transactions of the active
+	// generation finished, the held work is released once it is retired
+	if gate := cg.quiesce(); gate != nil {
+		defer close(gate.done)
+	}
 
-		// Prepare copy of existing cancel ctx fn,
-		// and reset to new cancelable ctx
-		cg.prevCancel = cg.cancel
-		cg.ctx, cg.cancel = context.WithCancel(cg.parentCtx)
+	criticalSection := func() oldInfo {
+		cg.mu.Lock()
+		defer cg.mu.Unlock()
 
-		// Prepare copy of existing value,
-		// and reset to new value
-		cg.prevRedactVal = cg.redactVal
+		// The active generation becomes draining,
+		// and a new active generation is started with the new value
+		prevActive := cg.active()
+		prevActive.state = GenerationDraining
+		newActive := newGeneration(cg.parentCtx, prevActive.id+1, newRedactVal)
+		cg.gens = append(cg.gens, newActive)
 		cg.value = newValue
 		cg.redactVal = newRedactVal
+		for _, wc := range warmed {
+			manConn := cg.addMgdConn(newActive, wc.dsn, wc.redactDsn, wc.conn, wc.openedAt)
+			newActive.ready = append(newActive.ready, manConn)
+		}
 
-		result.prevConns = cg.prevConns
-		result.prevCancel = cg.prevCancel
-		result.prevRedactVal = cg.prevRedactVal
+		result := oldInfo{
+			draining: prevActive,
+			// Ready conns of the previous generation will never be used
+			unused: prevActive.ready,
+		}
+		prevActive.ready = nil
+
+		// Retire the oldest generations beyond the number of generations to keep
+		if n := len(cg.gens) - cg.keep(); n > 0 {
+			result.retired = append([]*generation{}, cg.gens[:n]...)
+			cg.gens = append([]*generation{}, cg.gens[n:]...)
+			for _, gen := range result.retired {
+				gen.state = GenerationClosed
+				if gen == prevActive {
+					// its conns (including the unused ones) are closed as retired conns
+					result.draining = nil
+					result.unused = nil
+				}
+			}
+		}
+		cg.updateGenerationMetrics()
 
 		return result
 	}
 
 	prev := criticalSection()
-	if !prev.changedFlag {
-		return
-	}
 
 	// Mutex MUST be unlocked at this point before continuing
 
@@ -314,58 +410,149 @@ func (cg *chanGroup) processNewValue(newValue string) {
 	metrics.IncHotloadChangeTotal(cg.name)
 	metrics.SetHotloadLastChangedTimestampSeconds(cg.name, float64(time.Now().Unix()))
 
-	// Canceling previous ctx can potentially cause other threads
+	// Canceling retired ctx can potentially cause other threads
 	// to call managedConn.Close(), which calls managedConn.afterClose(),
 	// which calls chanGroup.removeMgdConn(), which tries to lock mutex.
-	if cg.forceKill {
-		// Immediately cancel the previous dsn
-		if prev.prevCancel != nil {
-			prev.prevCancel()
-			cg.logf("chanGroup.processNewValue", "canceled context for previous dsn: '%s'", prev.prevRedactVal)
+	// We let the draining generations gracefully continue until they are retired.
+	if cg.closeWindow > 0 {
+		// Spread the closes of the retired conns over the close window,
+		// the retired generation ctx is canceled once they are all closed
+		for _, gen := range prev.retired {
+			logger.DebugC("chanGroup.processNewValue", "pacing reset/close of conns for retired generation",
+				slog.String(logger.NameKey, cg.name),
+				slog.String(logger.DsnKey, gen.redactVal),
+				slog.Uint64(logger.GenerationKey, gen.id),
+				slog.Duration(closeWindow, cg.closeWindow),
+			)
+			go cg.pace(cg.genConns(gen), cg.retireConn, gen.cancel)
 		}
 	} else {
-		// Immediately cancel the previous-previous dsn.
-		// We let the previous dsn to gracefully continue until the next dsn-change.
-		if prev.prevPrevCancel != nil {
-			prev.prevPrevCancel()
-			cg.logf("chanGroup.processNewValue", "canceled context for previous-previous dsn: '%s'", prev.prevPrevRedactVal)
+		cg.retireGenerations(prev.retired)
+	}
+
+	for _, c := range prev.unused {
+		// ignore errors from close
+		c.Close()
+	}
+
+	// Reset (but do not close) draining conns.
+	// We let the draining conns gracefully continue until they are retired.
+	if prev.draining != nil {
+		logger.DebugC("chanGroup.processNewValue", "reset conns for draining generation",
+			slog.String(logger.NameKey, cg.name),
+			slog.String(logger.DsnKey, prev.draining.redactVal),
+			slog.Uint64(logger.GenerationKey, prev.draining.id),
+		)
+		if cg.closeWindow > 0 {
+			go cg.pace(cg.genConns(prev.draining), cg.resetConn, nil)
+		} else {
+			for _, c := range cg.genConns(prev.draining) {
+				cg.resetConn(c)
+			}
 		}
 	}
 
-	// Yield to let other threads process cancel signal.
-	// Otherwise, there's a race and what happens (esp if forceKill=true)
-	// is that sometimes a db.Exec completes successfully (before cancel is processed),
-	// but db.Exec is later killed (closed) below because dsn changed, resulting in
+	metrics.IncHotloadSwitchoverTotal(cg.name, metrics.SwitchoverApplied)
+	metrics.ObserveHotloadSwitchoverLatencyHistogram(cg.name, time.Since(emitTime).Seconds())
+}
+
+// retireGenerations immediately cancels the retired generations,
+// then resets/closes their conns
+func (cg *chanGroup) retireGenerations(retired []*generation) {
+	var retiredConns []*managedConn
+	for _, gen := range retired {
+		gen.cancel()
+		logger.DebugC("chanGroup.processNewValue", "canceled context for retired generation",
+			slog.String(logger.NameKey, cg.name),
+			slog.String(logger.DsnKey, gen.redactVal),
+			slog.Uint64(logger.GenerationKey, gen.id),
+		)
+		retiredConns = append(retiredConns, cg.genConns(gen)...)
+	}
+
+	// Wait for the in-flight operations of the canceled conns to finish
+	// (they are aborted by the canceled context). Otherwise, there's a race
+	// (esp if forceKill=true) where a db.Exec that completed successfully
+	// after the cancel, could be killed (closed) below, resulting in
 	// db.Exec returning error.  This is inconsistent.
-	time.Sleep(1 * time.Millisecond)
+	cg.waitConnsIdle(retiredConns)
 
-	// Reset previous connections
+	// Reset/close retired connections
 	// Mutex MUST NOT be held by this point, because in the same thread,
-	// we will call managedConn.Close() if forceKill is true,
+	// we will call managedConn.Close(),
 	// which calls managedConn.afterClose(), which calls chanGroup.removeMgdConn(),
 	// which tries to lock mutex.
-	if cg.forceKill {
-		// Immediately reset/close previous conns
-		cg.logf("chanGroup.processNewValue", "reset/close conns for previous dsn: '%s'", prev.prevRedactVal)
-		for _, c := range prev.prevConns {
-			c.Reset(true)
-			// ignore errors from close
-			c.Close()
-		}
-	} else {
-		// Immediately close previous-previous conns.
-		// We let the previous conns to gracefully continue until the next dsn-change.
-		cg.logf("chanGroup.processNewValue", "close conns for previous-previous dsn: '%s'", prev.prevPrevRedactVal)
-		for _, c := range prev.prevPrevConns {
-			// ignore errors from close
-			c.Close()
-		}
+	for _, gen := range retired {
+		logger.DebugC("chanGroup.processNewValue", "reset/close conns for retired generation",
+			slog.String(logger.NameKey, cg.name),
+			slog.String(logger.DsnKey, gen.redactVal),
+			slog.Uint64(logger.GenerationKey, gen.id),
+		)
+	}
+	for _, c := range retiredConns {
+		c.Reset(true)
+		// ignore errors from close
+		c.Close()
+	}
+}
+
+// genConns returns a copy of the conns of the generation
+func (cg *chanGroup) genConns(gen *generation) []*managedConn {
+	cg.mu.RLock()
+	defer cg.mu.RUnlock()
+	return append([]*managedConn{}, gen.conns...)
+}
 
-		// Immediately reset (but do not close) previous conns.
-		// We let the previous conns to gracefully continue until the next dsn-change.
-		cg.logf("chanGroup.processNewValue", "reset conns for previous dsn: '%s'", prev.prevPrevRedactVal)
-		for _, c := range prev.prevConns {
-			c.Reset(true)
+// status returns a copy of the status of the live generations
+func (cg *chanGroup) status() *Status {
+	cg.mu.RLock()
+	defer cg.mu.RUnlock()
+	sts := &Status{
+		Name:        cg.name,
+		Generations: make([]GenerationStatus, 0, len(cg.gens)),
+	}
+	for _, gen := range cg.gens {
+		sts.Generations = append(sts.Generations, GenerationStatus{
+			Generation: gen.id,
+			RedactDsn:  gen.redactVal,
+			StartTime:  gen.startTime,
+			State:      gen.state,
+			Conns:      len(gen.conns),
+			ReadyConns: len(gen.ready),
+		})
+	}
+	return sts
+}
+
+// updateGenerationMetrics reports the live generations, cg.mu MUST be held
+func (cg *chanGroup) updateGenerationMetrics() {
+	metrics.DeleteHotloadGenerationConns(cg.name)
+	for _, gen := range cg.gens {
+		metrics.SetHotloadGenerationConns(cg.name, gen.idStr(), gen.state.String(), float64(len(gen.conns)))
+	}
+}
+
+// waitConnsIdle waits for the in-flight operations of the (canceled) conns
+// to finish, for up to the cancel wait timeout
+func (cg *chanGroup) waitConnsIdle(conns []*managedConn) {
+	if len(conns) <= 0 {
+		return
+	}
+	timeout := cg.cancelWait
+	if timeout <= 0 {
+		timeout = DefaultCancelWaitTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for i, c := range conns {
+		if !c.waitIdle(ctx) {
+			logger.ErrorC("chanGroup.waitConnsIdle", "timed out waiting for in-flight operations of canceled conns",
+				slog.String(logger.NameKey, cg.name),
+				slog.Duration("timeout", timeout),
+				slog.Int("busyConns", len(conns)-i),
+			)
+			return
 		}
 	}
 }
@@ -389,100 +576,353 @@ func mergeConnStringOptions(dsn string, options map[string]string) (string, erro
 	return u.String(), nil
 }
 
-func (cg *chanGroup) Open() (driver.Conn, error) {
+// maxDialAttempts is the number of times chanGroup.Open dials
+// when the connection string keeps changing while dialing
+const maxDialAttempts = 3
+
+// Open dials a new connection using the current connection string.
+// The mutex is not held while dialing, so that concurrent dials are not
+// serialized and dsn changes are not blocked behind a slow database.
+// A connection dialed on a superseded generation is closed and dialed again.
+// While the active generation is quiesced, the connection is held until
+// the switchover completes or ctx is done.
+func (cg *chanGroup) Open(ctx context.Context) (driver.Conn, error) {
+	if err := cg.holdOpen(ctx); err != nil {
+		return nil, err
+	}
+	if manConn := cg.takeReadyConn(); manConn != nil {
+		return manConn, nil
+	}
+
+	var genId uint64
+	var redactDsn string
+	for attempt := 1; attempt <= maxDialAttempts; attempt++ {
+		cg.mu.RLock()
+		value := cg.value
+		genId = cg.active().id
+		cg.mu.RUnlock()
+
+		dsn, err := mergeConnStringOptions(value, cg.sqlDriver.options)
+		if err != nil {
+			return nil, err
+		}
+		redactDsn = internal.RedactUrl(dsn)
+		conn, err := cg.sqlDriver.driver.Open(dsn)
+		if err != nil {
+			return conn, err
+		}
+
+		if manConn := cg.registerMgdConn(genId, dsn, redactDsn, conn); manConn != nil {
+			return manConn, nil
+		}
+
+		// ignore errors from close
+		conn.Close()
+		logger.DebugC("chanGroup.Open", "closed conn dialed on superseded generation",
+			slog.String(logger.NameKey, cg.name),
+			slog.String(logger.DsnKey, redactDsn),
+			slog.Uint64(logger.GenerationKey, genId),
+			slog.Int("attempt", attempt),
+		)
+	}
+
+	return nil, &RotationError{Generation: genId, RedactDsn: redactDsn, Err: driver.ErrBadConn}
+}
+
+// takeReadyConn takes a warm conn of the active generation, or returns nil if there are none
+func (cg *chanGroup) takeReadyConn() *managedConn {
 	cg.mu.Lock()
 	defer cg.mu.Unlock()
-	dsn, err := mergeConnStringOptions(cg.value, cg.sqlDriver.options)
-	if err != nil {
-		return nil, err
+	gen := cg.active()
+	if len(gen.ready) <= 0 {
+		return nil
 	}
-	redactDsn := internal.RedactUrl(dsn)
-	conn, err := cg.sqlDriver.driver.Open(dsn)
-	if err != nil {
-		return conn, err
+	manConn := gen.ready[0]
+	gen.ready = gen.ready[1:]
+	logger.DebugC("chanGroup.Open", "took ready managed conn", slog.String(logger.NameKey, cg.name),
+		slog.String(logger.DsnKey, manConn.redactDsn),
+		slog.Uint64(logger.GenerationKey, manConn.generation),
+	)
+	return manConn
+}
+
+// registerMgdConn wraps conn in a managed conn and registers it in the active generation,
+// unless the generation it was dialed on has been superseded (then returns nil).
+func (cg *chanGroup) registerMgdConn(genId uint64, dsn, redactDsn string, conn driver.Conn) *managedConn {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	gen := cg.active()
+	if genId != gen.id {
+		return nil
 	}
 
-	manConn := newManagedConn(cg.ctx, dsn, redactDsn, conn, cg.removeMgdConn)
-	cg.conns = append(cg.conns, manConn)
-	cg.logf("chanGroup.Open", "opened managed conn: '%s'", manConn.redactDsn)
+	manConn := cg.addMgdConn(gen, dsn, redactDsn, conn, time.Now())
+	cg.updateGenerationMetrics()
+	logger.DebugC("chanGroup.Open", "opened managed conn", slog.String(logger.NameKey, cg.name),
+		slog.String(logger.DsnKey, manConn.redactDsn),
+		slog.Uint64(logger.GenerationKey, manConn.generation),
+	)
+
+	return manConn
+}
 
-	return manConn, nil
+// addMgdConn wraps conn in a managed conn and adds it to the generation, cg.mu MUST be held.
+// Each managed conn has its own ctx (derived from the generation's ctx),
+// so that the conns of a retired generation can be canceled one by one (see closeWindow).
+func (cg *chanGroup) addMgdConn(gen *generation, dsn, redactDsn string, conn driver.Conn, openedAt time.Time) *managedConn {
+	ctx, cancel := context.WithCancel(gen.ctx)
+	manConn := newManagedConn(ctx, gen.id, dsn, redactDsn, conn, cg.removeMgdConn)
+	manConn.cancel = cancel
+	manConn.gen = gen
+	manConn.expiresAt = cg.connExpiry(openedAt)
+	gen.conns = append(gen.conns, manConn)
+	return manConn
 }
 
 func (cg *chanGroup) removeMgdConn(conn *managedConn) {
 	cg.mu.Lock()
 	defer cg.mu.Unlock()
-	for i, c := range cg.conns {
-		if c == conn {
-			cg.conns = append(cg.conns[:i], cg.conns[i+1:]...)
-			cg.logf("chanGroup.removeMgdConn", "%d: removed: '%s'", i, conn.redactDsn)
-			return
+	for _, gen := range cg.gens {
+		if gen.id != conn.generation {
+			continue
+		}
+		for i, c := range gen.conns {
+			if c == conn {
+				gen.conns = append(gen.conns[:i], gen.conns[i+1:]...)
+				cg.updateGenerationMetrics()
+				logger.DebugC("chanGroup.removeMgdConn", "removed managed conn",
+					slog.String(logger.NameKey, cg.name),
+					slog.Int("index", i),
+					slog.String(logger.DsnKey, conn.redactDsn),
+					slog.Uint64(logger.GenerationKey, conn.generation),
+				)
+				return
+			}
 		}
 	}
 }
 
 func (cg *chanGroup) parseUrlValues(vs url.Values) {
-	cg.logf("chanGroup.parseUrlValues", "values: %s", vs)
+	logger.DebugC("chanGroup.parseUrlValues", "parsing url values",
+		slog.String(logger.NameKey, cg.name),
+		slog.Any("values", vs),
+	)
 	v, ok := vs[forceKill]
 	if ok && len(v) > 0 {
 		firstValue := v[0]
 		cg.forceKill = firstValue == "true"
-		cg.logf("chanGroup.parseUrlValues", "forceKill set to true")
+		logger.DebugC("chanGroup.parseUrlValues", "forceKill set",
+			slog.String(logger.NameKey, cg.name),
+			slog.Bool(forceKill, cg.forceKill),
+		)
+	}
+	if d, ok := cg.parseDurationValue(vs, cancelWaitTimeout); ok {
+		cg.cancelWait = d
+	}
+	if d, ok := cg.parseDurationValue(vs, maxConnAge); ok {
+		cg.maxAge = d
+	}
+	if d, ok := cg.parseDurationValue(vs, maxConnAgeJitter); ok {
+		if d >= cg.maxAge {
+			logger.ErrorC("chanGroup.parseUrlValues", "maxConnAgeJitter must be less than maxConnAge, ignored",
+				slog.String(logger.NameKey, cg.name),
+				slog.Duration(maxConnAgeJitter, d),
+				slog.Duration(maxConnAge, cg.maxAge),
+			)
+		} else {
+			cg.maxAgeJitter = d
+		}
+	}
+	if n, ok := cg.parseIntValue(vs, keepGenerations); ok {
+		cg.keepGenerations = n
+	}
+	if n, ok := cg.parseIntValue(vs, warmConns); ok {
+		cg.warmConns = n
+	}
+	if d, ok := cg.parseDurationValue(vs, warmTimeout); ok {
+		cg.warmTimeout = d
+	}
+	if d, ok := cg.parseDurationValue(vs, closeWindow); ok {
+		cg.closeWindow = d
+	}
+	if d, ok := cg.parseDurationValue(vs, applyDelay); ok {
+		cg.applyDelay = d
+	}
+	if v, ok := vs[switchoverMode]; ok && len(v) > 0 {
+		switch v[0] {
+		case switchoverGraceful, switchoverQuiesce:
+			cg.switchoverMode = v[0]
+			logger.DebugC("chanGroup.parseUrlValues", "switchoverMode set",
+				slog.String(logger.NameKey, cg.name),
+				slog.String(switchoverMode, v[0]),
+			)
+		default:
+			logger.ErrorC("chanGroup.parseUrlValues", "invalid switchoverMode, ignored",
+				slog.String(logger.NameKey, cg.name),
+				slog.String(switchoverMode, v[0]),
+			)
+		}
+	}
+	if d, ok := cg.parseDurationValue(vs, quiesceTimeout); ok {
+		cg.quiesceTimeout = d
+	}
+}
+
+// parseIntValue parses the positive integer url value of key,
+// returns false if not set or invalid
+func (cg *chanGroup) parseIntValue(vs url.Values, key string) (int, bool) {
+	v, ok := vs[key]
+	if !ok || len(v) <= 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v[0])
+	if err != nil || n <= 0 {
+		logger.ErrorC("chanGroup.parseUrlValues", "invalid integer, ignored",
+			slog.String(logger.NameKey, cg.name),
+			slog.String(key, v[0]),
+		)
+		return 0, false
+	}
+	logger.DebugC("chanGroup.parseUrlValues", "integer set",
+		slog.String(logger.NameKey, cg.name),
+		slog.Int(key, n),
+	)
+	return n, true
+}
+
+// parseDurationValue parses the positive duration url value of key,
+// returns false if not set or invalid
+func (cg *chanGroup) parseDurationValue(vs url.Values, key string) (time.Duration, bool) {
+	v, ok := vs[key]
+	if !ok || len(v) <= 0 {
+		return 0, false
+	}
+	d, err := time.ParseDuration(v[0])
+	if err != nil || d <= 0 {
+		logger.ErrorC("chanGroup.parseUrlValues", "invalid duration, ignored",
+			slog.String(logger.NameKey, cg.name),
+			slog.String(key, v[0]),
+		)
+		return 0, false
 	}
+	logger.DebugC("chanGroup.parseUrlValues", "duration set",
+		slog.String(logger.NameKey, cg.name),
+		slog.Duration(key, d),
+	)
+	return d, true
 }
 
 func (h *hdriver) Open(name string) (driver.Conn, error) {
-	uri, err := url.Parse(name)
+	cgroup, err := h.chanGroup(name)
 	if err != nil {
 		return nil, err
 	}
-	mu.Lock()
-	defer mu.Unlock()
+	return cgroup.Open(context.Background())
+}
+
+// OpenConnector implements driver.DriverContext, so that database/sql
+// passes the ctx of the callers to the connector when opening conns
+func (h *hdriver) OpenConnector(name string) (driver.Connector, error) {
+	return &hconnector{driver: h, name: name}, nil
+}
 
-	// look up in the chan group
+// chanGroup returns the chanGroup for name, creating it if needed
+func (h *hdriver) chanGroup(name string) (*chanGroup, error) {
+	h.mu.RLock()
 	cgroup, ok := h.cgroup[name]
-	if !ok {
-		strategy, ok := strategies[uri.Scheme]
-		if !ok {
-			return nil, ErrUnsupportedStrategy
-		}
-		sqlDriver, ok := sqlDrivers[uri.Host]
-		if !ok {
-			return nil, ErrUnknownDriver
-		}
-		queryParams := uri.Query()
-		value, newValChan, err := strategy.Watch(h.ctx, uri.Path, queryParams.Encode())
-		if err != nil {
-			return nil, err
-		}
-		ctx, cancel := context.WithCancel(h.ctx)
-		cgroup = &chanGroup{
-			name:       name,
-			value:      value,
-			redactVal:  internal.RedactUrl(value),
-			newValChan: newValChan,
-			parentCtx:  h.ctx,
-			ctx:        ctx,
-			cancel:     cancel,
-			sqlDriver:  sqlDriver,
-			conns:      make([]*managedConn, 0),
-		}
-		cgroup.parseUrlValues(queryParams)
-		h.cgroup[name] = cgroup
-		h.logf("hotload", "new chanGroup: '%s'", name)
-		go cgroup.runLoop()
+	h.mu.RUnlock()
+	if ok {
+		return cgroup, nil
+	}
+	return h.initChanGroup(name)
+}
+
+// hconnector opens the conns of a hotload connection string
+type hconnector struct {
+	driver *hdriver
+	name   string
+}
+
+// Connect implements driver.Connector
+func (c *hconnector) Connect(ctx context.Context) (driver.Conn, error) {
+	cgroup, err := c.driver.chanGroup(c.name)
+	if err != nil {
+		return nil, err
 	}
-	return cgroup.Open()
+	return cgroup.Open(ctx)
 }
 
-func (h *hdriver) logf(prefix, format string, args ...any) {
-	logPrefix := fmt.Sprintf("%s:", prefix)
-	logger.Logf(logPrefix, format, args...)
+// Driver implements driver.Connector
+func (c *hconnector) Driver() driver.Driver {
+	return c.driver
 }
 
-func (cg *chanGroup) logf(prefix, format string, args ...any) {
-	logPrefix := fmt.Sprintf("%s[%s]:", prefix, cg.name)
-	logger.Logf(logPrefix, format, args...)
+// initChanGroup returns the chanGroup for name, creating it if needed.
+// Concurrent callers for the same name share a single initialization,
+// and no lock is held while the strategy is watching, so a slow strategy
+// does not block other names nor the registration of drivers and strategies.
+func (h *hdriver) initChanGroup(name string) (*chanGroup, error) {
+	h.mu.Lock()
+	if cgroup, ok := h.cgroup[name]; ok {
+		h.mu.Unlock()
+		return cgroup, nil
+	}
+	if init, ok := h.inits[name]; ok {
+		h.mu.Unlock()
+		<-init.done
+		return init.cgroup, init.err
+	}
+	init := &chanGroupInit{done: make(chan struct{})}
+	h.inits[name] = init
+	h.mu.Unlock()
+
+	init.cgroup, init.err = h.newChanGroup(name)
+
+	h.mu.Lock()
+	if init.err == nil {
+		h.cgroup[name] = init.cgroup
+	}
+	delete(h.inits, name)
+	h.mu.Unlock()
+	close(init.done)
+
+	if init.err != nil {
+		return nil, init.err
+	}
+	logger.Debug("new chanGroup",
+		logger.Component("hotload"),
+		slog.String(logger.NameKey, name),
+	)
+	go init.cgroup.runLoop()
+	return init.cgroup, nil
+}
+
+// newChanGroup watches the location of name and returns a new chanGroup for it
+func (h *hdriver) newChanGroup(name string) (*chanGroup, error) {
+	uri, err := url.Parse(name)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	strategy, strategyOk := strategies[uri.Scheme]
+	sqlDriver, sqlDriverOk := sqlDrivers[uri.Host]
+	mu.RUnlock()
+	if !strategyOk {
+		return nil, ErrUnsupportedStrategy
+	}
+	if !sqlDriverOk {
+		return nil, ErrUnknownDriver
+	}
+
+	queryParams := uri.Query()
+	value, newValChan, err := strategy.Watch(h.ctx, uri.Path, queryParams.Encode())
+	if err != nil {
+		return nil, err
+	}
+	cgroup := newChanGroup(h.ctx, name, value, newValChan, sqlDriver)
+	cgroup.parseUrlValues(queryParams)
+	return cgroup, nil
 }
 
 // Deprecated: Use logger.WithLogger() instead, retained for backwards-compatibility only
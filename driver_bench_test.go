@@ -0,0 +1,41 @@
+package hotload
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+// slowDialDriver simulates the network round-trips of dialing a database
+type slowDialDriver struct {
+	latency time.Duration
+}
+
+func (d slowDialDriver) Open(name string) (driver.Conn, error) {
+	time.Sleep(d.latency)
+	return &testConn{}, nil
+}
+
+// BenchmarkChanGroupOpenParallel measures the throughput of concurrent dials
+// of the same chanGroup (dials must not be serialized by the chanGroup mutex)
+func BenchmarkChanGroupOpenParallel(b *testing.B) {
+	withDebugLogging(b, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Cleanup(cancel)
+	cg := newChanGroup(ctx, "fsnotify://postgres/tmp/mydsn.txt", "1st-dsn", nil, &driverInstance{driver: slowDialDriver{latency: time.Millisecond}})
+
+	b.SetParallelism(16)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			conn, err := cg.Open(context.Background())
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			conn.Close()
+		}
+	})
+}
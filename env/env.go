@@ -0,0 +1,342 @@
+// Package env implements hotload strategies reading the connection string
+// from an environment variable ("env") or from a key of a .env file ("dotenv").
+// The sources are read again on an OS signal (set by the reloadSignal query param)
+// or when Reload is called, and the changes are sent to the watches.
+//
+// Import it to register the "env" and "dotenv" strategies:
+//
+//	import _ "github.com/infobloxopen/hotload/env"
+//
+//	db, err := sql.Open("hotload", "env://postgres/DB_DSN?reloadSignal=SIGHUP")
+//	db, err := sql.Open("hotload", "dotenv://postgres/etc/app/.env?key=DB_DSN&reloadSignal=SIGHUP")
+package env
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/infobloxopen/hotload"
+	"github.com/infobloxopen/hotload/internal/fanout"
+	"github.com/infobloxopen/hotload/logger"
+	"github.com/pkg/errors"
+)
+
+var (
+	defaultEnv    = NewStrategy()
+	defaultDotenv = NewDotenvStrategy()
+)
+
+func init() {
+	hotload.RegisterStrategy("env", defaultEnv)
+	hotload.RegisterStrategy("dotenv", defaultDotenv)
+}
+
+const (
+	keyParam          = "key"
+	reloadSignalParam = "reloadSignal"
+)
+
+var (
+	// ErrNotFound is returned by Watch when the variable (or .env key) is not set
+	ErrNotFound = errors.New("variable not set")
+	// ErrMissingKey is returned by the dotenv strategy Watch when the key query param is missing
+	ErrMissingKey = errors.New("missing key query param")
+	// ErrInvalidSignal is returned by Watch when the reloadSignal query param is not supported
+	ErrInvalidSignal = errors.New("unsupported reload signal")
+)
+
+// Reload reads again the sources of all the watches of the registered
+// "env" and "dotenv" strategies, and sends the values that changed
+func Reload() {
+	defaultEnv.Reload()
+	defaultDotenv.Reload()
+}
+
+// Strategy implements the hotload Strategy interface by reading
+// environment variables or .env files.
+type Strategy struct {
+	name string
+	// read reads the value of the source of a watch
+	read func(src source) (string, error)
+
+	mu      sync.Mutex
+	watches *fanout.Watches
+	sources map[string]source
+	// signals are the reload signals of the watches, by source and query
+	signals map[string]map[string]os.Signal
+	// sigChans are the channels the reload signals are listened on, one per signal
+	// so that a signal can be stopped without the others being unlistened meanwhile
+	sigChans map[os.Signal]chan os.Signal
+}
+
+// source is the variable (or the key of the .env file) read by a watch
+type source struct {
+	path string
+	key  string
+}
+
+// NewStrategy implements a hotload strategy that reads the environment variable
+// named by the path (eg: "env://postgres/DB_DSN").
+func NewStrategy() *Strategy {
+	return newStrategy("env", readEnv)
+}
+
+// NewDotenvStrategy implements a hotload strategy that reads the key (set by the key query
+// param) of the .env file at the path (eg: "dotenv://postgres/etc/app/.env?key=DB_DSN").
+func NewDotenvStrategy() *Strategy {
+	return newStrategy("dotenv", readDotenv)
+}
+
+func newStrategy(name string, read func(src source) (string, error)) *Strategy {
+	s := &Strategy{
+		name:     name,
+		read:     read,
+		sources:  make(map[string]source),
+		signals:  make(map[string]map[string]os.Signal),
+		sigChans: make(map[os.Signal]chan os.Signal),
+	}
+	s.watches = fanout.New(name, s.onCloseWatch)
+	return s
+}
+
+// Watch implements the hotload.Strategy interface.
+func (s *Strategy) Watch(ctx context.Context, pth string, pathQry string) (value string, values <-chan string, err error) {
+	pathQry = strings.TrimSpace(pathQry)
+	vs, err := url.ParseQuery(pathQry)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "could not parse query %v", pathQry)
+	}
+	src := source{path: path.Clean(pth)}
+	if s.name == "env" {
+		src.path = strings.TrimLeft(src.path, "/")
+	} else {
+		src.key = vs.Get(keyParam)
+		if src.key == "" {
+			return "", nil, ErrMissingKey
+		}
+	}
+	var sig os.Signal
+	if v := vs.Get(reloadSignalParam); v != "" {
+		if sig, err = parseSignal(v); err != nil {
+			return "", nil, err
+		}
+	}
+
+	srcKey := src.String()
+	value, values, err = s.watches.Watch(srcKey, pathQry, func() (string, error) {
+		val, err := s.read(src)
+		if err != nil {
+			return "", err
+		}
+		s.mu.Lock()
+		s.sources[srcKey] = src
+		s.mu.Unlock()
+		return val, nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	if sig != nil {
+		s.mu.Lock()
+		if _, ok := s.signals[srcKey]; !ok {
+			s.signals[srcKey] = make(map[string]os.Signal)
+		}
+		s.signals[srcKey][pathQry] = sig
+		s.notifySignals()
+		s.mu.Unlock()
+	}
+	return value, values, nil
+}
+
+// CloseWatch implements the hotload.Strategy interface.
+// Closes the specified watch by closing its update channel.
+func (s *Strategy) CloseWatch(pth string, pathQry string) error {
+	pathQry = strings.TrimSpace(pathQry)
+	vs, err := url.ParseQuery(pathQry)
+	if err != nil {
+		return errors.Wrapf(err, "could not parse query %v", pathQry)
+	}
+	src := source{path: path.Clean(pth)}
+	if s.name == "env" {
+		src.path = strings.TrimLeft(src.path, "/")
+	} else {
+		src.key = vs.Get(keyParam)
+	}
+	s.watches.CloseWatch(src.String(), pathQry)
+	return nil
+}
+
+// onCloseWatch forgets the reload signal of the closed query,
+// and the source once its last query is closed
+func (s *Strategy) onCloseWatch(srcKey, pathQry string, last bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sigs, ok := s.signals[srcKey]; ok {
+		delete(sigs, pathQry)
+		if len(sigs) <= 0 {
+			delete(s.signals, srcKey)
+		}
+		s.notifySignals()
+	}
+	if last {
+		delete(s.sources, srcKey)
+	}
+}
+
+// Close implements the hotload.Strategy interface.
+// Closes this strategy by no longer listening to the reload signals
+// and closing all the update channels.
+func (s *Strategy) Close() {
+	s.mu.Lock()
+	s.sources = make(map[string]source)
+	s.signals = make(map[string]map[string]os.Signal)
+	s.notifySignals()
+	s.mu.Unlock()
+	s.watches.Close()
+}
+
+// Reload reads again the sources of all the watches, and sends the values that changed
+func (s *Strategy) Reload() {
+	s.mu.Lock()
+	sources := make(map[string]source, len(s.sources))
+	for srcKey, src := range s.sources {
+		sources[srcKey] = src
+	}
+	s.mu.Unlock()
+
+	for srcKey, src := range sources {
+		val, err := s.read(src)
+		if err != nil {
+			logger.ErrorC(s.name+".Reload", "reload failed",
+				slog.String(logger.PathKey, srcKey),
+				logger.Err(err),
+			)
+			continue
+		}
+		if curVal, ok := s.watches.Value(srcKey); !ok || val == curVal {
+			continue
+		}
+		logger.DebugC(s.name+".Reload", "value changed", slog.String(logger.PathKey, srcKey))
+		s.watches.Set(srcKey, val)
+	}
+}
+
+// notifySignals listens to the reload signals of the watches, s.mu MUST be held
+func (s *Strategy) notifySignals() {
+	uniq := make(map[os.Signal]struct{})
+	for _, sigs := range s.signals {
+		for _, sig := range sigs {
+			uniq[sig] = struct{}{}
+		}
+	}
+
+	for sig := range uniq {
+		if _, ok := s.sigChans[sig]; ok {
+			continue
+		}
+		sigChan := make(chan os.Signal, 1)
+		s.sigChans[sig] = sigChan
+		go s.signalLoop(sigChan)
+		signal.Notify(sigChan, sig)
+	}
+	for sig, sigChan := range s.sigChans {
+		if _, ok := uniq[sig]; ok {
+			continue
+		}
+		signal.Stop(sigChan)
+		close(sigChan)
+		delete(s.sigChans, sig)
+	}
+}
+
+func (s *Strategy) signalLoop(sigChan <-chan os.Signal) {
+	for sig := range sigChan {
+		logger.DebugC(s.name+".signalLoop", "reload signal received", slog.String("signal", sig.String()))
+		s.Reload()
+	}
+}
+
+// parseSignal parses a reload signal name, with or without the "SIG" prefix (eg: "SIGHUP" or "HUP")
+func parseSignal(name string) (os.Signal, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := reloadSignals[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignal, name)
+	}
+	return sig, nil
+}
+
+func (src source) String() string {
+	if src.key == "" {
+		return src.path
+	}
+	return src.path + "#" + src.key
+}
+
+// readEnv reads the environment variable named by the source path
+func readEnv(src source) (string, error) {
+	val, ok := os.LookupEnv(src.path)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, src.path)
+	}
+	return strings.TrimSpace(val), nil
+}
+
+// readDotenv reads the source key of the .env file at the source path
+func readDotenv(src source) (string, error) {
+	bs, err := os.ReadFile(src.path)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not read %v", src.path)
+	}
+	val, ok := parseDotenv(bs)[src.key]
+	if !ok {
+		return "", fmt.Errorf("%w: %s in %s", ErrNotFound, src.key, src.path)
+	}
+	return strings.TrimSpace(val), nil
+}
+
+// parseDotenv parses the KEY=VALUE lines of a .env file. Blank lines and comments
+// are ignored, the "export " prefix is allowed, and values may be single or double quoted
+// (an unquoted value ends at a " #" comment).
+func parseDotenv(bs []byte) map[string]string {
+	vals := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(bs))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		val = strings.TrimSpace(val)
+		switch {
+		case len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"':
+			val = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(val[1 : len(val)-1])
+		case len(val) >= 2 && val[0] == '\'' && val[len(val)-1] == '\'':
+			val = val[1 : len(val)-1]
+		default:
+			if i := strings.Index(val, " #"); i >= 0 {
+				val = strings.TrimSpace(val[:i])
+			}
+		}
+		vals[key] = val
+	}
+	return vals
+}
@@ -0,0 +1,57 @@
+//go:build !windows
+
+package env
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reload signal", func() {
+	const name = "HOTLOAD_ENV_SIGNAL_TEST_DSN"
+
+	It("Should reload on the reload signal", func(ctx context.Context) {
+		s := NewStrategy()
+		DeferCleanup(s.Close)
+		DeferCleanup(os.Unsetenv, name)
+
+		Expect(os.Setenv(name, "a")).To(Succeed())
+		_, values, err := s.Watch(ctx, "/"+name, "reloadSignal=USR1")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.Setenv(name, "b")).To(Succeed())
+		Expect(syscall.Kill(os.Getpid(), syscall.SIGUSR1)).To(Succeed())
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should keep listening to a signal while the others change", func(ctx context.Context) {
+		s := NewStrategy()
+		DeferCleanup(s.Close)
+		DeferCleanup(os.Unsetenv, name)
+
+		Expect(os.Setenv(name, "a")).To(Succeed())
+		_, values, err := s.Watch(ctx, "/"+name, "reloadSignal=USR1")
+		Expect(err).ToNot(HaveOccurred())
+		s.mu.Lock()
+		usr1Chan := s.sigChans[syscall.SIGUSR1]
+		s.mu.Unlock()
+
+		_, values2, err := s.Watch(ctx, "/"+name, "reloadSignal=USR2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.CloseWatch("/"+name, "reloadSignal=USR2")).To(Succeed())
+		Eventually(values2).Should(BeClosed())
+		s.mu.Lock()
+		Expect(s.sigChans).To(HaveLen(1))
+		Expect(s.sigChans[syscall.SIGUSR1]).To(BeIdenticalTo(usr1Chan), "USR1 was not unlistened")
+		s.mu.Unlock()
+
+		Expect(os.Setenv(name, "b")).To(Succeed())
+		Expect(syscall.Kill(os.Getpid(), syscall.SIGUSR1)).To(Succeed())
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+})
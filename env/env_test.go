@@ -0,0 +1,157 @@
+package env
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Strategy", func() {
+	Context("env", func() {
+		const name = "HOTLOAD_ENV_TEST_DSN"
+
+		var s *Strategy
+
+		BeforeEach(func() {
+			s = NewStrategy()
+			DeferCleanup(s.Close)
+			DeferCleanup(os.Unsetenv, name)
+		})
+
+		It("Should return the trimmed value and send the changes on Reload", func(ctx context.Context) {
+			Expect(os.Setenv(name, " a \n")).To(Succeed())
+			value, values, err := s.Watch(ctx, "/"+name, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("a"))
+
+			Expect(os.Setenv(name, "b")).To(Succeed())
+			s.Reload()
+			Eventually(values).Should(Receive(Equal("b")))
+		}, NodeTimeout(5*time.Second))
+
+		It("Should not send unchanged values", func(ctx context.Context) {
+			Expect(os.Setenv(name, "a")).To(Succeed())
+			_, values, err := s.Watch(ctx, "/"+name, "")
+			Expect(err).ToNot(HaveOccurred())
+
+			s.Reload()
+			Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+		}, NodeTimeout(5*time.Second))
+
+		It("Should keep the value while the variable is unset", func(ctx context.Context) {
+			Expect(os.Setenv(name, "a")).To(Succeed())
+			_, values, err := s.Watch(ctx, "/"+name, "")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(os.Unsetenv(name)).To(Succeed())
+			s.Reload()
+			Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+
+			Expect(os.Setenv(name, "b")).To(Succeed())
+			s.Reload()
+			Eventually(values).Should(Receive(Equal("b")))
+		}, NodeTimeout(5*time.Second))
+
+		It("Should fail to watch an unset variable", func(ctx context.Context) {
+			_, _, err := s.Watch(ctx, "/"+name, "")
+			Expect(errors.Is(err, ErrNotFound)).To(BeTrue())
+			Expect(s.sources).To(BeEmpty())
+		})
+
+		It("Should fail to watch with an unsupported reload signal", func(ctx context.Context) {
+			Expect(os.Setenv(name, "a")).To(Succeed())
+			_, _, err := s.Watch(ctx, "/"+name, "reloadSignal=SIGKILL")
+			Expect(errors.Is(err, ErrInvalidSignal)).To(BeTrue())
+		})
+
+		It("Should forget the source and the reload signal once the last watch is closed", func(ctx context.Context) {
+			Expect(os.Setenv(name, "a")).To(Succeed())
+			_, values, err := s.Watch(ctx, "/"+name, "reloadSignal=HUP")
+			Expect(err).ToNot(HaveOccurred())
+			s.mu.Lock()
+			Expect(s.sigChans).To(HaveLen(1))
+			s.mu.Unlock()
+
+			Expect(s.CloseWatch("/"+name, "reloadSignal=HUP")).To(Succeed())
+			Eventually(values).Should(BeClosed())
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			Expect(s.sources).To(BeEmpty())
+			Expect(s.signals).To(BeEmpty())
+			Expect(s.sigChans).To(BeEmpty())
+		}, NodeTimeout(5*time.Second))
+	})
+
+	Context("dotenv", func() {
+		var pth string
+		var s *Strategy
+
+		writeFile := func(content string) {
+			Expect(os.WriteFile(pth, []byte(content), 0o600)).To(Succeed())
+		}
+
+		BeforeEach(func() {
+			pth = filepath.Join(GinkgoT().TempDir(), ".env")
+			s = NewDotenvStrategy()
+			DeferCleanup(s.Close)
+		})
+
+		It("Should return the value of the key and send the changes on Reload", func(ctx context.Context) {
+			writeFile("OTHER=x\nDB_DSN=a\n")
+			value, values, err := s.Watch(ctx, pth, "key=DB_DSN")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("a"))
+
+			writeFile("OTHER=y\nDB_DSN=a\n")
+			s.Reload()
+			Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+
+			writeFile("OTHER=y\nDB_DSN=b\n")
+			s.Reload()
+			Eventually(values).Should(Receive(Equal("b")))
+		}, NodeTimeout(5*time.Second))
+
+		It("Should watch the keys of the same file separately", func(ctx context.Context) {
+			writeFile("DB_DSN=a\nRO_DSN=c\n")
+			_, values1, err := s.Watch(ctx, pth, "key=DB_DSN")
+			Expect(err).ToNot(HaveOccurred())
+			value, values2, err := s.Watch(ctx, pth, "key=RO_DSN")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("c"))
+
+			writeFile("DB_DSN=b\nRO_DSN=c\n")
+			s.Reload()
+			Eventually(values1).Should(Receive(Equal("b")))
+			Consistently(values2, 100*time.Millisecond).ShouldNot(Receive())
+		}, NodeTimeout(5*time.Second))
+
+		It("Should fail to watch without a key", func(ctx context.Context) {
+			writeFile("DB_DSN=a\n")
+			_, _, err := s.Watch(ctx, pth, "")
+			Expect(errors.Is(err, ErrMissingKey)).To(BeTrue())
+		})
+
+		It("Should fail to watch a missing key", func(ctx context.Context) {
+			writeFile("DB_DSN=a\n")
+			_, _, err := s.Watch(ctx, pth, "key=RO_DSN")
+			Expect(errors.Is(err, ErrNotFound)).To(BeTrue())
+		})
+	})
+
+	DescribeTable("parseDotenv",
+		func(content, want string) {
+			Expect(parseDotenv([]byte(content))).To(HaveKeyWithValue("DB_DSN", want))
+		},
+		Entry("plain", "DB_DSN=host=db user=app", "host=db user=app"),
+		Entry("export", "# comment\n\nexport DB_DSN=a", "a"),
+		Entry("inline comment", "DB_DSN=a # comment", "a"),
+		Entry("double quoted", `DB_DSN="a # b\n\"c\""`, "a # b\n\"c\""),
+		Entry("single quoted", `DB_DSN='a # b\n'`, `a # b\n`),
+		Entry("last one wins", "DB_DSN=a\nDB_DSN=b", "b"),
+	)
+})
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package env
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals are the supported reload signals
+var reloadSignals = map[string]os.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
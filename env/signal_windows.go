@@ -0,0 +1,13 @@
+//go:build windows
+
+package env
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals are the supported reload signals
+var reloadSignals = map[string]os.Signal{
+	"SIGHUP": syscall.SIGHUP,
+}
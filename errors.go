@@ -0,0 +1,39 @@
+package hotload
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrConnectionRotated is matched (using errors.Is) by the errors returned
+// from a managed connection whose generation was canceled by hotload
+// because the connection string changed (see RotationError).
+var ErrConnectionRotated = errors.New("hotload: connection rotated")
+
+// RotationError is returned by a managed connection whose generation was
+// canceled by hotload because the connection string changed.
+// It unwraps to the original error (eg: driver.ErrBadConn or context.Canceled),
+// so database/sql still discards the connection and retries on a new one.
+type RotationError struct {
+	// Generation of the connection string the connection was opened with
+	Generation uint64
+	// RedactDsn is the redacted connection string the connection was opened with
+	RedactDsn string
+	// Err is the original error
+	Err error
+}
+
+// Error implements the error interface
+func (e *RotationError) Error() string {
+	return fmt.Sprintf("hotload: connection rotated (generation=%d, dsn='%s'): %v", e.Generation, e.RedactDsn, e.Err)
+}
+
+// Unwrap returns the original error
+func (e *RotationError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true for ErrConnectionRotated
+func (e *RotationError) Is(target error) bool {
+	return target == ErrConnectionRotated
+}
@@ -0,0 +1,431 @@
+// Package etcd implements a hotload strategy for connection strings stored in etcd.
+// The strategy gets the value of the key (the path), then watches the key with the
+// etcd v3 watch API and sends its new values. The etcd client resumes a broken watch
+// after the last revision received (failing over to the next endpoint); a watch that
+// can not be resumed is reopened with a backoff, and the value is read again if the
+// last revision received was compacted meanwhile.
+//
+// Import it to register the "etcd" strategy:
+//
+//	import _ "github.com/infobloxopen/hotload/etcd"
+//
+//	db, err := sql.Open("hotload", "etcd://postgres/app/db/dsn?endpoints=etcd-0:2379,etcd-1:2379&caFile=/etc/etcd/ca.pem")
+package etcd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infobloxopen/hotload"
+	"github.com/infobloxopen/hotload/internal/backoff"
+	"github.com/infobloxopen/hotload/internal/fanout"
+	"github.com/infobloxopen/hotload/logger"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+func init() {
+	hotload.RegisterStrategy("etcd", NewStrategy())
+}
+
+const (
+	endpointsParam    = "endpoints"
+	usernameParam     = "username"
+	passwordFileParam = "passwordFile"
+	caFileParam       = "caFile"
+	certFileParam     = "certFile"
+	keyFileParam      = "keyFile"
+	maxBackoffParam   = "maxBackoff"
+
+	// minRetryDelay is the delay before reopening a failing watch,
+	// doubled for each consecutive failure up to maxBackoff
+	minRetryDelay = 250 * time.Millisecond
+)
+
+// keyParams are the query params configuring the connection to etcd, the watches
+// of a key with the same keyParams share the same watch
+var keyParams = []string{
+	endpointsParam, usernameParam, passwordFileParam, caFileParam, certFileParam, keyFileParam, maxBackoffParam,
+}
+
+var (
+	// ErrWatchClosed is logged when the watch is closed by etcd (eg: its member lost the leader)
+	ErrWatchClosed = errors.New("watch closed")
+	// ErrNotFound is returned by Watch when the key does not exist
+	ErrNotFound = errors.New("key not found")
+	// ErrMissingKey is returned by Watch when the path is empty
+	ErrMissingKey = errors.New("missing key")
+	// ErrInvalidEndpoint is returned by Watch when an endpoint is invalid
+	ErrInvalidEndpoint = errors.New("invalid etcd endpoint")
+	// ErrInvalidTLS is returned by Watch when the TLS query params are invalid
+	ErrInvalidTLS = errors.New("invalid TLS config")
+	// ErrMissingPasswordFile is returned by Watch when the username query param is set without passwordFile
+	ErrMissingPasswordFile = errors.New("missing passwordFile query param")
+	// ErrInvalidMaxBackoff is returned by Watch when the maxBackoff query param is invalid
+	ErrInvalidMaxBackoff = errors.New("invalid maxBackoff")
+)
+
+// NewStrategy implements a hotload strategy that watches etcd keys.
+func NewStrategy(opts ...Option) *Strategy {
+	defOpts := newDefaultOptions()
+	for _, opt := range opts {
+		opt(defOpts)
+	}
+	s := &Strategy{
+		endpoints:  defOpts.endpoints,
+		maxBackoff: defOpts.maxBackoff,
+		timeout:    defOpts.timeout,
+		keyWatches: make(map[string]*keyWatch),
+	}
+	s.watches = fanout.New("etcd", s.onCloseWatch)
+	return s
+}
+
+// Strategy implements the hotload Strategy interface by watching etcd keys.
+type Strategy struct {
+	mu         sync.Mutex
+	endpoints  []string
+	maxBackoff time.Duration
+	timeout    time.Duration
+	watches    *fanout.Watches
+	keyWatches map[string]*keyWatch
+}
+
+// keyConfig is the etcd key and connection config of a watch
+type keyConfig struct {
+	path         string
+	endpoints    []string
+	username     string
+	passwordFile string
+	caFile       string
+	certFile     string
+	keyFile      string
+	maxBackoff   time.Duration
+	// key identifies the watch of the etcd key with this config
+	key string
+}
+
+// keyWatch is the state of a watched etcd key config
+type keyWatch struct {
+	cfg    *keyConfig
+	client *clientv3.Client
+	cancel context.CancelFunc
+
+	// only accessed by the watch loop (once started)
+	value string
+	// revision is the last revision received, the watch is reopened after it
+	revision int64
+	failures int
+}
+
+// Watch implements the hotload.Strategy interface.
+func (s *Strategy) Watch(ctx context.Context, pth string, pathQry string) (value string, values <-chan string, err error) {
+	pathQry = strings.TrimSpace(pathQry)
+	cfg, err := s.parseConfig(pth, pathQry)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return s.watches.Watch(cfg.key, pathQry, func() (string, error) {
+		c, err := s.newClient(cfg)
+		if err != nil {
+			return "", err
+		}
+		kw := &keyWatch{cfg: cfg, client: c}
+		getCtx, cancel := context.WithTimeout(ctx, s.timeout)
+		resp, err := c.Get(getCtx, cfg.path)
+		cancel()
+		if err != nil {
+			c.Close()
+			return "", errors.Wrapf(err, "could not get %v", cfg.path)
+		}
+		if len(resp.Kvs) == 0 {
+			c.Close()
+			return "", fmt.Errorf("%w: %s", ErrNotFound, cfg.path)
+		}
+		kw.value = strings.TrimSpace(string(resp.Kvs[0].Value))
+		kw.revision = resp.Header.Revision
+		watchCtx, cancel := context.WithCancel(ctx)
+		kw.cancel = cancel
+		s.mu.Lock()
+		s.keyWatches[cfg.key] = kw
+		s.mu.Unlock()
+		go s.watchLoop(watchCtx, kw)
+		return kw.value, nil
+	})
+}
+
+// CloseWatch implements the hotload.Strategy interface.
+// Closes the specified watch by closing its update channel,
+// and closes the etcd watch once all the watches of the key are closed.
+func (s *Strategy) CloseWatch(pth string, pathQry string) error {
+	pathQry = strings.TrimSpace(pathQry)
+	cfg, err := s.parseConfig(pth, pathQry)
+	if err != nil {
+		return err
+	}
+	s.watches.CloseWatch(cfg.key, pathQry)
+	return nil
+}
+
+// onCloseWatch closes the etcd watch once its last query is closed
+func (s *Strategy) onCloseWatch(key, pathQry string, last bool) {
+	if !last {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kw, ok := s.keyWatches[key]
+	if !ok {
+		return
+	}
+	kw.cancel()
+	delete(s.keyWatches, key)
+	logger.DebugC("etcd.processWatchClosure", "stopped watching key", slog.String(logger.PathKey, kw.cfg.path))
+}
+
+// Close implements the hotload.Strategy interface.
+// Closes this strategy by closing all the etcd watches
+// and closing all the update channels.
+func (s *Strategy) Close() {
+	s.mu.Lock()
+	keyWatches := s.keyWatches
+	s.keyWatches = make(map[string]*keyWatch)
+	s.mu.Unlock()
+	for _, kw := range keyWatches {
+		kw.cancel()
+	}
+	s.watches.Close()
+}
+
+// parseConfig parses the etcd key (the path) and the connection config (the query params)
+func (s *Strategy) parseConfig(pth, pathQry string) (*keyConfig, error) {
+	vs, err := url.ParseQuery(pathQry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse query %v", pathQry)
+	}
+	if strings.Trim(pth, "/") == "" {
+		return nil, ErrMissingKey
+	}
+	cfg := &keyConfig{
+		path:         pth,
+		username:     vs.Get(usernameParam),
+		passwordFile: vs.Get(passwordFileParam),
+		caFile:       vs.Get(caFileParam),
+		certFile:     vs.Get(certFileParam),
+		keyFile:      vs.Get(keyFileParam),
+		maxBackoff:   s.maxBackoff,
+	}
+	if (cfg.certFile == "") != (cfg.keyFile == "") {
+		return nil, fmt.Errorf("%w: %s and %s must be set together", ErrInvalidTLS, certFileParam, keyFileParam)
+	}
+	if cfg.username != "" && cfg.passwordFile == "" {
+		return nil, ErrMissingPasswordFile
+	}
+	if cfg.maxBackoff, err = backoff.ParseDuration(vs, maxBackoffParam, cfg.maxBackoff, ErrInvalidMaxBackoff); err != nil {
+		return nil, err
+	}
+
+	endpoints := s.endpoints
+	if v := vs.Get(endpointsParam); v != "" {
+		endpoints = strings.Split(v, ",")
+	}
+	useTLS := cfg.caFile != "" || cfg.certFile != ""
+	for _, ep := range endpoints {
+		endpoint, err := parseEndpoint(ep, useTLS)
+		if err != nil {
+			return nil, err
+		}
+		cfg.endpoints = append(cfg.endpoints, endpoint)
+	}
+	if len(cfg.endpoints) == 0 {
+		return nil, fmt.Errorf("%w: no endpoint", ErrInvalidEndpoint)
+	}
+
+	keyVals := make(url.Values)
+	for _, param := range keyParams {
+		if v, ok := vs[param]; ok {
+			keyVals[param] = v
+		}
+	}
+	cfg.key = cfg.path + "?" + keyVals.Encode()
+	return cfg, nil
+}
+
+// parseEndpoint parses an endpoint (eg: "https://etcd-0:2379"), whose scheme defaults
+// to https with the TLS query params, and to http otherwise
+func parseEndpoint(ep string, useTLS bool) (string, error) {
+	ep = strings.TrimSpace(ep)
+	if !strings.Contains(ep, "://") {
+		if useTLS {
+			ep = "https://" + ep
+		} else {
+			ep = "http://" + ep
+		}
+	}
+	u, err := url.Parse(ep)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidEndpoint, ep)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// newClient returns an etcd client with the config. The client cert is read again
+// at each TLS handshake, so a rotated cert is used by the next connections.
+func (s *Strategy) newClient(cfg *keyConfig) (*clientv3.Client, error) {
+	etcdCfg := clientv3.Config{
+		Endpoints:   cfg.endpoints,
+		DialTimeout: s.timeout,
+		Logger:      zap.NewNop(),
+	}
+	if cfg.username != "" {
+		bs, err := os.ReadFile(cfg.passwordFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read %v", cfg.passwordFile)
+		}
+		etcdCfg.Username = cfg.username
+		etcdCfg.Password = strings.TrimSpace(string(bs))
+	}
+	if cfg.caFile != "" || cfg.certFile != "" {
+		tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.caFile != "" {
+			bs, err := os.ReadFile(cfg.caFile)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not read %v", cfg.caFile)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(bs) {
+				return nil, fmt.Errorf("%w: no certificate in %s", ErrInvalidTLS, cfg.caFile)
+			}
+			tlsCfg.RootCAs = pool
+		}
+		if cfg.certFile != "" {
+			if _, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidTLS, err)
+			}
+			tlsCfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				cert, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile)
+				if err != nil {
+					return nil, err
+				}
+				return &cert, nil
+			}
+		}
+		etcdCfg.TLS = tlsCfg
+	}
+	c, err := clientv3.New(etcdCfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not connect to etcd")
+	}
+	return c, nil
+}
+
+// nextDelay returns the delay before reopening the watch, doubled
+// for each consecutive failure up to maxBackoff
+func (kw *keyWatch) nextDelay() time.Duration {
+	if kw.failures == 0 {
+		return 0
+	}
+	return backoff.Delay(minRetryDelay, kw.cfg.maxBackoff, kw.failures-1)
+}
+
+// watchLoop watches the key, reopening the watch when it fails,
+// until ctx is done (the key is no longer watched)
+func (s *Strategy) watchLoop(ctx context.Context, kw *keyWatch) {
+	defer kw.client.Close()
+	logger.DebugC("etcd.watchLoop", "started", slog.String(logger.PathKey, kw.cfg.path))
+	for {
+		if err := s.watchKey(ctx, kw); err != nil && ctx.Err() == nil {
+			if kw.failures == 0 {
+				// only log the first failure, until the watch is reopened
+				logger.ErrorC("etcd.watchLoop", "watch failed",
+					slog.String(logger.PathKey, kw.cfg.path),
+					logger.Err(err),
+				)
+			}
+			kw.failures++
+		}
+		timer := time.NewTimer(kw.nextDelay())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.DebugC("etcd.watchLoop", "terminated", slog.String(logger.PathKey, kw.cfg.path))
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// watchKey watches the key from the revision after the last one received, and sends
+// its new values until the watch is closed. Returns nil once the value is read again,
+// because the revisions after the last one received were compacted.
+func (s *Strategy) watchKey(ctx context.Context, kw *keyWatch) error {
+	// the watch of a member without leader (eg: partitioned) is closed, to be reopened
+	// on another member, rather than waiting for events that may never come
+	watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	wch := kw.client.Watch(watchCtx, kw.cfg.path, clientv3.WithRev(kw.revision+1), clientv3.WithCreatedNotify())
+	for resp := range wch {
+		if resp.CompactRevision > 0 {
+			logger.DebugC("etcd.watchKey", "revisions compacted, reading the value again",
+				slog.String(logger.PathKey, kw.cfg.path),
+				slog.Int64("compactRevision", resp.CompactRevision),
+			)
+			return s.resync(ctx, kw)
+		}
+		if err := resp.Err(); err != nil {
+			return err
+		}
+		if resp.Created && kw.failures > 0 {
+			logger.DebugC("etcd.watchKey", "watch recovered", slog.String(logger.PathKey, kw.cfg.path))
+			kw.failures = 0
+		}
+		for _, ev := range resp.Events {
+			kw.revision = max(kw.revision, ev.Kv.ModRevision)
+			if ev.Type == clientv3.EventTypeDelete {
+				logger.ErrorC("etcd.watchKey", "key deleted, keeping the last value", slog.String(logger.PathKey, kw.cfg.path))
+				continue
+			}
+			s.update(kw, string(ev.Kv.Value))
+		}
+	}
+	return ErrWatchClosed
+}
+
+// resync reads the value again, and the watch is reopened after the current revision
+func (s *Strategy) resync(ctx context.Context, kw *keyWatch) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	resp, err := kw.client.Get(ctx, kw.cfg.path)
+	if err != nil {
+		return errors.Wrapf(err, "could not get %v", kw.cfg.path)
+	}
+	kw.revision = resp.Header.Revision
+	kw.failures = 0
+	if len(resp.Kvs) == 0 {
+		logger.ErrorC("etcd.resync", "key deleted, keeping the last value", slog.String(logger.PathKey, kw.cfg.path))
+		return nil
+	}
+	s.update(kw, string(resp.Kvs[0].Value))
+	return nil
+}
+
+// update sends the value to the watches if it changed
+func (s *Strategy) update(kw *keyWatch, value string) {
+	value = strings.TrimSpace(value)
+	if value == kw.value {
+		return
+	}
+	logger.DebugC("etcd.update", "value changed", slog.String(logger.PathKey, kw.cfg.path))
+	kw.value = value
+	s.watches.Set(kw.cfg.key, value)
+}
@@ -0,0 +1,76 @@
+package etcd
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultEndpoints are the default etcd endpoints (when the hotload connection
+	// string has no endpoints query param), ETCD_ENDPOINTS (comma separated) if set
+	DefaultEndpoints = defaultEndpoints()
+
+	// DefaultMaxBackoff is the default longest delay between the reconnections of a failing watch
+	// (when the hotload connection string has no maxBackoff query param)
+	DefaultMaxBackoff = time.Second * 30
+
+	// DefaultTimeout is the default timeout of the connection and the requests to etcd (except the watches)
+	DefaultTimeout = time.Second * 10
+)
+
+func defaultEndpoints() []string {
+	if endpoints := os.Getenv("ETCD_ENDPOINTS"); endpoints != "" {
+		return strings.Split(endpoints, ",")
+	}
+	return []string{"http://127.0.0.1:2379"}
+}
+
+type etcdOptions struct {
+	endpoints  []string
+	maxBackoff time.Duration
+	timeout    time.Duration
+}
+
+type Option func(*etcdOptions)
+
+func newDefaultOptions() *etcdOptions {
+	return &etcdOptions{
+		endpoints:  DefaultEndpoints,
+		maxBackoff: DefaultMaxBackoff,
+		timeout:    DefaultTimeout,
+	}
+}
+
+// WithEndpoints is the option to set the default etcd endpoints
+func WithEndpoints(endpoints ...string) Option {
+	return func(opts *etcdOptions) {
+		if len(endpoints) == 0 {
+			opts.endpoints = DefaultEndpoints
+		} else {
+			opts.endpoints = endpoints
+		}
+	}
+}
+
+// WithMaxBackoff is the option to set the default longest delay between the reconnections of a failing watch
+func WithMaxBackoff(maxBackoff time.Duration) Option {
+	return func(opts *etcdOptions) {
+		if maxBackoff <= 0 {
+			opts.maxBackoff = DefaultMaxBackoff
+		} else {
+			opts.maxBackoff = maxBackoff
+		}
+	}
+}
+
+// WithTimeout is the option to set the timeout of the connection and the requests to etcd (except the watches)
+func WithTimeout(timeout time.Duration) Option {
+	return func(opts *etcdOptions) {
+		if timeout <= 0 {
+			opts.timeout = DefaultTimeout
+		} else {
+			opts.timeout = timeout
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// the specs connecting to an etcd server are in the etcd/internal/etcdtest module,
+// so that this module does not require the etcd server
+
+const dsnKey = "/app/db/dsn"
+
+var _ = Describe("Strategy", func() {
+	var s *Strategy
+
+	BeforeEach(func() {
+		s = NewStrategy(WithEndpoints("127.0.0.1:2379"))
+		DeferCleanup(s.Close)
+	})
+
+	DescribeTable("Should fail to watch invalid params",
+		func(pth, qry string, wantErr error) {
+			_, _, err := s.Watch(context.Background(), pth, qry)
+			Expect(errors.Is(err, wantErr)).To(BeTrue())
+		},
+		Entry("no key", "/", "", ErrMissingKey),
+		Entry("endpoint", dsnKey, "endpoints=ftp://etcd:2379", ErrInvalidEndpoint),
+		Entry("cert without key", dsnKey, "certFile=/tmp/cert.pem", ErrInvalidTLS),
+		Entry("username without password", dsnKey, "username=app", ErrMissingPasswordFile),
+		Entry("maxBackoff", dsnKey, "maxBackoff=soon", ErrInvalidMaxBackoff),
+	)
+
+	DescribeTable("nextDelay",
+		func(failures int, want time.Duration) {
+			kw := &keyWatch{cfg: &keyConfig{maxBackoff: 30 * time.Second}, failures: failures}
+			Expect(kw.nextDelay()).To(Equal(want))
+		},
+		Entry("not failing", 0, time.Duration(0)),
+		Entry("failing", 3, time.Second),
+		Entry("failing for long", 100, 30*time.Second),
+	)
+})
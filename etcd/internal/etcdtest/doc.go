@@ -0,0 +1,5 @@
+// Package etcdtest tests the etcd strategy against an embedded etcd server.
+// It is a separate module, so that the hotload module requires the etcd client only:
+//
+//	cd etcd/internal/etcdtest && go test ./...
+package etcdtest
@@ -0,0 +1,355 @@
+package etcdtest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infobloxopen/hotload/etcd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+)
+
+// freeAddr returns a free local address
+func freeAddr() string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startEtcd starts an embedded etcd server (serving TLS with tlsInfo if set),
+// and returns its client endpoint
+func startEtcd(tlsInfo *transport.TLSInfo) string {
+	cfg := embed.NewConfig()
+	cfg.Dir = GinkgoT().TempDir()
+	cfg.LogLevel = "error"
+	clientURL := url.URL{Scheme: "http", Host: freeAddr()}
+	if tlsInfo != nil {
+		clientURL.Scheme = "https"
+		cfg.ClientTLSInfo = *tlsInfo
+	}
+	peerURL := url.URL{Scheme: "http", Host: freeAddr()}
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	Expect(err).ToNot(HaveOccurred())
+	DeferCleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		Fail("embedded etcd server not ready")
+	}
+	return clientURL.String()
+}
+
+// newAdminClient returns a client to write to the etcd server
+func newAdminClient(endpoint string, username, password string) *clientv3.Client {
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: 5 * time.Second,
+		Username:    username,
+		Password:    password,
+		Logger:      zap.NewNop(),
+	})
+	Expect(err).ToNot(HaveOccurred())
+	DeferCleanup(c.Close)
+	return c
+}
+
+// newTLSAdminClient returns a client to write to the etcd server serving TLS
+func newTLSAdminClient(endpoint string, caFile string) *clientv3.Client {
+	tlsConfig, err := (&transport.TLSInfo{TrustedCAFile: caFile}).ClientConfig()
+	Expect(err).ToNot(HaveOccurred())
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: 5 * time.Second,
+		TLS:         tlsConfig,
+		Logger:      zap.NewNop(),
+	})
+	Expect(err).ToNot(HaveOccurred())
+	DeferCleanup(c.Close)
+	return c
+}
+
+// tcpProxy forwards the connections to the etcd server, and breaks them while it is down
+type tcpProxy struct {
+	ln     net.Listener
+	target string
+	mu     sync.Mutex
+	down   bool
+	conns  []net.Conn
+}
+
+func newTCPProxy(target string) *tcpProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	p := &tcpProxy{ln: ln, target: target}
+	DeferCleanup(func() {
+		ln.Close()
+		p.setDown(true)
+	})
+	go p.serve()
+	return p
+}
+
+func (p *tcpProxy) endpoint() string {
+	return "http://" + p.ln.Addr().String()
+}
+
+func (p *tcpProxy) serve() {
+	for {
+		c, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		if p.down {
+			p.mu.Unlock()
+			c.Close()
+			continue
+		}
+		t, err := net.Dial("tcp", p.target)
+		if err != nil {
+			p.mu.Unlock()
+			c.Close()
+			continue
+		}
+		p.conns = append(p.conns, c, t)
+		p.mu.Unlock()
+		go func() {
+			io.Copy(t, c)
+			t.Close()
+		}()
+		go func() {
+			io.Copy(c, t)
+			c.Close()
+		}()
+	}
+}
+
+func (p *tcpProxy) setDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.down = down
+	if down {
+		for _, c := range p.conns {
+			c.Close()
+		}
+		p.conns = nil
+	}
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and its key
+func writeCert(dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "etcd"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	Expect(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)).To(Succeed())
+	Expect(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)).To(Succeed())
+	return certFile, keyFile
+}
+
+const dsnKey = "/app/db/dsn"
+
+var _ = Describe("Strategy", func() {
+	var endpoint string
+	var admin *clientv3.Client
+	var s *etcd.Strategy
+
+	put := func(ctx context.Context, key, value string) int64 {
+		resp, err := admin.Put(ctx, key, value)
+		Expect(err).ToNot(HaveOccurred())
+		return resp.Header.Revision
+	}
+
+	BeforeEach(func(ctx context.Context) {
+		endpoint = startEtcd(nil)
+		admin = newAdminClient(endpoint, "", "")
+		put(ctx, dsnKey, "host=db1\n")
+		s = etcd.NewStrategy(etcd.WithEndpoints(endpoint))
+		DeferCleanup(s.Close)
+	})
+
+	It("Should get the value, and send its changes", func(ctx context.Context) {
+		value, values, err := s.Watch(ctx, dsnKey, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("host=db1"))
+
+		put(ctx, dsnKey, "host=db2")
+		Eventually(values).Should(Receive(Equal("host=db2")))
+		put(ctx, dsnKey, "host=db2\n")
+		put(ctx, "/app/other", "host=db3")
+		Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+	}, NodeTimeout(10*time.Second))
+
+	It("Should keep the last value when the key is deleted", func(ctx context.Context) {
+		_, values, err := s.Watch(ctx, dsnKey, "")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = admin.Delete(ctx, dsnKey)
+		Expect(err).ToNot(HaveOccurred())
+		Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+		put(ctx, dsnKey, "host=db2")
+		Eventually(values).Should(Receive(Equal("host=db2")))
+	}, NodeTimeout(10*time.Second))
+
+	It("Should resume the watch after the last revision received", func(ctx context.Context) {
+		proxy := newTCPProxy(strings.TrimPrefix(endpoint, "http://"))
+		_, values, err := s.Watch(ctx, dsnKey, url.Values{"endpoints": []string{proxy.endpoint()}}.Encode())
+		Expect(err).ToNot(HaveOccurred())
+
+		proxy.setDown(true)
+		put(ctx, dsnKey, "host=db2")
+		put(ctx, dsnKey, "host=db3")
+		proxy.setDown(false)
+		Eventually(values, 5*time.Second).Should(Receive(Equal("host=db2")))
+		Eventually(values).Should(Receive(Equal("host=db3")))
+	}, NodeTimeout(10*time.Second))
+
+	It("Should read the value again when the revisions were compacted", func(ctx context.Context) {
+		proxy := newTCPProxy(strings.TrimPrefix(endpoint, "http://"))
+		_, values, err := s.Watch(ctx, dsnKey, url.Values{"endpoints": []string{proxy.endpoint()}}.Encode())
+		Expect(err).ToNot(HaveOccurred())
+
+		proxy.setDown(true)
+		put(ctx, dsnKey, "host=db2")
+		rev := put(ctx, dsnKey, "host=db3")
+		_, err = admin.Compact(ctx, rev)
+		Expect(err).ToNot(HaveOccurred())
+		proxy.setDown(false)
+		Eventually(values, 5*time.Second).Should(Receive(Equal("host=db3")))
+
+		put(ctx, dsnKey, "host=db4")
+		Eventually(values).Should(Receive(Equal("host=db4")))
+	}, NodeTimeout(10*time.Second))
+
+	It("Should fail over to the next endpoint", func(ctx context.Context) {
+		endpoints := freeAddr() + "," + strings.TrimPrefix(endpoint, "http://")
+		value, values, err := s.Watch(ctx, dsnKey, url.Values{"endpoints": []string{endpoints}}.Encode())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("host=db1"))
+
+		put(ctx, dsnKey, "host=db2")
+		Eventually(values).Should(Receive(Equal("host=db2")))
+	}, NodeTimeout(10*time.Second))
+
+	Context("Auth", func() {
+		var passwordFile string
+
+		BeforeEach(func(ctx context.Context) {
+			_, err := admin.RoleAdd(ctx, "root")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = admin.UserAdd(ctx, "root", "root-secret")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = admin.UserGrantRole(ctx, "root", "root")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = admin.RoleAdd(ctx, "app")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = admin.RoleGrantPermission(ctx, "app", dsnKey, "", clientv3.PermissionType(clientv3.PermRead))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = admin.UserAdd(ctx, "app", "secret")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = admin.UserGrantRole(ctx, "app", "app")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = admin.AuthEnable(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			admin = newAdminClient(endpoint, "root", "root-secret")
+			passwordFile = filepath.Join(GinkgoT().TempDir(), "password")
+		})
+
+		It("Should authenticate with the username and password file", func(ctx context.Context) {
+			Expect(os.WriteFile(passwordFile, []byte("secret\n"), 0o600)).To(Succeed())
+			qry := url.Values{"username": []string{"app"}, "passwordFile": []string{passwordFile}}.Encode()
+			value, values, err := s.Watch(ctx, dsnKey, qry)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("host=db1"))
+
+			put(ctx, dsnKey, "host=db2")
+			Eventually(values, 5*time.Second).Should(Receive(Equal("host=db2")))
+		}, NodeTimeout(10*time.Second))
+
+		It("Should fail to watch with a wrong password", func(ctx context.Context) {
+			Expect(os.WriteFile(passwordFile, []byte("wrong"), 0o600)).To(Succeed())
+			qry := url.Values{"username": []string{"app"}, "passwordFile": []string{passwordFile}}.Encode()
+			_, _, err := s.Watch(ctx, dsnKey, qry)
+			Expect(err).To(MatchError(ContainSubstring("authentication failed")))
+		}, NodeTimeout(10*time.Second))
+	})
+
+	It("Should connect with TLS", func(ctx context.Context) {
+		certFile, keyFile := writeCert(GinkgoT().TempDir())
+		tlsEndpoint := startEtcd(&transport.TLSInfo{CertFile: certFile, KeyFile: keyFile})
+
+		qry := url.Values{
+			"endpoints": []string{strings.TrimPrefix(tlsEndpoint, "https://")},
+			"caFile":    []string{certFile},
+		}.Encode()
+		tlsAdmin := newTLSAdminClient(tlsEndpoint, certFile)
+		_, err := tlsAdmin.Put(ctx, dsnKey, "host=tls1")
+		Expect(err).ToNot(HaveOccurred())
+
+		value, values, err := s.Watch(ctx, dsnKey, qry)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("host=tls1"))
+		_, err = tlsAdmin.Put(ctx, dsnKey, "host=tls2")
+		Expect(err).ToNot(HaveOccurred())
+		Eventually(values).Should(Receive(Equal("host=tls2")))
+	}, NodeTimeout(20*time.Second))
+
+	It("Should fan out to the watches of the same key config, and close the watch once they are closed", func(ctx context.Context) {
+		_, values1, err := s.Watch(ctx, dsnKey, "")
+		Expect(err).ToNot(HaveOccurred())
+		_, values2, err := s.Watch(ctx, dsnKey, "forceKill=true")
+		Expect(err).ToNot(HaveOccurred())
+
+		put(ctx, dsnKey, "host=db2")
+		Eventually(values1).Should(Receive(Equal("host=db2")))
+		Eventually(values2).Should(Receive(Equal("host=db2")))
+
+		Expect(s.CloseWatch(dsnKey, "")).To(Succeed())
+		Expect(s.CloseWatch(dsnKey, "forceKill=true")).To(Succeed())
+		Eventually(values1).Should(BeClosed())
+		Eventually(values2).Should(BeClosed())
+	}, NodeTimeout(10*time.Second))
+
+	It("Should fail to watch a key that does not exist", func(ctx context.Context) {
+		_, _, err := s.Watch(ctx, "/app/missing", "")
+		Expect(errors.Is(err, etcd.ErrNotFound)).To(BeTrue())
+	}, NodeTimeout(10*time.Second))
+})
@@ -2,16 +2,20 @@ package fsnotify
 
 import (
 	"context"
-	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	rfsnotify "github.com/fsnotify/fsnotify"
 	"github.com/infobloxopen/hotload"
-	"github.com/infobloxopen/hotload/internal"
+	"github.com/infobloxopen/hotload/internal/backoff"
+	"github.com/infobloxopen/hotload/internal/fanout"
 	"github.com/infobloxopen/hotload/logger"
 	"github.com/infobloxopen/hotload/metrics"
 	"github.com/pkg/errors"
@@ -23,42 +27,43 @@ func init() {
 
 var resyncPeriod = time.Second * 2
 
+const verifyIntervalParam = "verifyInterval"
+
+// k8sDataDir is the directory symlink swapped by Kubernetes to update
+// the files of ConfigMap and Secret volumes
+const k8sDataDir = "..data"
+
+// ErrInvalidVerifyInterval is returned by Watch when the verifyInterval query param is invalid
+var ErrInvalidVerifyInterval = errors.New("invalid verify interval")
+
 // NewStrategy implements a hotload strategy that monitors config changes
 // in a file using fsnotify.
 func NewStrategy() *Strategy {
-	return &Strategy{
-		paths: make(map[string]*pathWatch),
+	s := &Strategy{
+		dirs:            make(map[string]map[string]struct{}),
+		targets:         make(map[string]symlinkTarget),
+		verifyIntervals: make(map[string]map[string]time.Duration),
 	}
+	s.watches = fanout.New("fsnotify", s.onCloseWatch)
+	return s
 }
 
 // Strategy implements the hotload Strategy inferface by using
 // fsnotify under the covers.
 type Strategy struct {
-	mu      sync.RWMutex
-	paths   map[string]*pathWatch
+	mu sync.RWMutex
+	// regMu serializes the changes of the watched directories, so that the watcher
+	// Add and Remove calls (made without mu) are applied in the order of the changes
+	regMu   sync.Mutex
+	watches *fanout.Watches
 	watcher watcher
-}
-
-type pendingOperation struct {
-	operation string
-	watchPath string
-	pathQuery string
-	dsn       string
-	redactDsn string
-}
-
-type queryWatch struct {
-	parentPathW *pathWatch
-	pathQuery   string
-	updateChan  chan string
-	operChan    chan pendingOperation
-}
-
-type pathWatch struct {
-	parentStrat *Strategy
-	watchPath   string
-	value       string
-	queries     map[string]*queryWatch
+	// dirs are the watched directories, with the watched files whose changes show up in
+	// them: the files of the directory, and the symlinked files whose target is in it
+	dirs map[string]map[string]struct{}
+	// targets are the resolved symlink targets of the watched files that are symlinks
+	targets map[string]symlinkTarget
+	// verifyIntervals are the integrity check intervals of the watched paths, by query
+	verifyIntervals map[string]map[string]time.Duration
 }
 
 func (s *Strategy) readConfigFile(path string) (v []byte, err error) {
@@ -70,57 +75,89 @@ func (s *Strategy) readConfigFile(path string) (v []byte, err error) {
 	return
 }
 
-func (s *Strategy) resync(w watcher, pth string) (string, error) {
-	s.logf("fsnotify", "resync path: '%s'", pth)
-	err := w.Remove(pth)
-	if err != nil && !errors.Is(err, rfsnotify.ErrNonExistentWatch) {
-		return "", err
-	}
+func (s *Strategy) resync(pth string) (string, error) {
+	logger.DebugC("fsnotify.resync", "resync path", slog.String(logger.PathKey, pth))
 	bs, err := s.readConfigFile(pth)
 	if err != nil {
 		return "", err
 	}
-	return string(bs), w.Add(pth)
+	return string(bs), nil
+}
+
+// symlinkTarget is the resolved symlink target of a watched file
+type symlinkTarget struct {
+	path string
+	// dir is the directory of the target, watched for the file when
+	// it is not the directory of the file ("" otherwise)
+	dir string
+}
+
+// eventPaths returns the watched files changed by the event of the directory entry:
+// the files with its basename, or whose symlink target has it, or all the watched
+// files of the directory for the "..data" symlink of Kubernetes volumes
+func (s *Strategy) eventPaths(name string) []string {
+	dir, base := filepath.Dir(name), filepath.Base(name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var paths []string
+	for pth := range s.dirs[dir] {
+		target, ok := s.targets[pth]
+		if base == k8sDataDir || base == filepath.Base(pth) || (ok && base == filepath.Base(target.path)) {
+			paths = append(paths, pth)
+		}
+	}
+	return paths
 }
 
 func (s *Strategy) runLoop() {
 	failedPaths := make(map[string]struct{})
+	lastVerified := make(map[string]time.Time)
+	resyncTicker := time.NewTicker(resyncPeriod)
+	defer resyncTicker.Stop()
 	for {
 		select {
 		case ev, ok := <-s.watcher.GetEvents():
 			if !ok {
-				s.logf("fsnotify.runLoop", "Events chan closed, terminating")
+				logger.DebugC("fsnotify.runLoop", "Events chan closed, terminating")
 				return
 			}
 
-			s.logf("fsnotify.runLoop", "got event: %s", ev.String())
-			if !ev.Has(rfsnotify.Write) && !ev.Has(rfsnotify.Remove) {
+			logger.DebugC("fsnotify.runLoop", "got event",
+				slog.String(logger.PathKey, ev.Name),
+				slog.String("op", ev.Op.String()),
+			)
+			if ev.Op == rfsnotify.Chmod {
 				continue
 			}
-
-			val, err := s.resync(s.watcher, ev.Name)
-			if err != nil {
-				s.errlogf("fsnotify.runLoop", "resync(%s) err: %v", ev.Name, err)
-				failedPaths[ev.Name] = struct{}{}
-				break
+			for _, pth := range s.eventPaths(ev.Name) {
+				s.reload(pth, failedPaths)
 			}
 
-			s.setVal(ev.Name, val)
-
 		case err, ok := <-s.watcher.GetErrors():
 			if !ok {
-				s.logf("fsnotify.runLoop", "Errors chan closed, terminating")
+				logger.DebugC("fsnotify.runLoop", "Errors chan closed, terminating")
 				return
 			}
-			s.logf("fsnotify.runLoop", "got error: %s", err.Error())
+			if errors.Is(err, rfsnotify.ErrEventOverflow) {
+				// events were missed, any watched path may have changed
+				logger.ErrorC("fsnotify.runLoop", "event overflow, resyncing all paths", logger.Err(err))
+				for _, pth := range s.watches.Paths() {
+					s.verify(pth, metrics.DriftOverflow, failedPaths)
+				}
+				break
+			}
+			logger.DebugC("fsnotify.runLoop", "got error", logger.Err(err))
 
-		case <-time.After(resyncPeriod):
-			s.logf("fsnotify.runLoop", "resyncPeriod %s timedout", resyncPeriod.String())
+		case now := <-resyncTicker.C:
+			logger.DebugC("fsnotify.runLoop", "resyncPeriod timedout", slog.Duration("resyncPeriod", resyncPeriod))
 			var fixedPaths []string
 			for pth := range failedPaths {
-				val, err := s.resync(s.watcher, pth)
+				val, err := s.resync(pth)
 				if err != nil {
-					s.errlogf("fsnotify.runLoop", "resync(%s) err: %v", pth, err)
+					logger.ErrorC("fsnotify.runLoop", "resync failed",
+						slog.String(logger.PathKey, pth),
+						logger.Err(err),
+					)
 				} else {
 					fixedPaths = append(fixedPaths, pth)
 					s.setVal(pth, val)
@@ -129,235 +166,355 @@ func (s *Strategy) runLoop() {
 			for _, pth := range fixedPaths {
 				delete(failedPaths, pth)
 			}
+
+			for pth, interval := range s.verifyPaths() {
+				if now.Sub(lastVerified[pth]) < interval {
+					continue
+				}
+				lastVerified[pth] = now
+				s.verify(pth, metrics.DriftVerify, failedPaths)
+			}
 		}
 	}
 }
 
-func (s *Strategy) setVal(pth string, val string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, ok := s.paths[pth]; !ok {
-		s.logf("fsnotify.setVal", "ignoring path not in map: '%s'", pth)
+// reload re-reads the watched file (and resolves its symlink target again) after an
+// event in one of its directories, and sends its value if it changed
+func (s *Strategy) reload(pth string, failedPaths map[string]struct{}) {
+	s.mu.RLock()
+	w := s.watcher
+	s.mu.RUnlock()
+	if w != nil {
+		s.regMu.Lock()
+		s.retarget(w, pth)
+		s.regMu.Unlock()
+	}
+
+	val, err := s.resync(pth)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// renamed or removed, wait for the file to be created (or renamed to) again
+			logger.DebugC("fsnotify.reload", "file removed", slog.String(logger.PathKey, pth))
+			return
+		}
+		logger.ErrorC("fsnotify.reload", "resync failed",
+			slog.String(logger.PathKey, pth),
+			logger.Err(err),
+		)
+		failedPaths[pth] = struct{}{}
+		return
+	}
+	delete(failedPaths, pth)
+	if len(val) <= 0 {
+		// created but not written yet, wait for the write event
+		logger.DebugC("fsnotify.reload", "ignoring empty file", slog.String(logger.PathKey, pth))
 		return
 	}
-	s.paths[pth].value = val
-	redactDsn := internal.RedactUrl(val)
-	for _, qryW := range s.paths[pth].queries {
-		pendOp := pendingOperation{
-			operation: "send",
-			dsn:       val,
-			redactDsn: redactDsn,
+	if curVal, ok := s.watches.Value(pth); ok && val == curVal {
+		return
+	}
+	s.setVal(pth, val)
+}
+
+// verify re-reads the watched path, and sends its value if it drifted from the
+// last value sent (ie: its event was missed)
+func (s *Strategy) verify(pth string, reason string, failedPaths map[string]struct{}) {
+	if _, failed := failedPaths[pth]; failed {
+		// retried by the resync
+		return
+	}
+	val, err := s.resync(pth)
+	if err != nil {
+		logger.ErrorC("fsnotify.verify", "resync failed",
+			slog.String(logger.PathKey, pth),
+			logger.Err(err),
+		)
+		failedPaths[pth] = struct{}{}
+		return
+	}
+	curVal, ok := s.watches.Value(pth)
+	if !ok || len(val) <= 0 || val == curVal {
+		return
+	}
+	logger.Warn("watched path drifted, sending missed change",
+		logger.Component("fsnotify.verify"),
+		slog.String(logger.PathKey, pth),
+		slog.String("reason", reason),
+	)
+	metrics.IncHotloadPathDriftTotal("fsnotify", pth, reason)
+	s.setVal(pth, val)
+}
+
+// verifyPaths returns the paths to check periodically, with their shortest verify interval
+func (s *Strategy) verifyPaths() map[string]time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	paths := make(map[string]time.Duration, len(s.verifyIntervals))
+	for pth, intervals := range s.verifyIntervals {
+		for _, interval := range intervals {
+			if cur, ok := paths[pth]; !ok || interval < cur {
+				paths[pth] = interval
+			}
 		}
-		qryW.operChan <- pendOp
 	}
+	return paths
+}
+
+// parseVerifyInterval parses the verifyInterval query param,
+// returns 0 if the path is not checked periodically
+func parseVerifyInterval(pathQry string) (time.Duration, error) {
+	vs, err := url.ParseQuery(pathQry)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not parse query %v", pathQry)
+	}
+	return backoff.ParseDuration(vs, verifyIntervalParam, 0, ErrInvalidVerifyInterval)
+}
+
+func (s *Strategy) setVal(pth string, val string) {
+	s.watches.Set(pth, val)
 }
 
 // Watch implements the hotload.Strategy interface.
 func (s *Strategy) Watch(ctx context.Context, pth string, pathQry string) (value string, values <-chan string, err error) {
 	pth = path.Clean(pth)
 	pathQry = strings.TrimSpace(pathQry)
+	verifyInterval, err := parseVerifyInterval(pathQry)
+	if err != nil {
+		return "", nil, err
+	}
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	// if this is the first time this strategy is called, initialize ourselves
 	if s.watcher == nil {
 		watcher, err := notifyConstructor()
 		if err != nil {
+			s.mu.Unlock()
 			return "", nil, err
 		}
 		s.watcher = watcher
 		go s.runLoop()
 	}
-	pathW, found := s.paths[pth]
-	if found {
-		pathW.logf("fsnotify.Watch", "path already being watched")
-	} else {
-		s.logf("fsnotify.Watch", "new path to be watched: '%s'", pth)
-		if err := s.watcher.Add(pth); err != nil {
-			return "", nil, err
+	w := s.watcher
+	s.mu.Unlock()
+
+	value, values, err = s.watches.Watch(pth, pathQry, func() (string, error) {
+		if err := s.addPath(w, pth); err != nil {
+			return "", err
 		}
 		if err := metrics.AddToDefaultPathChksum(pth); err != nil {
 			if err != metrics.ErrDuplicatePath {
-				s.errlogf("fsnotify.Watch", "AddToDefaultPathChksum(%s) failed, err=%v", pth, err)
-				return "", nil, err
+				logger.ErrorC("fsnotify.Watch", "AddToDefaultPathChksum failed",
+					slog.String(logger.PathKey, pth),
+					logger.Err(err),
+				)
+				return "", err
 			}
 		}
 		bs, err := s.readConfigFile(pth)
 		if err != nil {
-			s.watcher.Remove(pth)
-			return "", nil, err
-		}
-		pathW = &pathWatch{
-			parentStrat: s,
-			watchPath:   pth,
-			value:       string(bs),
-			queries:     make(map[string]*queryWatch),
+			s.removePath(w, pth)
+			return "", err
 		}
-		s.paths[pth] = pathW
+		return string(bs), nil
+	})
+	if err != nil {
+		return "", nil, err
 	}
 
-	qryW, found := pathW.queries[pathQry]
-	if found {
-		qryW.logf("fsnotify.Watch", "query already being watched")
-	} else {
-		pathW.logf("fsnotify.Watch", "new query to be watched: '%s'", pathQry)
-		qryW = &queryWatch{
-			parentPathW: pathW,
-			pathQuery:   pathQry,
-			updateChan:  make(chan string),
-			operChan:    make(chan pendingOperation, 30),
+	if verifyInterval > 0 {
+		s.mu.Lock()
+		if _, ok := s.verifyIntervals[pth]; !ok {
+			s.verifyIntervals[pth] = make(map[string]time.Duration)
 		}
-		pathW.queries[pathQry] = qryW
-		go qryW.opLoop()
+		s.verifyIntervals[pth][pathQry] = verifyInterval
+		s.mu.Unlock()
 	}
+	return value, values, nil
+}
 
-	return pathW.value, qryW.updateChan, nil
+// addPath watches the parent directory of the file (shared by all the watched files
+// of the directory), so that the file is still watched after being replaced
+// (eg: renamed to by an editor, or removed and created again), and the directory
+// of its symlink target
+func (s *Strategy) addPath(w watcher, pth string) error {
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
+	if err := s.addDir(w, filepath.Dir(pth), pth); err != nil {
+		return err
+	}
+	s.retarget(w, pth)
+	return nil
 }
 
-// CloseWatch implements the hotload.Strategy interface.
-// Closes the specified watch by removing the path
-// from the watcher and closing the path's update channel.
-func (s *Strategy) CloseWatch(pth string, pathQry string) error {
-	pth = path.Clean(pth)
-	pathQry = strings.TrimSpace(pathQry)
+// removePath stops watching the file, and its directories once they have no more watched files
+func (s *Strategy) removePath(w watcher, pth string) error {
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	pathW, found := s.paths[pth]
-	if found {
-		qryW, ok := pathW.queries[pathQry]
-		if ok {
-			pendOp := pendingOperation{
-				operation: "close",
-				watchPath: pth,
-				pathQuery: pathQry,
-			}
-			qryW.operChan <- pendOp
-			qryW.logf("fsnotify.CloseWatch", "sent pending close operation")
+	target, ok := s.targets[pth]
+	delete(s.targets, pth)
+	s.mu.Unlock()
+	if ok && target.dir != "" {
+		if err := s.removeDir(w, target.dir, pth); err != nil {
+			// the target directory may have been removed (eg: by a symlink swap)
+			logger.DebugC("fsnotify.removePath", "failed to remove target directory from watcher",
+				slog.String(logger.PathKey, target.dir),
+				logger.Err(err),
+			)
 		}
 	}
-	return nil
+	return s.removeDir(w, filepath.Dir(pth), pth)
 }
 
-func (s *Strategy) processWatchClosure(pendOp pendingOperation) error {
-	var err error
+// addDir watches the directory for the changes of the file, s.regMu must be held
+func (s *Strategy) addDir(w watcher, dir, pth string) error {
+	s.mu.RLock()
+	_, found := s.dirs[dir]
+	s.mu.RUnlock()
+	if !found {
+		if err := w.Add(dir); err != nil {
+			return err
+		}
+		logger.DebugC("fsnotify.Watch", "new directory to be watched", slog.String(logger.PathKey, dir))
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	pathW, found := s.paths[pendOp.watchPath]
-	if found {
-		qryW, ok := pathW.queries[pendOp.pathQuery]
-		if ok {
-			delete(pathW.queries, pendOp.pathQuery)
-			qryW.closeUpdateChan()
-			qryW.logf("fsnotify.processWatchClosure", "closed update channel")
-		}
-		if len(pathW.queries) <= 0 {
-			err = s.watcher.Remove(pendOp.watchPath)
-			if err == nil {
-				s.logf("fsnotify.processWatchClosure", "removed path from being watched '%s'", pendOp.watchPath)
-			} else {
-				s.errlogf("fsnotify.processWatchClosure", "failed to remove '%s' from watcher, err=%v", pendOp.watchPath, err)
-			}
-			delete(s.paths, pendOp.watchPath)
-			s.logf("fsnotify.processWatchClosure", "strategy removed path '%s'", pendOp.watchPath)
-		}
+	paths, found := s.dirs[dir]
+	if !found {
+		paths = make(map[string]struct{})
+		s.dirs[dir] = paths
 	}
-	return err
+	paths[pth] = struct{}{}
+	return nil
 }
 
-// Close implements the hotload.Strategy interface.
-// Closes this strategy by closing the internal watcher
-// and closing all the update channels.
-func (s *Strategy) Close() {
+// removeDir stops watching the directory for the changes of the file, and removes it
+// from the watcher once it has no more watched files, s.regMu must be held
+func (s *Strategy) removeDir(w watcher, dir, pth string) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	if s.watcher != nil {
-		s.watcher.Close()
-		s.logf("fsnotify.Close", "closed internal watcher")
-		s.watcher = nil
+	paths, found := s.dirs[dir]
+	if !found {
+		s.mu.Unlock()
+		return nil
 	}
-	for _, pathW := range s.paths {
-		for _, qryW := range pathW.queries {
-			qryW.closeUpdateChan()
-			qryW.logf("fsnotify.Close", "closed update channel")
-		}
-		pathW.queries = nil
+	delete(paths, pth)
+	last := len(paths) <= 0
+	if last {
+		delete(s.dirs, dir)
 	}
-	s.paths = nil
+	s.mu.Unlock()
+	if !last || w == nil {
+		return nil
+	}
+	return w.Remove(dir)
 }
 
-func (qw *queryWatch) sendUpdate(val, redactDsn string) {
-	if qw.updateChan == nil {
+// retarget resolves the symlink target of the watched file again, and watches
+// the directory of its current target (instead of the directory of its previous
+// target), s.regMu must be held
+func (s *Strategy) retarget(w watcher, pth string) {
+	target := resolveTarget(pth)
+	s.mu.RLock()
+	_, watched := s.dirs[filepath.Dir(pth)][pth]
+	cur := s.targets[pth]
+	s.mu.RUnlock()
+	if !watched || cur == target {
+		// no longer watched (eg: closed while its event was handled), or unchanged
 		return
 	}
-
-	defer func() {
-		// Recover/ignore from "panic: send on closed channel"
-		r := recover()
-		if r != nil {
-			qw.logf("fsnotify.sendUpdate", "panic recovery '%s'", r)
-		}
-	}()
-
-	qw.logf("fsnotify.sendUpdate", "block-sending redactDsn='%s'", redactDsn)
-	qw.updateChan <- val
-	qw.logf("fsnotify.sendUpdate", "successfully sent redactDsn='%s'", redactDsn)
-}
-
-func (qw *queryWatch) closeUpdateChan() {
-	close(qw.updateChan)
-	qw.updateChan = nil
-}
-
-func (qw *queryWatch) opLoop() {
-	for {
-		select {
-		case pendOp, ok := <-qw.operChan:
-			if !ok {
-				qw.logf("fsnotify.opLoop", "operChan closed, terminating")
-				return
+	if cur.dir != target.dir {
+		if cur.dir != "" {
+			if err := s.removeDir(w, cur.dir, pth); err != nil {
+				logger.DebugC("fsnotify.retarget", "failed to remove target directory from watcher",
+					slog.String(logger.PathKey, cur.dir),
+					logger.Err(err),
+				)
 			}
-			switch pendOp.operation {
-			case "close":
-				qw.logf("fsnotify.opLoop", "pendingOperation '%s', pendingPath=%s, pendingQuery='%s'",
-					pendOp.operation, pendOp.watchPath, pendOp.pathQuery)
-				qw.parentPathW.parentStrat.processWatchClosure(pendOp)
-			case "send":
-				qw.logf("fsnotify.opLoop", "pendingOperation '%s', redactDsn='%s'",
-					pendOp.operation, pendOp.redactDsn)
-				qw.sendUpdate(pendOp.dsn, pendOp.redactDsn)
-			default:
-				qw.logf("fsnotify.opLoop", "ignore invalid pendingOperation '%s'",
-					pendOp.operation)
+		}
+		if target.dir != "" {
+			if err := s.addDir(w, target.dir, pth); err != nil {
+				logger.ErrorC("fsnotify.retarget", "failed to watch target directory",
+					slog.String(logger.PathKey, target.dir),
+					logger.Err(err),
+				)
+				// watched again by the next retarget
+				target.dir = ""
 			}
 		}
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if target.path == "" {
+		delete(s.targets, pth)
+	} else {
+		s.targets[pth] = target
+	}
 }
 
-func (s *Strategy) logf(prefix, format string, args ...any) {
-	logPrefix := fmt.Sprintf("%s:", prefix)
-	logger.Logf(logPrefix, format, args...)
-}
-
-func (pw *pathWatch) logf(prefix, format string, args ...any) {
-	logPrefix := fmt.Sprintf("%s[%s]:", prefix, pw.watchPath)
-	logger.Logf(logPrefix, format, args...)
-}
-
-func (qw *queryWatch) logf(prefix, format string, args ...any) {
-	logPrefix := fmt.Sprintf("%s[%s?%s]:", prefix, qw.parentPathW.watchPath, qw.pathQuery)
-	logger.Logf(logPrefix, format, args...)
+// resolveTarget returns the symlink target of the file (eg: the files of Kubernetes
+// ConfigMap and Secret volumes are symlinks through the "..data" directory symlink),
+// or the zero symlinkTarget if the file is not a symlink
+func resolveTarget(pth string) symlinkTarget {
+	target, err := filepath.EvalSymlinks(pth)
+	if err != nil || target == pth {
+		return symlinkTarget{}
+	}
+	st := symlinkTarget{path: target}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(pth))
+	if err == nil && filepath.Dir(target) != dir {
+		st.dir = filepath.Dir(target)
+	}
+	return st
 }
 
-func (s *Strategy) errlogf(prefix, format string, args ...any) {
-	logPrefix := fmt.Sprintf("%s:", prefix)
-	logger.ErrLogf(logPrefix, format, args...)
+// CloseWatch implements the hotload.Strategy interface.
+// Closes the specified watch by removing the path
+// from the watcher and closing the path's update channel.
+func (s *Strategy) CloseWatch(pth string, pathQry string) error {
+	pth = path.Clean(pth)
+	pathQry = strings.TrimSpace(pathQry)
+	s.watches.CloseWatch(pth, pathQry)
+	return nil
 }
 
-func (pw *pathWatch) errlogf(prefix, format string, args ...any) {
-	logPrefix := fmt.Sprintf("%s[%s]:", prefix, pw.watchPath)
-	logger.ErrLogf(logPrefix, format, args...)
+// onCloseWatch forgets the verify interval of the closed query,
+// and removes the path from the watcher once its last query is closed
+func (s *Strategy) onCloseWatch(pth, pathQry string, last bool) {
+	s.mu.Lock()
+	if intervals, ok := s.verifyIntervals[pth]; ok {
+		delete(intervals, pathQry)
+		if len(intervals) <= 0 {
+			delete(s.verifyIntervals, pth)
+		}
+	}
+	w := s.watcher
+	s.mu.Unlock()
+	if !last {
+		return
+	}
+	if err := s.removePath(w, pth); err != nil {
+		logger.ErrorC("fsnotify.processWatchClosure", "failed to remove path from watcher",
+			slog.String(logger.PathKey, pth),
+			logger.Err(err),
+		)
+		return
+	}
+	logger.DebugC("fsnotify.processWatchClosure", "removed path from being watched",
+		slog.String(logger.PathKey, pth))
 }
 
-func (qw *queryWatch) errlogf(prefix, format string, args ...any) {
-	logPrefix := fmt.Sprintf("%s[%s?%s]:", prefix, qw.parentPathW.watchPath, qw.pathQuery)
-	logger.ErrLogf(logPrefix, format, args...)
+// Close implements the hotload.Strategy interface.
+// Closes this strategy by closing the internal watcher
+// and closing all the update channels.
+func (s *Strategy) Close() {
+	s.mu.Lock()
+	if s.watcher != nil {
+		s.watcher.Close()
+		logger.DebugC("fsnotify.Close", "closed internal watcher")
+		s.watcher = nil
+	}
+	s.dirs = make(map[string]map[string]struct{})
+	s.targets = make(map[string]symlinkTarget)
+	s.verifyIntervals = make(map[string]map[string]time.Duration)
+	s.mu.Unlock()
+	s.watches.Close()
 }
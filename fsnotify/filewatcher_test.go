@@ -2,14 +2,18 @@ package fsnotify
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
+	"path/filepath"
 	"time"
 
 	rfsnotify "github.com/fsnotify/fsnotify"
+	"github.com/infobloxopen/hotload/metrics"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func assertStringFromChannel(name string, want string, from <-chan string) {
@@ -255,6 +259,194 @@ var _ = Describe("FileWatcher", func() {
 		})
 	})
 
+	Context("Parent directory", func() {
+		It("Should detect a file replaced by a rename immediately", func() {
+			dir := GinkgoT().TempDir()
+			watchPath := filepath.Join(dir, "dsn.txt")
+			Expect(os.WriteFile(watchPath, []byte("a"), 0666)).To(Succeed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			gotValue, updateChan, err := s.Watch(ctx, watchPath, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gotValue).To(Equal("a"))
+
+			// an unrelated file in the same directory is ignored
+			Expect(os.WriteFile(filepath.Join(dir, "other.txt"), []byte("x"), 0666)).To(Succeed())
+
+			Expect(os.WriteFile(watchPath+".tmp", []byte("b"), 0666)).To(Succeed())
+			Expect(os.Rename(watchPath+".tmp", watchPath)).To(Succeed())
+			Eventually(updateChan, resyncPeriod/2).Should(Receive(Equal("b")))
+
+			Expect(os.Remove(watchPath)).To(Succeed())
+			Expect(os.WriteFile(watchPath, []byte("c"), 0666)).To(Succeed())
+			Eventually(updateChan, resyncPeriod/2).Should(Receive(Equal("c")))
+
+			_ = s.CloseWatch(watchPath, "")
+			time.Sleep(10 * time.Millisecond)
+		})
+
+		It("Should detect the swap of a Kubernetes volume ..data symlink", func() {
+			dir := GinkgoT().TempDir()
+			Expect(os.Mkdir(filepath.Join(dir, "..v1"), 0777)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "..v1", "dsn.txt"), []byte("a"), 0666)).To(Succeed())
+			Expect(os.Symlink("..v1", filepath.Join(dir, "..data"))).To(Succeed())
+			watchPath := filepath.Join(dir, "dsn.txt")
+			Expect(os.Symlink(filepath.Join("..data", "dsn.txt"), watchPath)).To(Succeed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			gotValue, updateChan, err := s.Watch(ctx, watchPath, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gotValue).To(Equal("a"))
+
+			// the kubelet writes a new directory, and atomically swaps the ..data symlink to it
+			Expect(os.Mkdir(filepath.Join(dir, "..v2"), 0777)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(dir, "..v2", "dsn.txt"), []byte("b"), 0666)).To(Succeed())
+			Expect(os.Symlink("..v2", filepath.Join(dir, "..data_tmp"))).To(Succeed())
+			Expect(os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data"))).To(Succeed())
+			Expect(os.RemoveAll(filepath.Join(dir, "..v1"))).To(Succeed())
+			Eventually(updateChan, resyncPeriod/2).Should(Receive(Equal("b")))
+
+			_ = s.CloseWatch(watchPath, "")
+			time.Sleep(10 * time.Millisecond)
+		})
+
+		It("Should detect a write to a symlink target in another directory", func() {
+			targetPath := filepath.Join(GinkgoT().TempDir(), "target.txt")
+			Expect(os.WriteFile(targetPath, []byte("a"), 0666)).To(Succeed())
+			watchPath := filepath.Join(GinkgoT().TempDir(), "dsn.txt")
+			Expect(os.Symlink(targetPath, watchPath)).To(Succeed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			gotValue, updateChan, err := s.Watch(ctx, watchPath, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gotValue).To(Equal("a"))
+
+			Expect(os.WriteFile(targetPath, []byte("b"), 0666)).To(Succeed())
+			Eventually(updateChan, resyncPeriod/2).Should(Receive(Equal("b")))
+
+			_ = s.CloseWatch(watchPath, "")
+			time.Sleep(10 * time.Millisecond)
+		})
+
+		It("Should share one directory watch across the files of the directory", func() {
+			strat := NewStrategy()
+			watcher := newTestWatcher()
+			strat.watcher = watcher
+
+			dir := GinkgoT().TempDir()
+			path1 := filepath.Join(dir, "dsn1.txt")
+			path2 := filepath.Join(dir, "dsn2.txt")
+			Expect(os.WriteFile(path1, []byte("a"), 0666)).To(Succeed())
+			Expect(os.WriteFile(path2, []byte("b"), 0666)).To(Succeed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, updateChan1, err := strat.Watch(ctx, path1, "")
+			Expect(err).ToNot(HaveOccurred())
+			_, updateChan2, err := strat.Watch(ctx, path2, "")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(watcher.paths).To(Equal(map[string]bool{dir: true}))
+
+			Expect(strat.CloseWatch(path1, "")).To(Succeed())
+			Eventually(updateChan1).Should(BeClosed())
+			Expect(strat.eventPaths(path1)).To(BeEmpty())
+			Expect(strat.eventPaths(path2)).To(Equal([]string{path2}))
+
+			Expect(strat.CloseWatch(path2, "")).To(Succeed())
+			Eventually(updateChan2).Should(BeClosed())
+			Eventually(func() map[string]map[string]struct{} {
+				strat.mu.RLock()
+				defer strat.mu.RUnlock()
+				return strat.dirs
+			}).Should(BeEmpty())
+		})
+	})
+
+	Context("Event filter", func() {
+		It("Should only reload the files named by the event, or on a ..data swap", func() {
+			strat := NewStrategy()
+			watcher := newTestWatcher()
+			strat.watcher = watcher
+			dir := GinkgoT().TempDir()
+			watchPath := filepath.Join(dir, "dsn.txt")
+			Expect(os.WriteFile(watchPath, []byte("a"), 0666)).To(Succeed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, updateChan, err := strat.Watch(ctx, watchPath, "")
+			Expect(err).ToNot(HaveOccurred())
+			go strat.runLoop()
+
+			// the write event is missed, the event of another file of the directory is ignored
+			Expect(os.WriteFile(watchPath, []byte("b"), 0666)).To(Succeed())
+			watcher.eventChannel <- rfsnotify.Event{Name: filepath.Join(dir, "other.txt"), Op: rfsnotify.Write}
+			Consistently(updateChan, 100*time.Millisecond).ShouldNot(Receive())
+
+			watcher.eventChannel <- rfsnotify.Event{Name: filepath.Join(dir, "..data"), Op: rfsnotify.Create}
+			Eventually(updateChan).Should(Receive(Equal("b")))
+
+			Expect(os.WriteFile(watchPath, []byte("c"), 0666)).To(Succeed())
+			watcher.eventChannel <- rfsnotify.Event{Name: watchPath, Op: rfsnotify.Write}
+			Eventually(updateChan).Should(Receive(Equal("c")))
+		})
+	})
+
+	Context("Resync", func() {
+		var strat *Strategy
+		var watcher *testWatcher
+		var watchPath string
+
+		BeforeEach(func() {
+			strat = NewStrategy()
+			watcher = newTestWatcher()
+			strat.watcher = watcher
+			watchPath = filepath.Join(GinkgoT().TempDir(), "dsn.txt")
+			Expect(os.WriteFile(watchPath, []byte("a"), 0666)).To(Succeed())
+			metrics.ResetCollectors()
+			DeferCleanup(metrics.ResetCollectors)
+		})
+
+		It("Should resync all paths after an event overflow", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, updateChan, err := strat.Watch(ctx, watchPath, "")
+			Expect(err).ToNot(HaveOccurred())
+			go strat.runLoop()
+
+			// the write event is missed
+			Expect(os.WriteFile(watchPath, []byte("b"), 0666)).To(Succeed())
+			watcher.errors <- rfsnotify.ErrEventOverflow
+			Eventually(updateChan).Should(Receive(Equal("b")))
+
+			Expect(testutil.ToFloat64(metrics.HotloadPathDriftTotal.WithLabelValues("fsnotify", watchPath, metrics.DriftOverflow))).To(Equal(1.0))
+		})
+
+		It("Should send the drift found by the periodic integrity check", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			_, updateChan, err := strat.Watch(ctx, watchPath, "verifyInterval=10ms")
+			Expect(err).ToNot(HaveOccurred())
+			go strat.runLoop()
+
+			// no drift
+			Consistently(updateChan, resyncPeriod+500*time.Millisecond).ShouldNot(Receive())
+
+			// the write event is missed
+			Expect(os.WriteFile(watchPath, []byte("b"), 0666)).To(Succeed())
+			Eventually(updateChan, resyncPeriod*2).Should(Receive(Equal("b")))
+
+			Expect(testutil.ToFloat64(metrics.HotloadPathDriftTotal.WithLabelValues("fsnotify", watchPath, metrics.DriftVerify))).To(Equal(1.0))
+		})
+
+		It("Should fail to watch with an invalid verify interval", func() {
+			_, _, err := strat.Watch(context.Background(), watchPath, "verifyInterval=bogus")
+			Expect(errors.Is(err, ErrInvalidVerifyInterval)).To(BeTrue())
+		})
+	})
+
 	Context("Two watches with same path but diff query-params", func() {
 		It("Should update channels for both watches", func() {
 			f, _ := os.CreateTemp("", "hotload_fsnotify_filewatcher_two_watches_unittest_")
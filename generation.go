@@ -0,0 +1,113 @@
+package hotload
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultKeepGenerations is the default number of live generations kept by a hotload
+// connection string: the current generation and the previous (draining) generation.
+const DefaultKeepGenerations = 2
+
+const keepGenerations = "keepGenerations"
+
+// ErrNotOpened is returned by GetStatus for a connection string that has not been opened
+var ErrNotOpened = errors.New("hotload connection string has not been opened")
+
+// GenerationState is the state of a generation
+type GenerationState int
+
+const (
+	// GenerationActive is the current generation, new conns are opened with it
+	GenerationActive GenerationState = iota
+	// GenerationDraining is a previous generation, its conns are reset (discarded
+	// by database/sql when returned to the pool) but are not closed yet
+	GenerationDraining
+	// GenerationClosed is a retired generation, its context is canceled and its conns are closed
+	GenerationClosed
+)
+
+func (s GenerationState) String() string {
+	switch s {
+	case GenerationActive:
+		return "active"
+	case GenerationDraining:
+		return "draining"
+	case GenerationClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// generation is the set of conns opened with the same connection string value
+type generation struct {
+	id        uint64
+	ctx       context.Context
+	cancel    context.CancelFunc
+	redactVal string
+	startTime time.Time
+	state     GenerationState
+	conns     []*managedConn
+	// ready are warm conns (also in conns) not handed out yet
+	ready []*managedConn
+
+	// gate holds new work while the generation is quiesced (see switchoverMode=quiesce)
+	gate atomic.Pointer[quiesceGate]
+
+	// open transactions, waited on (see waitTxIdle) when the generation is quiesced
+	txMu   sync.Mutex
+	txs    int
+	txIdle chan struct{}
+}
+
+func newGeneration(parentCtx context.Context, id uint64, redactVal string) *generation {
+	ctx, cancel := context.WithCancel(parentCtx)
+	return &generation{
+		id:        id,
+		ctx:       ctx,
+		cancel:    cancel,
+		redactVal: redactVal,
+		startTime: time.Now(),
+		state:     GenerationActive,
+		conns:     make([]*managedConn, 0),
+	}
+}
+
+func (g *generation) idStr() string {
+	return strconv.FormatUint(g.id, 10)
+}
+
+// GenerationStatus holds status of a live generation, returned by GetStatus()
+type GenerationStatus struct {
+	Generation uint64
+	RedactDsn  string
+	StartTime  time.Time
+	State      GenerationState
+	Conns      int
+	// ReadyConns are the warm conns not handed out yet (included in Conns)
+	ReadyConns int
+}
+
+// Status holds status of a hotload connection string, returned by GetStatus()
+type Status struct {
+	Name string
+	// Generations are the live generations, oldest first (the last is the active one)
+	Generations []GenerationStatus
+}
+
+// GetStatus returns the current status of a hotload connection string
+// (the name given to sql.Open) that has been opened
+func GetStatus(name string) (*Status, error) {
+	hotloadDriver.mu.RLock()
+	cg, ok := hotloadDriver.cgroup[name]
+	hotloadDriver.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotOpened
+	}
+	return cg.status(), nil
+}
@@ -0,0 +1,151 @@
+package hotload
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// gatedStrategy blocks each Watch until released
+type gatedStrategy struct {
+	watching chan string
+	release  chan error
+	watches  atomic.Int32
+}
+
+func newGatedStrategy() *gatedStrategy {
+	return &gatedStrategy{
+		watching: make(chan string, 10),
+		release:  make(chan error),
+	}
+}
+
+func (s *gatedStrategy) Watch(ctx context.Context, pth string, pathQry string) (string, <-chan string, error) {
+	s.watches.Add(1)
+	s.watching <- pth
+	if err := <-s.release; err != nil {
+		return "", nil, err
+	}
+	return "1st-dsn", make(chan string), nil
+}
+
+func (s *gatedStrategy) CloseWatch(pth string, pathQry string) error {
+	return nil
+}
+
+func (s *gatedStrategy) Close() {}
+
+// instantStrategy returns from Watch immediately
+type instantStrategy struct{}
+
+func (instantStrategy) Watch(ctx context.Context, pth string, pathQry string) (string, <-chan string, error) {
+	return "1st-dsn", make(chan string), nil
+}
+
+func (instantStrategy) CloseWatch(pth string, pathQry string) error {
+	return nil
+}
+
+func (instantStrategy) Close() {}
+
+var _ = Describe("hdriver.Open", func() {
+	var h *hdriver
+	var gs *gatedStrategy
+
+	BeforeEach(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		h = newHdriver(ctx)
+		gs = newGatedStrategy()
+		RegisterStrategy("gated", gs)
+		RegisterStrategy("instant", instantStrategy{})
+		mu.Lock()
+		sqlDrivers["hdrivertest"] = &driverInstance{driver: closableDriver{}}
+		mu.Unlock()
+		DeferCleanup(func() {
+			cancel()
+			UnregisterStrategy("gated")
+			UnregisterStrategy("instant")
+			mu.Lock()
+			delete(sqlDrivers, "hdrivertest")
+			delete(sqlDrivers, "hdrivertest2")
+			mu.Unlock()
+		})
+	})
+
+	openAsync := func(name string) <-chan error {
+		errs := make(chan error, 1)
+		go func() {
+			conn, err := h.Open(name)
+			if err == nil {
+				conn.Close()
+			}
+			errs <- err
+		}()
+		return errs
+	}
+
+	It("Should not block other names nor registrations while a strategy is watching", func(ginkgoCtx context.Context) {
+		slowErrs := openAsync("gated://hdrivertest/slow")
+		Eventually(gs.watching).Should(Receive(Equal("/slow")))
+
+		conn, err := h.Open("instant://hdrivertest/fast")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(conn.Close()).To(Succeed())
+
+		RegisterSQLDriver("hdrivertest2", closableDriver{})
+
+		gs.release <- nil
+		Eventually(slowErrs).Should(Receive(BeNil()))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should watch once for concurrent opens of the same name", func(ginkgoCtx context.Context) {
+		const opens = 5
+		var wg sync.WaitGroup
+		errs := make(chan error, opens)
+		for i := 0; i < opens; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- <-openAsync("gated://hdrivertest/shared")
+			}()
+		}
+		Eventually(gs.watching).Should(Receive(Equal("/shared")))
+		Consistently(gs.watching, 100*time.Millisecond).ShouldNot(Receive())
+
+		gs.release <- nil
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			Expect(err).ShouldNot(HaveOccurred())
+		}
+		Expect(gs.watches.Load()).To(Equal(int32(1)))
+
+		h.mu.RLock()
+		defer h.mu.RUnlock()
+		Expect(h.cgroup).To(HaveKey("gated://hdrivertest/shared"))
+		Expect(h.inits).To(BeEmpty())
+	}, NodeTimeout(5*time.Second))
+
+	It("Should share a watch error and retry on the next open", func(ginkgoCtx context.Context) {
+		watchErr := errors.New("watch failed")
+		errs1 := openAsync("gated://hdrivertest/failing")
+		Eventually(gs.watching).Should(Receive())
+		errs2 := openAsync("gated://hdrivertest/failing")
+		Consistently(gs.watching, 100*time.Millisecond).ShouldNot(Receive())
+
+		gs.release <- watchErr
+		Eventually(errs1).Should(Receive(MatchError(watchErr)))
+		Eventually(errs2).Should(Receive(MatchError(watchErr)))
+
+		errs3 := openAsync("gated://hdrivertest/failing")
+		Eventually(gs.watching).Should(Receive())
+		gs.release <- nil
+		Eventually(errs3).Should(Receive(BeNil()))
+		Expect(gs.watches.Load()).To(Equal(int32(2)))
+	}, NodeTimeout(5*time.Second))
+})
@@ -0,0 +1,426 @@
+// Package httppoll implements hotload strategies that poll a config endpoint over
+// HTTP(S) with conditional requests (ETag / Last-Modified), so unchanged values
+// cost a 304 response. The endpoint is the path of the hotload connection string
+// (its first element being the endpoint host), and the value is the response body
+// or a field of the JSON response.
+//
+// Import it to register the "http" and "https" strategies:
+//
+//	import _ "github.com/infobloxopen/hotload/httppoll"
+//
+//	db, err := sql.Open("hotload", "https://postgres/config.internal:8443/v1/db?jsonField=data.dsn&interval=30s")
+package httppoll
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infobloxopen/hotload"
+	"github.com/infobloxopen/hotload/internal/backoff"
+	"github.com/infobloxopen/hotload/internal/fanout"
+	"github.com/infobloxopen/hotload/logger"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	hotload.RegisterStrategy("http", NewStrategy(WithScheme("http")))
+	hotload.RegisterStrategy("https", NewStrategy())
+}
+
+const (
+	intervalParam   = "interval"
+	maxBackoffParam = "maxBackoff"
+	headerParam     = "header"
+	tokenFileParam  = "tokenFile"
+	certFileParam   = "certFile"
+	keyFileParam    = "keyFile"
+	caFileParam     = "caFile"
+	jsonFieldParam  = "jsonField"
+
+	// maxBodySize is the largest response body read
+	maxBodySize = 1 << 20
+)
+
+// endpointParams are the query params configuring the requests, the watches
+// of an endpoint with the same endpointParams share the same polling
+var endpointParams = []string{
+	intervalParam, maxBackoffParam, headerParam, tokenFileParam,
+	certFileParam, keyFileParam, caFileParam, jsonFieldParam,
+}
+
+var (
+	// ErrInvalidEndpoint is returned by Watch when the path does not start with the endpoint host
+	ErrInvalidEndpoint = errors.New("invalid endpoint")
+	// ErrInvalidInterval is returned by Watch when the interval or maxBackoff query param is invalid
+	ErrInvalidInterval = errors.New("invalid poll interval")
+	// ErrInvalidHeader is returned by Watch when a header query param is not "Name: value"
+	ErrInvalidHeader = errors.New("invalid header")
+	// ErrInvalidTLS is returned by Watch when the TLS query params are invalid
+	ErrInvalidTLS = errors.New("invalid TLS config")
+	// ErrUnexpectedStatus is returned when the endpoint responds with a status other than 200 or 304
+	ErrUnexpectedStatus = errors.New("unexpected status")
+	// ErrJSONField is returned when the JSON field is missing from the response, or is not a string
+	ErrJSONField = errors.New("invalid JSON field")
+)
+
+// NewStrategy implements a hotload strategy that monitors config changes
+// by polling an HTTP(S) endpoint.
+func NewStrategy(opts ...Option) *Strategy {
+	defOpts := newDefaultOptions()
+	for _, opt := range opts {
+		opt(defOpts)
+	}
+	client := defOpts.client
+	if client == nil {
+		client = &http.Client{}
+	}
+	s := &Strategy{
+		scheme:     defOpts.scheme,
+		client:     client,
+		interval:   defOpts.interval,
+		maxBackoff: defOpts.maxBackoff,
+		timeout:    defOpts.timeout,
+		polls:      make(map[string]*endpointPoll),
+	}
+	s.watches = fanout.New(s.scheme, s.onCloseWatch)
+	return s
+}
+
+// Strategy implements the hotload Strategy interface by periodically
+// requesting the watched endpoints.
+type Strategy struct {
+	mu         sync.Mutex
+	scheme     string
+	client     *http.Client
+	interval   time.Duration
+	maxBackoff time.Duration
+	timeout    time.Duration
+	watches    *fanout.Watches
+	polls      map[string]*endpointPoll
+}
+
+// endpointConfig is the endpoint and the request config of a watch
+type endpointConfig struct {
+	url        string
+	headers    http.Header
+	tokenFile  string
+	certFile   string
+	keyFile    string
+	caFile     string
+	jsonField  string
+	interval   time.Duration
+	maxBackoff time.Duration
+	// key identifies the polling of the endpoint with this config
+	key string
+}
+
+// endpointPoll is the polling state of a watched endpoint config
+type endpointPoll struct {
+	cfg    *endpointConfig
+	client *http.Client
+	cancel context.CancelFunc
+
+	// only accessed by the polling loop (once started)
+	etag         string
+	lastModified string
+	value        string
+	failures     int
+}
+
+// Watch implements the hotload.Strategy interface.
+// The endpoint is polled at the interval set by the interval query param
+// (eg: "interval=30s"), or at the strategy's default interval.
+func (s *Strategy) Watch(ctx context.Context, pth string, pathQry string) (value string, values <-chan string, err error) {
+	pathQry = strings.TrimSpace(pathQry)
+	cfg, err := s.parseConfig(pth, pathQry)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return s.watches.Watch(cfg.key, pathQry, func() (string, error) {
+		client, err := s.newClient(cfg)
+		if err != nil {
+			return "", err
+		}
+		ep := &endpointPoll{cfg: cfg, client: client}
+		val, _, err := s.fetch(ctx, ep)
+		if err != nil {
+			return "", err
+		}
+		ep.value = val
+		pollCtx, cancel := context.WithCancel(ctx)
+		ep.cancel = cancel
+		s.mu.Lock()
+		s.polls[cfg.key] = ep
+		s.mu.Unlock()
+		go s.pollLoop(pollCtx, ep)
+		return val, nil
+	})
+}
+
+// CloseWatch implements the hotload.Strategy interface.
+// Closes the specified watch by closing its update channel,
+// the endpoint is no longer polled once all its watches are closed.
+func (s *Strategy) CloseWatch(pth string, pathQry string) error {
+	pathQry = strings.TrimSpace(pathQry)
+	cfg, err := s.parseConfig(pth, pathQry)
+	if err != nil {
+		return err
+	}
+	s.watches.CloseWatch(cfg.key, pathQry)
+	return nil
+}
+
+// onCloseWatch stops polling the endpoint once its last query is closed
+func (s *Strategy) onCloseWatch(key, pathQry string, last bool) {
+	if !last {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ep, ok := s.polls[key]
+	if !ok {
+		return
+	}
+	ep.cancel()
+	delete(s.polls, key)
+	logger.DebugC(s.scheme+".processWatchClosure", "stopped polling endpoint", slog.String(logger.PathKey, ep.cfg.url))
+}
+
+// Close implements the hotload.Strategy interface.
+// Closes this strategy by stopping all the polling
+// and closing all the update channels.
+func (s *Strategy) Close() {
+	s.mu.Lock()
+	polls := s.polls
+	s.polls = make(map[string]*endpointPoll)
+	s.mu.Unlock()
+	for _, ep := range polls {
+		ep.cancel()
+	}
+	s.watches.Close()
+}
+
+// parseConfig parses the endpoint (the path, starting with the endpoint host)
+// and the request config (the query params)
+func (s *Strategy) parseConfig(pth, pathQry string) (*endpointConfig, error) {
+	vs, err := url.ParseQuery(pathQry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse query %v", pathQry)
+	}
+	u, err := url.Parse(s.scheme + "://" + strings.TrimPrefix(pth, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidEndpoint, pth)
+	}
+
+	cfg := &endpointConfig{
+		url:       u.String(),
+		headers:   make(http.Header),
+		tokenFile: vs.Get(tokenFileParam),
+		certFile:  vs.Get(certFileParam),
+		keyFile:   vs.Get(keyFileParam),
+		caFile:    vs.Get(caFileParam),
+		jsonField: vs.Get(jsonFieldParam),
+	}
+	if cfg.interval, err = backoff.ParseDuration(vs, intervalParam, s.interval, ErrInvalidInterval); err != nil {
+		return nil, err
+	}
+	if cfg.maxBackoff, err = backoff.ParseDuration(vs, maxBackoffParam, s.maxBackoff, ErrInvalidInterval); err != nil {
+		return nil, err
+	}
+	for _, h := range vs[headerParam] {
+		name, val, ok := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, h)
+		}
+		cfg.headers.Add(name, strings.TrimSpace(val))
+	}
+	if (cfg.certFile == "") != (cfg.keyFile == "") {
+		return nil, fmt.Errorf("%w: %s and %s must be set together", ErrInvalidTLS, certFileParam, keyFileParam)
+	}
+
+	keyVals := make(url.Values)
+	for _, param := range endpointParams {
+		if v, ok := vs[param]; ok {
+			keyVals[param] = v
+		}
+	}
+	cfg.key = cfg.url + "?" + keyVals.Encode()
+	return cfg, nil
+}
+
+// newClient returns the strategy's client, or a client with the TLS config
+// of the endpoint config. The client cert is read again at each TLS handshake,
+// so a rotated cert is used by the next connections.
+func (s *Strategy) newClient(cfg *endpointConfig) (*http.Client, error) {
+	if cfg.caFile == "" && cfg.certFile == "" {
+		return s.client, nil
+	}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.caFile != "" {
+		bs, err := os.ReadFile(cfg.caFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read %v", cfg.caFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bs) {
+			return nil, fmt.Errorf("%w: no certificate in %s", ErrInvalidTLS, cfg.caFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if cfg.certFile != "" {
+		if _, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidTLS, err)
+		}
+		tlsCfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		}
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.TLSClientConfig = tlsCfg
+	return &http.Client{Transport: tr}, nil
+}
+
+// fetch requests the endpoint, conditionally on the last ETag / Last-Modified received.
+// Returns false if the endpoint was not modified (304).
+func (s *Strategy) fetch(ctx context.Context, ep *endpointPoll) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.cfg.url, nil)
+	if err != nil {
+		return "", false, err
+	}
+	for name, vals := range ep.cfg.headers {
+		req.Header[name] = vals
+	}
+	if ep.cfg.tokenFile != "" {
+		// read at each request, so a rotated token is used by the next request
+		bs, err := os.ReadFile(ep.cfg.tokenFile)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "could not read %v", ep.cfg.tokenFile)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(bs)))
+	}
+	if ep.etag != "" {
+		req.Header.Set("If-None-Match", ep.etag)
+	}
+	if ep.lastModified != "" {
+		req.Header.Set("If-Modified-Since", ep.lastModified)
+	}
+
+	resp, err := ep.client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return "", false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("%w: %s", ErrUnexpectedStatus, resp.Status)
+	}
+	bs, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return "", false, errors.Wrapf(err, "could not read response of %v", ep.cfg.url)
+	}
+	val, err := extractValue(bs, ep.cfg.jsonField)
+	if err != nil {
+		return "", false, err
+	}
+	// only remembered once the response was valid, so that an invalid response is requested again
+	ep.etag = resp.Header.Get("ETag")
+	ep.lastModified = resp.Header.Get("Last-Modified")
+	return val, true, nil
+}
+
+// extractValue returns the trimmed body, or the string at the dot-separated
+// JSON field (eg: "data.dsn") of the body
+func extractValue(bs []byte, jsonField string) (string, error) {
+	if jsonField == "" {
+		return strings.TrimSpace(string(bs)), nil
+	}
+	var v any
+	if err := json.Unmarshal(bs, &v); err != nil {
+		return "", errors.Wrap(err, "could not parse JSON response")
+	}
+	for _, name := range strings.Split(jsonField, ".") {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("%w: %s", ErrJSONField, jsonField)
+		}
+		if v, ok = obj[name]; !ok {
+			return "", fmt.Errorf("%w: %s", ErrJSONField, jsonField)
+		}
+	}
+	val, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not a string", ErrJSONField, jsonField)
+	}
+	return strings.TrimSpace(val), nil
+}
+
+// nextDelay returns the interval, doubled for each consecutive failure up to maxBackoff
+func (ep *endpointPoll) nextDelay() time.Duration {
+	return backoff.Delay(ep.cfg.interval, ep.cfg.maxBackoff, ep.failures)
+}
+
+// pollLoop polls the endpoint until ctx is done (the endpoint is no longer watched)
+func (s *Strategy) pollLoop(ctx context.Context, ep *endpointPoll) {
+	logger.DebugC(s.scheme+".pollLoop", "started", slog.String(logger.PathKey, ep.cfg.url))
+	for {
+		timer := time.NewTimer(ep.nextDelay())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.DebugC(s.scheme+".pollLoop", "terminated", slog.String(logger.PathKey, ep.cfg.url))
+			return
+		case <-timer.C:
+			s.poll(ctx, ep)
+		}
+	}
+}
+
+// poll requests the endpoint, and sends its value to the watches if it changed
+func (s *Strategy) poll(ctx context.Context, ep *endpointPoll) {
+	val, modified, err := s.fetch(ctx, ep)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		if ep.failures == 0 {
+			// only log the first failure, until the endpoint responds again
+			logger.ErrorC(s.scheme+".poll", "poll failed",
+				slog.String(logger.PathKey, ep.cfg.url),
+				logger.Err(err),
+			)
+		}
+		ep.failures++
+		return
+	}
+	if ep.failures > 0 {
+		logger.DebugC(s.scheme+".poll", "poll recovered", slog.String(logger.PathKey, ep.cfg.url))
+		ep.failures = 0
+	}
+	if !modified || val == ep.value {
+		return
+	}
+	logger.DebugC(s.scheme+".poll", "value changed", slog.String(logger.PathKey, ep.cfg.url))
+	ep.value = val
+	s.watches.Set(ep.cfg.key, val)
+}
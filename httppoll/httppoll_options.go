@@ -0,0 +1,90 @@
+package httppoll
+
+import (
+	"net/http"
+	"time"
+)
+
+var (
+	// DefaultInterval is the default interval for polling endpoints
+	// (when the hotload connection string has no interval query param)
+	DefaultInterval = time.Second * 30
+
+	// DefaultMaxBackoff is the default longest delay between polls of a failing endpoint
+	// (when the hotload connection string has no maxBackoff query param)
+	DefaultMaxBackoff = time.Minute * 5
+
+	// DefaultTimeout is the default timeout of the requests
+	DefaultTimeout = time.Second * 10
+)
+
+type httpOptions struct {
+	scheme     string
+	client     *http.Client
+	interval   time.Duration
+	maxBackoff time.Duration
+	timeout    time.Duration
+}
+
+type Option func(*httpOptions)
+
+func newDefaultOptions() *httpOptions {
+	return &httpOptions{
+		scheme:     "https",
+		interval:   DefaultInterval,
+		maxBackoff: DefaultMaxBackoff,
+		timeout:    DefaultTimeout,
+	}
+}
+
+// WithScheme is the option to set the scheme of the polled endpoints ("http" or "https", the default)
+func WithScheme(scheme string) Option {
+	return func(opts *httpOptions) {
+		if scheme == "http" {
+			opts.scheme = scheme
+		} else {
+			opts.scheme = "https"
+		}
+	}
+}
+
+// WithHTTPClient is the option to set the HTTP client of the requests
+// (ignored by the watches with TLS query params, which use their own client)
+func WithHTTPClient(client *http.Client) Option {
+	return func(opts *httpOptions) {
+		opts.client = client
+	}
+}
+
+// WithInterval is the option to set the default polling interval
+func WithInterval(interval time.Duration) Option {
+	return func(opts *httpOptions) {
+		if interval <= 0 {
+			opts.interval = DefaultInterval
+		} else {
+			opts.interval = interval
+		}
+	}
+}
+
+// WithMaxBackoff is the option to set the default longest delay between polls of a failing endpoint
+func WithMaxBackoff(maxBackoff time.Duration) Option {
+	return func(opts *httpOptions) {
+		if maxBackoff <= 0 {
+			opts.maxBackoff = DefaultMaxBackoff
+		} else {
+			opts.maxBackoff = maxBackoff
+		}
+	}
+}
+
+// WithTimeout is the option to set the timeout of the requests
+func WithTimeout(timeout time.Duration) Option {
+	return func(opts *httpOptions) {
+		if timeout <= 0 {
+			opts.timeout = DefaultTimeout
+		} else {
+			opts.timeout = timeout
+		}
+	}
+}
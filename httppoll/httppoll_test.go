@@ -0,0 +1,293 @@
+package httppoll
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// configServer is a config service serving a body with an ETag
+type configServer struct {
+	mu          sync.Mutex
+	body        string
+	status      int
+	requests    int
+	notModified int
+	lastReq     *http.Request
+}
+
+func (cs *configServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.requests++
+	cs.lastReq = r
+	if cs.status != 0 {
+		w.WriteHeader(cs.status)
+		return
+	}
+	etag := `"` + cs.body + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		cs.notModified++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Write([]byte(cs.body))
+}
+
+func (cs *configServer) set(body string, status int) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.body = body
+	cs.status = status
+}
+
+func (cs *configServer) stats() (requests, notModified int) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.requests, cs.notModified
+}
+
+func (cs *configServer) header(name string) string {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.lastReq.Header.Get(name)
+}
+
+// endpointPath returns the hotload path of the endpoint of the server
+func endpointPath(srv *httptest.Server, pth string) string {
+	u, err := url.Parse(srv.URL)
+	Expect(err).ToNot(HaveOccurred())
+	return "/" + u.Host + pth
+}
+
+var _ = Describe("Strategy", func() {
+	var cs *configServer
+	var srv *httptest.Server
+	var s *Strategy
+	var pth string
+
+	BeforeEach(func() {
+		cs = &configServer{body: "a"}
+		srv = httptest.NewServer(cs)
+		DeferCleanup(srv.Close)
+		s = NewStrategy(WithScheme("http"), WithInterval(time.Hour))
+		DeferCleanup(s.Close)
+		pth = endpointPath(srv, "/v1/dsn")
+	})
+
+	It("Should return the value and send the changes, with conditional requests", func(ctx context.Context) {
+		value, values, err := s.Watch(ctx, pth, "interval=10ms")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("a"))
+
+		Eventually(func() int {
+			_, notModified := cs.stats()
+			return notModified
+		}).Should(BeNumerically(">=", 2))
+		Expect(values).ToNot(Receive())
+
+		cs.set("b\n", 0)
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should extract the JSON field", func(ctx context.Context) {
+		cs.set(`{"data": {"dsn": "a", "ttl": 30}}`, 0)
+		value, values, err := s.Watch(ctx, pth, "interval=10ms&jsonField=data.dsn")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("a"))
+
+		// the other fields changing does not send the value again
+		cs.set(`{"data": {"dsn": "a", "ttl": 60}}`, 0)
+		Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+
+		cs.set(`{"data": {"dsn": "b", "ttl": 60}}`, 0)
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should fail to watch a missing or non-string JSON field", func(ctx context.Context) {
+		cs.set(`{"data": {"dsn": "a", "ttl": 30}}`, 0)
+		_, _, err := s.Watch(ctx, pth, "jsonField=data.password")
+		Expect(errors.Is(err, ErrJSONField)).To(BeTrue())
+		_, _, err = s.Watch(ctx, pth, "jsonField=data.ttl")
+		Expect(errors.Is(err, ErrJSONField)).To(BeTrue())
+		Expect(s.polls).To(BeEmpty())
+	})
+
+	It("Should send the headers and the token read from the token file", func(ctx context.Context) {
+		tokenFile := filepath.Join(GinkgoT().TempDir(), "token")
+		Expect(os.WriteFile(tokenFile, []byte("t1\n"), 0o600)).To(Succeed())
+		qry := url.Values{
+			intervalParam:  []string{"10ms"},
+			headerParam:    []string{"X-Env: prod", "X-Team:db"},
+			tokenFileParam: []string{tokenFile},
+		}.Encode()
+		_, _, err := s.Watch(ctx, pth, qry)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cs.header("X-Env")).To(Equal("prod"))
+		Expect(cs.header("X-Team")).To(Equal("db"))
+		Expect(cs.header("Authorization")).To(Equal("Bearer t1"))
+
+		Expect(os.WriteFile(tokenFile, []byte("t2\n"), 0o600)).To(Succeed())
+		Eventually(func() string { return cs.header("Authorization") }).Should(Equal("Bearer t2"))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should keep polling while the endpoint fails", func(ctx context.Context) {
+		_, values, err := s.Watch(ctx, pth, "interval=10ms&maxBackoff=20ms")
+		Expect(err).ToNot(HaveOccurred())
+
+		cs.set("b", http.StatusServiceUnavailable)
+		Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+
+		cs.set("b", 0)
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should fan out to the watches of the same endpoint config, and stop polling once they are closed", func(ctx context.Context) {
+		_, values1, err := s.Watch(ctx, pth, "interval=10ms")
+		Expect(err).ToNot(HaveOccurred())
+		_, values2, err := s.Watch(ctx, pth, "forceKill=true&interval=10ms")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.polls).To(HaveLen(1))
+
+		cs.set("b", 0)
+		Eventually(values1).Should(Receive(Equal("b")))
+		Eventually(values2).Should(Receive(Equal("b")))
+
+		Expect(s.CloseWatch(pth, "interval=10ms")).To(Succeed())
+		Expect(s.CloseWatch(pth, "forceKill=true&interval=10ms")).To(Succeed())
+		Eventually(values1).Should(BeClosed())
+		Eventually(values2).Should(BeClosed())
+		Eventually(func() int {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return len(s.polls)
+		}).Should(BeZero())
+	}, NodeTimeout(5*time.Second))
+
+	It("Should fail to watch an unexpected status", func(ctx context.Context) {
+		cs.set("a", http.StatusForbidden)
+		_, _, err := s.Watch(ctx, pth, "")
+		Expect(errors.Is(err, ErrUnexpectedStatus)).To(BeTrue())
+	})
+
+	DescribeTable("Should fail to watch invalid params",
+		func(pth, qry string, wantErr error) {
+			_, _, err := s.Watch(context.Background(), pth, qry)
+			Expect(errors.Is(err, wantErr)).To(BeTrue())
+		},
+		Entry("no host", "/", "", ErrInvalidEndpoint),
+		Entry("interval", "/localhost/dsn", "interval=0s", ErrInvalidInterval),
+		Entry("maxBackoff", "/localhost/dsn", "maxBackoff=x", ErrInvalidInterval),
+		Entry("header", "/localhost/dsn", "header=X-Env", ErrInvalidHeader),
+		Entry("certFile without keyFile", "/localhost/dsn", "certFile=/tmp/cert.pem", ErrInvalidTLS),
+	)
+
+	DescribeTable("nextDelay",
+		func(failures int, want time.Duration) {
+			ep := &endpointPoll{
+				cfg:      &endpointConfig{interval: time.Second, maxBackoff: 5 * time.Second},
+				failures: failures,
+			}
+			Expect(ep.nextDelay()).To(Equal(want))
+		},
+		Entry("no failure", 0, time.Second),
+		Entry("1 failure", 1, 2*time.Second),
+		Entry("2 failures", 2, 4*time.Second),
+		Entry("capped", 3, 5*time.Second),
+		Entry("still capped", 100, 5*time.Second),
+	)
+
+	Context("TLS", func() {
+		It("Should verify the server with the CA file and authenticate with the client cert", func(ctx context.Context) {
+			dir := GinkgoT().TempDir()
+			clientCA, clientCAKey := newCert(nil, nil)
+			clientCert, clientKey := newCert(clientCA, clientCAKey)
+			certFile := writePEM(dir, "client.crt", "CERTIFICATE", clientCert.Raw)
+			keyBytes, err := x509.MarshalECPrivateKey(clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			keyFile := writePEM(dir, "client.key", "EC PRIVATE KEY", keyBytes)
+
+			tlsSrv := httptest.NewUnstartedServer(cs)
+			clientCAs := x509.NewCertPool()
+			clientCAs.AddCert(clientCA)
+			tlsSrv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+			tlsSrv.StartTLS()
+			DeferCleanup(tlsSrv.Close)
+			caFile := writePEM(dir, "ca.crt", "CERTIFICATE", tlsSrv.Certificate().Raw)
+
+			s := NewStrategy(WithInterval(time.Hour))
+			DeferCleanup(s.Close)
+			tlsPth := endpointPath(tlsSrv, "/v1/dsn")
+
+			// without the client cert
+			_, _, err = s.Watch(ctx, tlsPth, url.Values{caFileParam: []string{caFile}}.Encode())
+			Expect(err).To(HaveOccurred())
+
+			qry := url.Values{
+				caFileParam:   []string{caFile},
+				certFileParam: []string{certFile},
+				keyFileParam:  []string{keyFile},
+			}.Encode()
+			value, _, err := s.Watch(ctx, tlsPth, qry)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value).To(Equal("a"))
+		}, NodeTimeout(5*time.Second))
+
+		It("Should fail to watch with an invalid CA file", func(ctx context.Context) {
+			caFile := filepath.Join(GinkgoT().TempDir(), "ca.crt")
+			Expect(os.WriteFile(caFile, []byte("not a cert"), 0o600)).To(Succeed())
+			_, _, err := s.Watch(ctx, pth, caFileParam+"="+url.QueryEscape(caFile))
+			Expect(errors.Is(err, ErrInvalidTLS)).To(BeTrue())
+		})
+	})
+})
+
+// newCert returns a new certificate signed by the parent (or a self-signed CA if parent is nil)
+func newCert(parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "hotload-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	return cert, key
+}
+
+func writePEM(dir, name, typ string, der []byte) string {
+	pth := filepath.Join(dir, name)
+	bs := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	Expect(os.WriteFile(pth, bs, 0o600)).To(Succeed())
+	return pth
+}
@@ -0,0 +1,43 @@
+// Package awsconfig loads the AWS SDK default config
+// shared by the hotload strategies calling AWS.
+package awsconfig
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/pkg/errors"
+)
+
+// Loader loads the AWS SDK default config (env vars, shared config files, web identity,
+// container or instance role) on first use, and keeps it so that its credentials
+// provider caches and refreshes the credentials. The zero Loader is ready to use.
+type Loader struct {
+	mu  sync.Mutex
+	cfg *aws.Config
+}
+
+// Load returns the AWS SDK default config, loading it if it was not loaded yet
+func (l *Loader) Load(ctx context.Context) (aws.Config, error) {
+	l.mu.Lock()
+	cfg := l.cfg
+	l.mu.Unlock()
+	if cfg != nil {
+		return *cfg, nil
+	}
+
+	// load without the lock: concurrent first calls may each load the config,
+	// the first one stored is kept
+	loaded, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return aws.Config{}, errors.Wrap(err, "could not load the AWS config")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cfg == nil {
+		l.cfg = &loaded
+	}
+	return *l.cfg, nil
+}
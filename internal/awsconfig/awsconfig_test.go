@@ -0,0 +1,27 @@
+package awsconfig
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoadKeepsTheConfig(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	t.Setenv("AWS_REGION", "us-west-2")
+	var l Loader
+	cfg, err := l.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load() fail err=%v", err)
+	}
+	if cfg.Region != "us-west-2" {
+		t.Errorf("got Region=%q, expect=%q", cfg.Region, "us-west-2")
+	}
+
+	// not loaded again
+	t.Setenv("AWS_REGION", "eu-west-1")
+	cfg, err = l.Load(context.Background())
+	if err != nil || cfg.Region != "us-west-2" {
+		t.Errorf("got Load()=%q,%v, expect=%q,nil", cfg.Region, err, "us-west-2")
+	}
+}
@@ -0,0 +1,36 @@
+// Package backoff implements the polling intervals and retry delays
+// shared by the hotload strategies.
+package backoff
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// ParseDuration returns the duration of the query param, or defVal if it is not set.
+// The returned error wraps errInvalid if the duration is invalid or not positive.
+func ParseDuration(vs url.Values, param string, defVal time.Duration, errInvalid error) (time.Duration, error) {
+	v := vs.Get(param)
+	if v == "" {
+		return defVal, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%w: %s=%q", errInvalid, param, v)
+	}
+	return d, nil
+}
+
+// Delay returns interval doubled for each consecutive failure, up to maxBackoff
+// (interval is returned as is if maxBackoff is not longer)
+func Delay(interval, maxBackoff time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 0; i < failures && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff && maxBackoff > interval {
+		delay = maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,61 @@
+package backoff
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	errInvalid := errors.New("invalid")
+	tests := []struct {
+		query   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{query: "", want: time.Minute},
+		{query: "interval=10s", want: 10 * time.Second},
+		{query: "interval=-1s", wantErr: true},
+		{query: "interval=0s", wantErr: true},
+		{query: "interval=x", wantErr: true},
+	}
+	for _, tt := range tests {
+		vs, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatalf("ParseQuery(%q) fail err=%v", tt.query, err)
+		}
+		got, err := ParseDuration(vs, "interval", time.Minute, errInvalid)
+		if tt.wantErr {
+			if !errors.Is(err, errInvalid) {
+				t.Errorf("ParseDuration(%q) got err=%v, expect=%v", tt.query, err, errInvalid)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseDuration(%q) got=%v,%v, expect=%v,nil", tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		interval   time.Duration
+		maxBackoff time.Duration
+		failures   int
+		want       time.Duration
+	}{
+		{interval: time.Second, maxBackoff: 5 * time.Second, failures: 0, want: time.Second},
+		{interval: time.Second, maxBackoff: 5 * time.Second, failures: 1, want: 2 * time.Second},
+		{interval: time.Second, maxBackoff: 5 * time.Second, failures: 2, want: 4 * time.Second},
+		{interval: time.Second, maxBackoff: 5 * time.Second, failures: 3, want: 5 * time.Second},
+		{interval: time.Second, maxBackoff: 5 * time.Second, failures: 100, want: 5 * time.Second},
+		{interval: time.Minute, maxBackoff: time.Second, failures: 3, want: time.Minute},
+	}
+	for _, tt := range tests {
+		got := Delay(tt.interval, tt.maxBackoff, tt.failures)
+		if got != tt.want {
+			t.Errorf("Delay(%v, %v, %d) got=%v, expect=%v", tt.interval, tt.maxBackoff, tt.failures, got, tt.want)
+		}
+	}
+}
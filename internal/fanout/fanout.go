@@ -0,0 +1,431 @@
+// Package fanout implements the bookkeeping shared by the hotload strategies:
+// the watched paths, and for each path the queries (hotload connection strings)
+// its values are fanned out to.
+package fanout
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/infobloxopen/hotload"
+	"github.com/infobloxopen/hotload/internal"
+	"github.com/infobloxopen/hotload/logger"
+)
+
+const (
+	opSend  = "send"
+	opClose = "close"
+)
+
+// ErrClosed is returned by Watch when the watches are closed while the path is loaded
+var ErrClosed = errors.New("watches closed while loading the path")
+
+type pendingOperation struct {
+	operation string
+	dsn       string
+	redactDsn string
+}
+
+// queryWatch sends the values of its path to its update channel from its own goroutine,
+// so that a slow receiver does not block the other queries of the path.
+// Only that goroutine sends on and closes the update channel, it exits once done is closed.
+type queryWatch struct {
+	parentPathW *pathWatch
+	pathQuery   string
+	updateChan  chan string
+	operChan    chan pendingOperation
+	done        chan struct{}
+}
+
+type pathWatch struct {
+	parent    *Watches
+	watchPath string
+	value     string
+	queries   map[string]*queryWatch
+
+	// loaded is closed once the initial value is loaded, loadErr is set if that failed
+	loaded  chan struct{}
+	loadErr error
+	// set is true if Set was called while the initial value was loaded (its value is newer)
+	set bool
+	// sendMu keeps the values sent to the queries in the order they were set
+	sendMu sync.Mutex
+	// closing is closed once onClose returns for a query of the path (nil if not closing),
+	// closed is true once the last query is closed (the path is removed after onClose)
+	closing chan struct{}
+	closed  bool
+}
+
+// Watches is the set of paths watched by a strategy
+type Watches struct {
+	mu        sync.Mutex
+	component string
+	paths     map[string]*pathWatch
+	onClose   func(pth, pathQry string, last bool)
+}
+
+// New returns an empty set of watches, component prefixes the log components (eg: "fsnotify").
+// onClose (if not nil) is called, without the watches locked, when a query of a path is closed,
+// last is true if it was the last query of the path (which is no longer watched).
+// It is also called, with last true, when the watches are closed while the path was loaded.
+// The watches of the path wait for onClose to return, so it is not called concurrently
+// with the load of the path.
+func New(component string, onClose func(pth, pathQry string, last bool)) *Watches {
+	return &Watches{
+		component: component,
+		paths:     make(map[string]*pathWatch),
+		onClose:   onClose,
+	}
+}
+
+// Watch returns the value of the path and the update channel of the query.
+// If the path is not watched yet, load is called (without the watches locked)
+// to get its initial value, the concurrent watches of the path wait for that load
+// and share its result. The path is not watched if load fails.
+func (w *Watches) Watch(pth, pathQry string, load func() (string, error)) (string, <-chan string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var pathW *pathWatch
+	for pathW == nil {
+		found := false
+		pathW, found = w.paths[pth]
+		if !found {
+			logger.DebugC(w.component+".Watch", "new path to be watched", slog.String(logger.PathKey, pth))
+			pathW = &pathWatch{
+				parent:    w,
+				watchPath: pth,
+				queries:   make(map[string]*queryWatch),
+				loaded:    make(chan struct{}),
+			}
+			w.paths[pth] = pathW
+			if err := w.load(pathW, pathQry, load); err != nil {
+				return "", nil, err
+			}
+			break
+		}
+		logger.DebugC(w.component+".Watch", "path already being watched",
+			slog.String(logger.PathKey, pathW.watchPath),
+		)
+		w.waitClosing(pathW)
+		if err := w.waitLoaded(pathW); err != nil {
+			return "", nil, err
+		}
+		if w.paths[pth] != pathW {
+			// closed meanwhile, watch it again
+			pathW = nil
+		}
+	}
+
+	qryW, found := pathW.queries[pathQry]
+	if found {
+		logger.DebugC(w.component+".Watch", "query already being watched",
+			slog.String(logger.PathKey, qryW.parentPathW.watchPath),
+			slog.String(logger.QueryKey, qryW.pathQuery),
+		)
+	} else {
+		logger.DebugC(w.component+".Watch", "new query to be watched",
+			slog.String(logger.PathKey, pathW.watchPath),
+			slog.String(logger.QueryKey, pathQry),
+		)
+		qryW = &queryWatch{
+			parentPathW: pathW,
+			pathQuery:   pathQry,
+			updateChan:  make(chan string),
+			operChan:    make(chan pendingOperation, 30),
+			done:        make(chan struct{}),
+		}
+		pathW.queries[pathQry] = qryW
+		go qryW.opLoop()
+	}
+
+	return pathW.value, qryW.updateChan, nil
+}
+
+// load calls load with the watches unlocked (they must be locked),
+// and wakes up the watches of the path waiting for it
+func (w *Watches) load(pathW *pathWatch, pathQry string, load func() (string, error)) error {
+	w.mu.Unlock()
+	value, err := load()
+	w.mu.Lock()
+	defer close(pathW.loaded)
+	if err != nil {
+		pathW.loadErr = err
+		if w.paths[pathW.watchPath] == pathW {
+			delete(w.paths, pathW.watchPath)
+		}
+		return err
+	}
+	if curPathW, found := w.paths[pathW.watchPath]; curPathW != pathW {
+		// closed meanwhile, let the strategy release what load acquired
+		// (unless the path is watched again)
+		pathW.loadErr = ErrClosed
+		logger.DebugC(w.component+".Watch", "closed while loading path",
+			slog.String(logger.PathKey, pathW.watchPath),
+		)
+		if !found {
+			pathW.closed = true
+			w.paths[pathW.watchPath] = pathW
+			w.callOnClose(pathW, pathQry, true)
+		}
+		return ErrClosed
+	}
+	if !pathW.set {
+		pathW.value = value
+	}
+	return nil
+}
+
+// waitLoaded waits, with the watches unlocked (they must be locked),
+// for the path to be loaded and returns the error of the load
+func (w *Watches) waitLoaded(pathW *pathWatch) error {
+	select {
+	case <-pathW.loaded:
+		return pathW.loadErr
+	default:
+	}
+	w.mu.Unlock()
+	<-pathW.loaded
+	w.mu.Lock()
+	return pathW.loadErr
+}
+
+// waitClosing waits, with the watches unlocked (they must be locked),
+// until onClose returns for the queries of the path being closed
+func (w *Watches) waitClosing(pathW *pathWatch) {
+	for pathW.closing != nil {
+		closing := pathW.closing
+		w.mu.Unlock()
+		<-closing
+		w.mu.Lock()
+	}
+}
+
+// callOnClose calls onClose with the watches unlocked (they must be locked, and the
+// path must not be closing), and removes the path once its last query is closed
+func (w *Watches) callOnClose(pathW *pathWatch, pathQry string, last bool) {
+	if w.onClose != nil {
+		closing := make(chan struct{})
+		pathW.closing = closing
+		w.mu.Unlock()
+		w.onClose(pathW.watchPath, pathQry, last)
+		w.mu.Lock()
+		pathW.closing = nil
+		close(closing)
+	}
+	if last && w.paths[pathW.watchPath] == pathW {
+		delete(w.paths, pathW.watchPath)
+	}
+}
+
+// isLoaded returns true once the initial value of the path is loaded
+func (pw *pathWatch) isLoaded() bool {
+	select {
+	case <-pw.loaded:
+		return true
+	default:
+		return false
+	}
+}
+
+// Set sets the value of the path and sends it to all the queries of the path,
+// returns false if the path is not watched.
+// If the path is being loaded, the value replaces the loaded one.
+func (w *Watches) Set(pth, val string) bool {
+	w.mu.Lock()
+	pathW, ok := w.paths[pth]
+	if !ok || pathW.closed {
+		w.mu.Unlock()
+		logger.DebugC(w.component+".setVal", "ignoring path not in map", slog.String(logger.PathKey, pth))
+		return false
+	}
+	w.mu.Unlock()
+
+	// the queries are sent the value outside the lock, so that a full queue
+	// blocks only the values of this path
+	pathW.sendMu.Lock()
+	defer pathW.sendMu.Unlock()
+	w.mu.Lock()
+	pathW.value = val
+	pathW.set = true
+	queries := make([]*queryWatch, 0, len(pathW.queries))
+	for _, qryW := range pathW.queries {
+		queries = append(queries, qryW)
+	}
+	w.mu.Unlock()
+
+	redactDsn := internal.RedactUrl(val)
+	for _, qryW := range queries {
+		qryW.queue(pendingOperation{
+			operation: opSend,
+			dsn:       val,
+			redactDsn: redactDsn,
+		})
+	}
+	return true
+}
+
+// Value returns the current value of the path, false if the path is not watched
+// (or its initial value is still being loaded)
+func (w *Watches) Value(pth string) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pathW, ok := w.paths[pth]
+	if !ok || !pathW.isLoaded() || pathW.closed {
+		return "", false
+	}
+	return pathW.value, true
+}
+
+// Paths returns the watched paths (whose initial value is loaded)
+func (w *Watches) Paths() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	paths := make([]string, 0, len(w.paths))
+	for pth, pathW := range w.paths {
+		if pathW.isLoaded() && !pathW.closed {
+			paths = append(paths, pth)
+		}
+	}
+	return paths
+}
+
+// CloseWatch closes the query of the path: its update channel is closed
+// once the values queued before are sent
+func (w *Watches) CloseWatch(pth, pathQry string) {
+	w.mu.Lock()
+	pathW, found := w.paths[pth]
+	if !found {
+		w.mu.Unlock()
+		return
+	}
+	qryW, ok := pathW.queries[pathQry]
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+	// queued after the values set before
+	pathW.sendMu.Lock()
+	defer pathW.sendMu.Unlock()
+	qryW.queue(pendingOperation{operation: opClose})
+	logger.DebugC(w.component+".CloseWatch", "sent pending close operation",
+		slog.String(logger.PathKey, qryW.parentPathW.watchPath),
+		slog.String(logger.QueryKey, qryW.pathQuery),
+	)
+}
+
+// Close stops all the queries, their update channels are closed shortly after,
+// no path is watched anymore (the watches can be used again)
+func (w *Watches) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, pathW := range w.paths {
+		for _, qryW := range pathW.queries {
+			close(qryW.done)
+			logger.DebugC(w.component+".Close", "stopped query",
+				slog.String(logger.PathKey, qryW.parentPathW.watchPath),
+				slog.String(logger.QueryKey, qryW.pathQuery),
+			)
+		}
+		pathW.queries = nil
+	}
+	w.paths = make(map[string]*pathWatch)
+}
+
+func (w *Watches) processWatchClosure(qryW *queryWatch) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pathW := qryW.parentPathW
+	// one query of the path is closed at a time
+	w.waitClosing(pathW)
+	if pathW.queries[qryW.pathQuery] != qryW {
+		// closed already (eg: by Close)
+		return
+	}
+	delete(pathW.queries, qryW.pathQuery)
+	logger.DebugC(w.component+".processWatchClosure", "removed query",
+		slog.String(logger.PathKey, qryW.parentPathW.watchPath),
+		slog.String(logger.QueryKey, qryW.pathQuery),
+	)
+
+	last := len(pathW.queries) <= 0
+	if last {
+		pathW.closed = true
+		logger.DebugC(w.component+".processWatchClosure", "strategy removed path",
+			slog.String(logger.PathKey, pathW.watchPath))
+	}
+	w.callOnClose(pathW, qryW.pathQuery, last)
+}
+
+// queue queues the operation, unless the query is stopped
+func (qw *queryWatch) queue(pendOp pendingOperation) {
+	select {
+	case qw.operChan <- pendOp:
+	case <-qw.done:
+		hotload.ForgetEmitted(qw.updateChan)
+	}
+}
+
+func (qw *queryWatch) sendUpdate(val, redactDsn string) {
+	logger.DebugC(qw.component()+".sendUpdate", "block-sending",
+		slog.String(logger.PathKey, qw.parentPathW.watchPath),
+		slog.String(logger.QueryKey, qw.pathQuery),
+		slog.String(logger.DsnKey, redactDsn),
+	)
+	hotload.MarkEmitted(qw.updateChan)
+	select {
+	case qw.updateChan <- val:
+		logger.DebugC(qw.component()+".sendUpdate", "successfully sent",
+			slog.String(logger.PathKey, qw.parentPathW.watchPath),
+			slog.String(logger.QueryKey, qw.pathQuery),
+			slog.String(logger.DsnKey, redactDsn),
+		)
+	case <-qw.done:
+		hotload.ForgetEmitted(qw.updateChan)
+	}
+}
+
+func (qw *queryWatch) opLoop() {
+	defer func() {
+		close(qw.updateChan)
+		hotload.ForgetEmitted(qw.updateChan)
+		logger.DebugC(qw.component()+".opLoop", "closed update channel",
+			slog.String(logger.PathKey, qw.parentPathW.watchPath),
+			slog.String(logger.QueryKey, qw.pathQuery),
+		)
+	}()
+	for {
+		var pendOp pendingOperation
+		select {
+		case <-qw.done:
+			return
+		case pendOp = <-qw.operChan:
+		}
+		switch pendOp.operation {
+		case opClose:
+			logger.DebugC(qw.component()+".opLoop", "pendingOperation",
+				slog.String(logger.PathKey, qw.parentPathW.watchPath),
+				slog.String(logger.QueryKey, qw.pathQuery),
+				slog.String("operation", pendOp.operation))
+			qw.parentPathW.parent.processWatchClosure(qw)
+			return
+		case opSend:
+			logger.DebugC(qw.component()+".opLoop", "pendingOperation",
+				slog.String(logger.PathKey, qw.parentPathW.watchPath),
+				slog.String(logger.QueryKey, qw.pathQuery),
+				slog.String("operation", pendOp.operation),
+				slog.String(logger.DsnKey, pendOp.redactDsn),
+			)
+			qw.sendUpdate(pendOp.dsn, pendOp.redactDsn)
+		default:
+			logger.DebugC(qw.component()+".opLoop", "ignore invalid pendingOperation",
+				slog.String(logger.PathKey, qw.parentPathW.watchPath),
+				slog.String(logger.QueryKey, qw.pathQuery),
+				slog.String("operation", pendOp.operation))
+		}
+	}
+}
+
+func (qw *queryWatch) component() string {
+	return qw.parentPathW.parent.component
+}
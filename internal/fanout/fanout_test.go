@@ -0,0 +1,256 @@
+package fanout
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchLoadsOutsideTheLock(t *testing.T) {
+	w := New("test", nil)
+	if _, _, err := w.Watch("other", "", func() (string, error) { return "other-val", nil }); err != nil {
+		t.Fatalf("Watch(other) fail err=%v", err)
+	}
+
+	var loads int32
+	release := make(chan struct{})
+	type result struct {
+		val string
+		err error
+	}
+	results := make(chan result, 3)
+	for i := 0; i < 3; i++ {
+		qry := string(rune('a' + i))
+		go func() {
+			val, _, err := w.Watch("pth", qry, func() (string, error) {
+				atomic.AddInt32(&loads, 1)
+				<-release
+				return "val", nil
+			})
+			results <- result{val, err}
+		}()
+	}
+
+	// the other paths can be read and set while pth loads
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if val, ok := w.Value("other"); !ok || val != "other-val" {
+			t.Errorf("got Value(other)=%q,%v, expect=%q,true", val, ok, "other-val")
+		}
+		w.Set("other", "other-val2")
+		if _, ok := w.Value("pth"); ok {
+			t.Errorf("got Value(pth) ok while loading, expect=false")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Value/Set blocked by the load of another path")
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		r := <-results
+		if r.err != nil || r.val != "val" {
+			t.Errorf("got Watch(pth)=%q,%v, expect=%q,nil", r.val, r.err, "val")
+		}
+	}
+	if got := atomic.LoadInt32(&loads); got != 1 {
+		t.Errorf("got %d loads, expect=1", got)
+	}
+	w.Close()
+}
+
+func TestWatchSharesLoadError(t *testing.T) {
+	w := New("test", nil)
+	errLoad := errors.New("load failed")
+	release := make(chan struct{})
+	started := make(chan struct{})
+	errs := make(chan error, 2)
+	go func() {
+		_, _, err := w.Watch("pth", "a", func() (string, error) {
+			close(started)
+			<-release
+			return "", errLoad
+		})
+		errs <- err
+	}()
+	<-started
+	go func() {
+		_, _, err := w.Watch("pth", "b", func() (string, error) {
+			t.Error("load called twice")
+			return "", nil
+		})
+		errs <- err
+	}()
+	// let the second watch wait for the load
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, errLoad) {
+			t.Errorf("got err=%v, expect=%v", err, errLoad)
+		}
+	}
+	if paths := w.Paths(); len(paths) != 0 {
+		t.Errorf("got Paths()=%v, expect none", paths)
+	}
+
+	// the path can be watched again
+	val, _, err := w.Watch("pth", "a", func() (string, error) { return "val", nil })
+	if err != nil || val != "val" {
+		t.Errorf("got Watch(pth)=%q,%v, expect=%q,nil", val, err, "val")
+	}
+	w.Close()
+}
+
+func TestSetWhileLoadingWins(t *testing.T) {
+	w := New("test", nil)
+	val, _, err := w.Watch("pth", "a", func() (string, error) {
+		// eg: the change is received by the watch started by load
+		go w.Set("pth", "new-val")
+		time.Sleep(50 * time.Millisecond)
+		return "old-val", nil
+	})
+	if err != nil || val != "new-val" {
+		t.Errorf("got Watch(pth)=%q,%v, expect=%q,nil", val, err, "new-val")
+	}
+	w.Close()
+}
+
+func TestCloseWhileLoading(t *testing.T) {
+	var mu sync.Mutex
+	var closed []string
+	w := New("test", func(pth, pathQry string, last bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		if last {
+			closed = append(closed, pth)
+		}
+	})
+	_, _, err := w.Watch("pth", "a", func() (string, error) {
+		w.Close()
+		return "val", nil
+	})
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("got err=%v, expect=%v", err, ErrClosed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(closed) != 1 || closed[0] != "pth" {
+		t.Errorf("got closed paths=%v, expect=[pth]", closed)
+	}
+}
+
+func TestCloseStopsQueries(t *testing.T) {
+	w := New("test", nil)
+	_, values, err := w.Watch("pth", "a", func() (string, error) { return "val", nil })
+	if err != nil {
+		t.Fatalf("Watch(pth) fail err=%v", err)
+	}
+	// the update channel is not read: fill the operation queue
+	setDone := make(chan struct{})
+	go func() {
+		defer close(setDone)
+		for i := 0; i < 50; i++ {
+			w.Set("pth", "val")
+		}
+	}()
+	// Value is not blocked by the full queue
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := w.Value("pth"); !ok {
+		t.Errorf("got Value(pth) not ok, expect=true")
+	}
+
+	w.Close()
+	select {
+	case <-setDone:
+	case <-time.After(time.Second):
+		t.Fatal("Set still blocked after Close")
+	}
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-values:
+			if !ok {
+				// no send on the closed channel
+				w.Set("pth", "val2")
+				w.CloseWatch("pth", "a")
+				return
+			}
+		case <-timeout:
+			t.Fatal("update channel not closed after Close")
+		}
+	}
+}
+
+func TestCloseWatchAfterValues(t *testing.T) {
+	w := New("test", nil)
+	_, values, err := w.Watch("pth", "a", func() (string, error) { return "val", nil })
+	if err != nil {
+		t.Fatalf("Watch(pth) fail err=%v", err)
+	}
+	w.Set("pth", "val2")
+	w.Set("pth", "val3")
+	w.CloseWatch("pth", "a")
+	var got []string
+	for val := range values {
+		got = append(got, val)
+	}
+	if len(got) != 2 || got[0] != "val2" || got[1] != "val3" {
+		t.Errorf("got values=%v, expect=[val2 val3]", got)
+	}
+	if paths := w.Paths(); len(paths) != 0 {
+		t.Errorf("got Paths()=%v, expect none", paths)
+	}
+}
+
+func TestOnCloseUnlocked(t *testing.T) {
+	var w *Watches
+	inOnClose := make(chan struct{})
+	release := make(chan struct{})
+	w = New("test", func(pth, pathQry string, last bool) {
+		// the watches can be used from onClose
+		if _, ok := w.Value("other"); !ok {
+			t.Errorf("got Value(other) not ok, expect=true")
+		}
+		close(inOnClose)
+		<-release
+	})
+	if _, _, err := w.Watch("other", "a", func() (string, error) { return "other-val", nil }); err != nil {
+		t.Fatalf("Watch(other) fail err=%v", err)
+	}
+	_, values, err := w.Watch("pth", "a", func() (string, error) { return "val", nil })
+	if err != nil {
+		t.Fatalf("Watch(pth) fail err=%v", err)
+	}
+	w.CloseWatch("pth", "a")
+	<-inOnClose
+
+	// the path is loaded again once onClose returned
+	loaded := make(chan string, 1)
+	go func() {
+		val, _, err := w.Watch("pth", "a", func() (string, error) { return "val2", nil })
+		if err != nil {
+			t.Errorf("Watch(pth) fail err=%v", err)
+		}
+		loaded <- val
+	}()
+	select {
+	case <-loaded:
+		t.Fatal("path watched again before onClose returned")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, ok := w.Value("pth"); ok {
+		t.Errorf("got Value(pth) ok while closing, expect=false")
+	}
+	close(release)
+	if val := <-loaded; val != "val2" {
+		t.Errorf("got Watch(pth)=%q, expect=%q", val, "val2")
+	}
+	for range values {
+	}
+	w.Close()
+}
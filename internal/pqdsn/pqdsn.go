@@ -0,0 +1,124 @@
+// Package pqdsn parses and formats libpq key/value connection strings
+// (eg: "host=db port=5432 user=app password='p w'").
+package pqdsn
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalid is returned by Parse when the connection string is malformed
+var ErrInvalid = errors.New("invalid key/value connection string")
+
+// Pair is a key/value pair of a connection string
+type Pair struct {
+	Key   string
+	Value string
+}
+
+// Pairs are the key/value pairs of a connection string, in order
+type Pairs []Pair
+
+// Parse parses the key/value pairs of the connection string. Values may be single
+// quoted, and backslash escapes the next character (in quoted or unquoted values).
+func Parse(dsn string) (Pairs, error) {
+	var pairs Pairs
+	rs := []rune(dsn)
+	i := 0
+	skipSpaces := func() {
+		for i < len(rs) && unicode.IsSpace(rs[i]) {
+			i++
+		}
+	}
+	for {
+		skipSpaces()
+		if i >= len(rs) {
+			return pairs, nil
+		}
+		start := i
+		for i < len(rs) && rs[i] != '=' && !unicode.IsSpace(rs[i]) {
+			i++
+		}
+		key := string(rs[start:i])
+		skipSpaces()
+		if i >= len(rs) || rs[i] != '=' || key == "" {
+			return nil, fmt.Errorf("%w: missing \"=\" after %q", ErrInvalid, key)
+		}
+		i++
+		skipSpaces()
+
+		var val strings.Builder
+		quoted := i < len(rs) && rs[i] == '\''
+		if quoted {
+			i++
+		}
+		closed := false
+		for i < len(rs) {
+			r := rs[i]
+			if r == '\\' && i+1 < len(rs) {
+				val.WriteRune(rs[i+1])
+				i += 2
+				continue
+			}
+			if quoted && r == '\'' {
+				i++
+				closed = true
+				break
+			}
+			if !quoted && unicode.IsSpace(r) {
+				break
+			}
+			val.WriteRune(r)
+			i++
+		}
+		if quoted && !closed {
+			return nil, fmt.Errorf("%w: unterminated quoted value of %q", ErrInvalid, key)
+		}
+		pairs = append(pairs, Pair{Key: key, Value: val.String()})
+	}
+}
+
+// Get returns the value of the last pair with the key
+func (pairs Pairs) Get(key string) string {
+	val := ""
+	for _, p := range pairs {
+		if p.Key == key {
+			val = p.Value
+		}
+	}
+	return val
+}
+
+// Set sets the value of the pairs with the key, or appends a pair
+func (pairs Pairs) Set(key, value string) Pairs {
+	found := false
+	for i := range pairs {
+		if pairs[i].Key == key {
+			pairs[i].Value = value
+			found = true
+		}
+	}
+	if !found {
+		pairs = append(pairs, Pair{Key: key, Value: value})
+	}
+	return pairs
+}
+
+// String formats the pairs as a connection string
+func (pairs Pairs) String() string {
+	strs := make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		strs = append(strs, p.Key+"="+Quote(p.Value))
+	}
+	return strings.Join(strs, " ")
+}
+
+// Quote quotes a connection string value if needed
+func Quote(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\n'\\") {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
@@ -0,0 +1,71 @@
+package pqdsn
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		expPairs Pairs
+	}{
+		{"", nil},
+		{"host=db port=5432", Pairs{{"host", "db"}, {"port", "5432"}}},
+		{" host = db\tuser=app ", Pairs{{"host", "db"}, {"user", "app"}}},
+		{"password='p w' dbname=''", Pairs{{"password", "p w"}, {"dbname", ""}}},
+		{`password='p\'\\w'`, Pairs{{"password", `p'\w`}}},
+		{`password=p\ w`, Pairs{{"password", "p w"}}},
+	}
+	for _, tt := range tests {
+		pairs, err := Parse(tt.dsn)
+		if err != nil {
+			t.Errorf("Parse(%q) fail err=%v", tt.dsn, err)
+			continue
+		}
+		if !reflect.DeepEqual(pairs, tt.expPairs) {
+			t.Errorf("Parse(%q) got %#v, expected %#v", tt.dsn, pairs, tt.expPairs)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, dsn := range []string{"host", "host db", "=db", "password='p w"} {
+		if _, err := Parse(dsn); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Parse(%q) got err=%v, expected %v", dsn, err, ErrInvalid)
+		}
+	}
+}
+
+func TestPairs(t *testing.T) {
+	pairs, err := Parse("host=db user=app password=old")
+	if err != nil {
+		t.Fatalf("Parse fail err=%v", err)
+	}
+	if got := pairs.Get("user"); got != "app" {
+		t.Errorf("got user=%q, expected %q", got, "app")
+	}
+	pairs = pairs.Set("password", "n'w").Set("sslmode", "require")
+	exp := `host=db user=app password='n\'w' sslmode=require`
+	if got := pairs.String(); got != exp {
+		t.Errorf("got %q, expected %q", got, exp)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		v   string
+		exp string
+	}{
+		{"p@ss", "p@ss"},
+		{"", "''"},
+		{"p ss", "'p ss'"},
+		{`p'\s`, `'p\'\\s'`},
+	}
+	for _, tt := range tests {
+		if got := Quote(tt.v); got != tt.exp {
+			t.Errorf("Quote(%q) got %q, expected %q", tt.v, got, tt.exp)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+// Package k8ssecret implements a hotload strategy for Kubernetes Secret (or ConfigMap)
+// volumes. Kubernetes updates a mounted Secret by writing the new keys to a new
+// timestamped directory, then atomically swapping the "..data" symlink to it
+// (the key files are symlinks through "..data"). The strategy watches the mount
+// directory, resolves the "..data" symlink, and sends exactly one update per swap.
+//
+// Import it to register the "k8ssecret" strategy, the path is the key file
+// in the mount directory:
+//
+//	import _ "github.com/infobloxopen/hotload/k8ssecret"
+//
+//	db, err := sql.Open("hotload", "k8ssecret://postgres/etc/db-secret/dsn")
+package k8ssecret
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	rfsnotify "github.com/fsnotify/fsnotify"
+	"github.com/infobloxopen/hotload"
+	"github.com/infobloxopen/hotload/internal/fanout"
+	"github.com/infobloxopen/hotload/logger"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	hotload.RegisterStrategy("k8ssecret", NewStrategy())
+}
+
+// dataLink is the symlink swapped by Kubernetes to update the volume
+const dataLink = "..data"
+
+// NewStrategy implements a hotload strategy that monitors config changes
+// in a Kubernetes Secret volume.
+func NewStrategy() *Strategy {
+	s := &Strategy{
+		dirs: make(map[string]*mountDir),
+	}
+	s.watches = fanout.New("k8ssecret", s.onCloseWatch)
+	return s
+}
+
+// Strategy implements the hotload Strategy interface by watching
+// the mount directories with fsnotify.
+type Strategy struct {
+	mu sync.Mutex
+	// regMu serializes the changes of the watched directories, so that the watcher
+	// Add and Remove calls (made without mu) are applied in the order of the changes
+	regMu   sync.Mutex
+	watcher *rfsnotify.Watcher
+	watches *fanout.Watches
+	dirs    map[string]*mountDir
+}
+
+// mountDir is a watched mount directory, shared by the watched keys of the volume
+type mountDir struct {
+	dir  string
+	keys map[string]*secretKey
+}
+
+// secretKey is a watched key file
+type secretKey struct {
+	pth string
+	key string
+	keyState
+}
+
+// keyState is the last state read of a key file
+type keyState struct {
+	// target is the last resolved "..data" target ("" if the directory has no "..data")
+	target string
+	value  string
+}
+
+// Watch implements the hotload.Strategy interface.
+func (s *Strategy) Watch(ctx context.Context, pth string, pathQry string) (value string, values <-chan string, err error) {
+	pth = filepath.Clean(pth)
+	pathQry = strings.TrimSpace(pathQry)
+	return s.watches.Watch(pth, pathQry, func() (string, error) {
+		return s.addKey(pth)
+	})
+}
+
+// addKey reads the key file and starts watching its mount directory
+func (s *Strategy) addKey(pth string) (string, error) {
+	dir, key := filepath.Split(pth)
+	dir = filepath.Clean(dir)
+	target, err := resolveData(dir)
+	if err != nil {
+		return "", err
+	}
+	val, err := readKey(dir, target, key)
+	if err != nil {
+		return "", err
+	}
+
+	w, err := s.getWatcher()
+	if err != nil {
+		return "", err
+	}
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
+	s.mu.Lock()
+	_, found := s.dirs[dir]
+	s.mu.Unlock()
+	if !found {
+		if err := w.Add(dir); err != nil {
+			return "", err
+		}
+		logger.DebugC("k8ssecret.Watch", "new mount directory to be watched", slog.String(logger.PathKey, dir))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	md, found := s.dirs[dir]
+	if !found {
+		md = &mountDir{dir: dir, keys: make(map[string]*secretKey)}
+		s.dirs[dir] = md
+	}
+	md.keys[key] = &secretKey{pth: pth, key: key, keyState: keyState{target: target, value: val}}
+	return val, nil
+}
+
+// getWatcher returns the watcher, and starts it if this is the first time
+// this strategy is called
+func (s *Strategy) getWatcher() (*rfsnotify.Watcher, error) {
+	s.mu.Lock()
+	w := s.watcher
+	s.mu.Unlock()
+	if w != nil {
+		return w, nil
+	}
+
+	// created without the lock: concurrent first calls may each create a watcher,
+	// the first one stored is kept
+	w, err := rfsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.watcher != nil {
+		cur := s.watcher
+		s.mu.Unlock()
+		w.Close()
+		return cur, nil
+	}
+	s.watcher = w
+	s.mu.Unlock()
+	go s.runLoop(w)
+	return w, nil
+}
+
+// CloseWatch implements the hotload.Strategy interface.
+// Closes the specified watch by closing its update channel, the mount
+// directory is no longer watched once the watches of all its keys are closed.
+func (s *Strategy) CloseWatch(pth string, pathQry string) error {
+	pth = filepath.Clean(pth)
+	pathQry = strings.TrimSpace(pathQry)
+	s.watches.CloseWatch(pth, pathQry)
+	return nil
+}
+
+// onCloseWatch forgets the key once its last query is closed,
+// and removes the mount directory from the watcher once it has no more keys
+func (s *Strategy) onCloseWatch(pth, pathQry string, last bool) {
+	if !last {
+		return
+	}
+	dir, key := filepath.Split(pth)
+	dir = filepath.Clean(dir)
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
+	s.mu.Lock()
+	md, ok := s.dirs[dir]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	delete(md.keys, key)
+	if len(md.keys) > 0 {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.dirs, dir)
+	w := s.watcher
+	s.mu.Unlock()
+	if w == nil {
+		return
+	}
+	if err := w.Remove(dir); err != nil {
+		logger.ErrorC("k8ssecret.processWatchClosure", "failed to remove mount directory from watcher",
+			slog.String(logger.PathKey, dir),
+			logger.Err(err),
+		)
+		return
+	}
+	logger.DebugC("k8ssecret.processWatchClosure", "removed mount directory from being watched",
+		slog.String(logger.PathKey, dir))
+}
+
+// Close implements the hotload.Strategy interface.
+// Closes this strategy by closing the internal watcher
+// and closing all the update channels.
+func (s *Strategy) Close() {
+	s.mu.Lock()
+	if s.watcher != nil {
+		s.watcher.Close()
+		logger.DebugC("k8ssecret.Close", "closed internal watcher")
+		s.watcher = nil
+	}
+	s.dirs = make(map[string]*mountDir)
+	s.mu.Unlock()
+	s.watches.Close()
+}
+
+func (s *Strategy) runLoop(watcher *rfsnotify.Watcher) {
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				logger.DebugC("k8ssecret.runLoop", "Events chan closed, terminating")
+				return
+			}
+			logger.DebugC("k8ssecret.runLoop", "got event",
+				slog.String(logger.PathKey, ev.Name),
+				slog.String("op", ev.Op.String()),
+			)
+			if ev.Op == rfsnotify.Chmod {
+				continue
+			}
+			s.checkDir(filepath.Dir(ev.Name))
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				logger.DebugC("k8ssecret.runLoop", "Errors chan closed, terminating")
+				return
+			}
+			if errors.Is(err, rfsnotify.ErrEventOverflow) {
+				// events were missed, any mount directory may have been swapped
+				logger.ErrorC("k8ssecret.runLoop", "event overflow, checking all mount directories", logger.Err(err))
+				for _, dir := range s.mountDirs() {
+					s.checkDir(dir)
+				}
+				break
+			}
+			logger.ErrorC("k8ssecret.runLoop", "watcher error", logger.Err(err))
+		}
+	}
+}
+
+// mountDirs returns the watched mount directories
+func (s *Strategy) mountDirs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dirs := make([]string, 0, len(s.dirs))
+	for dir := range s.dirs {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// checkDir checks the keys of the mount directory, and sends the values that changed.
+// The keys are read without the lock, from a snapshot of their state.
+func (s *Strategy) checkDir(dir string) {
+	s.mu.Lock()
+	md, ok := s.dirs[dir]
+	var keys []secretKey
+	if ok {
+		for _, sk := range md.keys {
+			keys = append(keys, *sk)
+		}
+	}
+	s.mu.Unlock()
+	if len(keys) <= 0 {
+		return
+	}
+
+	target, err := resolveData(dir)
+	if err != nil {
+		logger.ErrorC("k8ssecret.checkDir", "failed to resolve "+dataLink,
+			slog.String(logger.PathKey, dir),
+			logger.Err(err),
+		)
+		return
+	}
+	states := make([]keyState, len(keys))
+	changed := make([]bool, len(keys))
+	for i, sk := range keys {
+		states[i], changed[i] = checkKey(dir, target, &sk)
+	}
+
+	var updates []secretKey
+	s.mu.Lock()
+	md, ok = s.dirs[dir]
+	for i, sk := range keys {
+		if !ok {
+			break
+		}
+		// the key may have been closed (or watched again) meanwhile
+		cur, found := md.keys[sk.key]
+		if !found || cur.keyState != sk.keyState {
+			continue
+		}
+		cur.keyState = states[i]
+		if changed[i] {
+			updates = append(updates, *cur)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, u := range updates {
+		s.watches.Set(u.pth, u.value)
+	}
+}
+
+// checkKey reads the key file again if the "..data" symlink was swapped to the target
+// (or if the directory has no "..data", when the key file changed), and returns its
+// new state, and true if its value changed. A key file missing (eg: the key was removed
+// from the Secret) is not an error, it is sent again once it reappears.
+func checkKey(dir, target string, sk *secretKey) (keyState, bool) {
+	if target != "" && target == sk.target {
+		// not swapped
+		return sk.keyState, false
+	}
+
+	val, err := readKey(dir, target, sk.key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			logger.DebugC("k8ssecret.checkKey", "key file missing", slog.String(logger.PathKey, sk.pth))
+			return keyState{target: target}, false
+		}
+		logger.ErrorC("k8ssecret.checkKey", "failed to read key file",
+			slog.String(logger.PathKey, sk.pth),
+			logger.Err(err),
+		)
+		return sk.keyState, false
+	}
+	if target == "" && val == sk.value {
+		return sk.keyState, false
+	}
+	logger.DebugC("k8ssecret.checkKey", "key file changed",
+		slog.String(logger.PathKey, sk.pth),
+		slog.String("target", target),
+	)
+	return keyState{target: target, value: val}, true
+}
+
+// resolveData returns the target of the "..data" symlink of the mount directory,
+// or "" if there is none (the key files are then read directly)
+func resolveData(dir string) (string, error) {
+	link := filepath.Join(dir, dataLink)
+	fInfo, err := os.Lstat(link)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if fInfo.Mode()&fs.ModeSymlink == 0 {
+		return "", nil
+	}
+	target, err := os.Readlink(link)
+	if errors.Is(err, fs.ErrNotExist) {
+		// removed meanwhile
+		return "", nil
+	}
+	return target, err
+}
+
+// readKey reads the trimmed content of the key file in the resolved "..data" target,
+// so that a swap happening meanwhile does not mix two versions of the volume
+func readKey(dir, target, key string) (string, error) {
+	pth := filepath.Join(dir, key)
+	if target != "" {
+		if filepath.IsAbs(target) {
+			pth = filepath.Join(target, key)
+		} else {
+			pth = filepath.Join(dir, target, key)
+		}
+	}
+	bs, err := os.ReadFile(pth)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not read %v", pth)
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
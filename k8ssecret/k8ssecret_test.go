@@ -0,0 +1,137 @@
+package k8ssecret
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	rfsnotify "github.com/fsnotify/fsnotify"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// atomicWriter updates a directory the way the kubelet updates a Secret volume
+type atomicWriter struct {
+	dir string
+	seq atomic.Int32
+}
+
+// write writes the keys to a new timestamped directory, then swaps "..data" to it
+func (aw *atomicWriter) write(keys map[string]string) {
+	tsDir := fmt.Sprintf("..%s_%d", time.Now().Format("2006_01_02_15_04_05"), aw.seq.Add(1))
+	Expect(os.Mkdir(filepath.Join(aw.dir, tsDir), 0755)).To(Succeed())
+	for key, val := range keys {
+		Expect(os.WriteFile(filepath.Join(aw.dir, tsDir, key), []byte(val), 0644)).To(Succeed())
+	}
+
+	oldTsDir, _ := os.Readlink(filepath.Join(aw.dir, dataLink))
+	tmpLink := filepath.Join(aw.dir, "..data_tmp")
+	Expect(os.Symlink(tsDir, tmpLink)).To(Succeed())
+	Expect(os.Rename(tmpLink, filepath.Join(aw.dir, dataLink))).To(Succeed())
+
+	for key := range keys {
+		// the key file links through "..data"
+		os.Symlink(filepath.Join(dataLink, key), filepath.Join(aw.dir, key))
+	}
+	if oldTsDir != "" {
+		Expect(os.RemoveAll(filepath.Join(aw.dir, oldTsDir))).To(Succeed())
+	}
+}
+
+var _ = Describe("Strategy", func() {
+	var s *Strategy
+	var aw *atomicWriter
+
+	BeforeEach(func() {
+		s = NewStrategy()
+		aw = &atomicWriter{dir: GinkgoT().TempDir()}
+		DeferCleanup(s.Close)
+	})
+
+	It("Should send exactly one update per atomic swap", func(ctx context.Context) {
+		aw.write(map[string]string{"dsn": "a\n", "other": "x"})
+		value, values, err := s.Watch(ctx, filepath.Join(aw.dir, "dsn"), "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("a"))
+
+		aw.write(map[string]string{"dsn": "b", "other": "x"})
+		Eventually(values).Should(Receive(Equal("b")))
+		Consistently(values, 200*time.Millisecond).ShouldNot(Receive())
+
+		aw.write(map[string]string{"dsn": "c", "other": "y"})
+		Eventually(values).Should(Receive(Equal("c")))
+		Consistently(values, 200*time.Millisecond).ShouldNot(Receive())
+	}, NodeTimeout(5*time.Second))
+
+	It("Should send the key once it reappears", func(ctx context.Context) {
+		aw.write(map[string]string{"dsn": "a"})
+		_, values, err := s.Watch(ctx, filepath.Join(aw.dir, "dsn"), "")
+		Expect(err).ToNot(HaveOccurred())
+
+		aw.write(map[string]string{"other": "x"})
+		Consistently(values, 200*time.Millisecond).ShouldNot(Receive())
+
+		aw.write(map[string]string{"dsn": "b"})
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should check all the mount directories after an event overflow", func(ctx context.Context) {
+		aw.write(map[string]string{"dsn": "a"})
+		_, values, err := s.Watch(ctx, filepath.Join(aw.dir, "dsn"), "")
+		Expect(err).ToNot(HaveOccurred())
+
+		// the swap events are missed
+		s.mu.Lock()
+		w := s.watcher
+		s.mu.Unlock()
+		Expect(w.Remove(aw.dir)).To(Succeed())
+		aw.write(map[string]string{"dsn": "b"})
+		Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+
+		w.Errors <- rfsnotify.ErrEventOverflow
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should fan out the keys of the same mount directory", func(ctx context.Context) {
+		aw.write(map[string]string{"dsn1": "a", "dsn2": "x"})
+		_, values1, err := s.Watch(ctx, filepath.Join(aw.dir, "dsn1"), "")
+		Expect(err).ToNot(HaveOccurred())
+		_, values2, err := s.Watch(ctx, filepath.Join(aw.dir, "dsn2"), "forceKill=true")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.dirs).To(HaveLen(1))
+
+		aw.write(map[string]string{"dsn1": "b", "dsn2": "y"})
+		Eventually(values1).Should(Receive(Equal("b")))
+		Eventually(values2).Should(Receive(Equal("y")))
+
+		Expect(s.CloseWatch(filepath.Join(aw.dir, "dsn1"), "")).To(Succeed())
+		Eventually(values1).Should(BeClosed())
+		Expect(s.CloseWatch(filepath.Join(aw.dir, "dsn2"), "forceKill=true")).To(Succeed())
+		Eventually(values2).Should(BeClosed())
+		Eventually(func() int {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return len(s.dirs)
+		}).Should(BeZero())
+	}, NodeTimeout(5*time.Second))
+
+	It("Should watch a plain key file without ..data", func(ctx context.Context) {
+		pth := filepath.Join(aw.dir, "dsn")
+		Expect(os.WriteFile(pth, []byte("a"), 0644)).To(Succeed())
+		_, values, err := s.Watch(ctx, pth, "")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.WriteFile(pth+".tmp", []byte("b"), 0644)).To(Succeed())
+		Expect(os.Rename(pth+".tmp", pth)).To(Succeed())
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should fail to watch a missing key", func(ctx context.Context) {
+		aw.write(map[string]string{"other": "x"})
+		_, _, err := s.Watch(ctx, filepath.Join(aw.dir, "dsn"), "")
+		Expect(err).To(HaveOccurred())
+	})
+})
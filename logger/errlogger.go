@@ -3,6 +3,7 @@ package logger
 import (
 	"fmt"
 	"log"
+	"strings"
 )
 
 // errLogger is the global error logger
@@ -30,9 +31,15 @@ func GetErrLogger() Logger {
 	return errLogger
 }
 
-// ErrLogf formats and prints to global error logger
+// ErrLogf formats and prints to global error logger.
+// If a global structured logger is set (see WithSlogLogger),
+// logs to it at error level instead, with the prefix as component.
 func ErrLogf(prefix, format string, args ...any) {
-	loggr := GetErrLogger()
 	logMsg := fmt.Sprintf(format, args...)
+	if sl := slogLogger.Load(); sl != nil {
+		sl.Error(logMsg, Component(strings.TrimSuffix(prefix, ":")))
+		return
+	}
+	loggr := GetErrLogger()
 	loggr(prefix, logMsg)
 }
@@ -1,6 +1,11 @@
 package logger
 
-import "fmt"
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
 
 // Logger defines the interface for logging.
 type Logger func(...interface{})
@@ -11,12 +16,26 @@ var DefaultLogger Logger = func(args ...interface{}) {}
 // stdLogger is the global standard logger
 var stdLogger Logger = DefaultLogger
 
+// stdLoggerNoop is true when the global standard logger is DefaultLogger,
+// so callers can skip formatting messages that would be discarded anyway
+var stdLoggerNoop atomic.Bool
+
+func init() {
+	stdLoggerNoop.Store(true)
+}
+
 // WithLogger sets the global standard logger
 func WithLogger(l Logger) {
 	stdLogger = l
 	if stdLogger == nil {
 		stdLogger = DefaultLogger
 	}
+	stdLoggerNoop.Store(isNoopLogger(stdLogger))
+}
+
+// isNoopLogger returns true if l is DefaultLogger
+func isNoopLogger(l Logger) bool {
+	return reflect.ValueOf(l).Pointer() == reflect.ValueOf(DefaultLogger).Pointer()
 }
 
 // GetLogger gets the global standard logger
@@ -27,9 +46,18 @@ func GetLogger() Logger {
 	return stdLogger
 }
 
-// Logf formats and prints to global standard logger
+// Logf formats and prints to global standard logger.
+// If a global structured logger is set (see WithSlogLogger),
+// logs to it at debug level instead, with the prefix as component.
 func Logf(prefix, format string, args ...any) {
-	loggr := GetLogger()
+	if !DebugEnabled() {
+		return
+	}
 	logMsg := fmt.Sprintf(format, args...)
+	if sl := slogLogger.Load(); sl != nil {
+		sl.Debug(logMsg, Component(strings.TrimSuffix(prefix, ":")))
+		return
+	}
+	loggr := GetLogger()
 	loggr(prefix, logMsg)
 }
@@ -0,0 +1,245 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// Attribute keys used by hotload for structured logging
+const (
+	ComponentKey  = "component"
+	NameKey       = "name"
+	DsnKey        = "dsn"
+	GenerationKey = "generation"
+	PathKey       = "path"
+	QueryKey      = "query"
+	ErrorKey      = "err"
+)
+
+// slogLogger is the global structured logger
+// (nil means use funcSlogLogger, which adapts the global function loggers)
+var slogLogger atomic.Pointer[slog.Logger]
+
+// funcSlogLogger is the structured logger that logs
+// to the global standard and error function loggers
+var funcSlogLogger = slog.New(NewFuncHandler(nil, nil))
+
+// WithSlogLogger sets the global structured logger.
+// Once set, hotload logs to it instead of the function loggers
+// set by WithLogger and WithErrLogger.
+// Setting nil reverts to using the function loggers.
+func WithSlogLogger(l *slog.Logger) {
+	slogLogger.Store(l)
+}
+
+// GetSlogLogger gets the global structured logger.
+// If not set, returns a structured logger that logs to the
+// global function loggers (see NewFuncHandler).
+func GetSlogLogger() *slog.Logger {
+	l := slogLogger.Load()
+	if l == nil {
+		return funcSlogLogger
+	}
+	return l
+}
+
+// Enabled reports whether the global structured logger logs messages at level.
+// Hot paths should check it before building messages or attributes,
+// so that nothing is formatted or allocated for discarded messages.
+func Enabled(level slog.Level) bool {
+	return GetSlogLogger().Enabled(context.Background(), level)
+}
+
+// DebugEnabled reports whether the global structured logger logs debug messages
+func DebugEnabled() bool {
+	return Enabled(slog.LevelDebug)
+}
+
+// Log logs a structured message at the given level to the global structured logger
+func Log(level slog.Level, msg string, attrs ...slog.Attr) {
+	l := GetSlogLogger()
+	ctx := context.Background()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	l.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// Debug logs a structured message at debug level to the global structured logger
+func Debug(msg string, attrs ...slog.Attr) {
+	Log(slog.LevelDebug, msg, attrs...)
+}
+
+// Info logs a structured message at info level to the global structured logger
+func Info(msg string, attrs ...slog.Attr) {
+	Log(slog.LevelInfo, msg, attrs...)
+}
+
+// Warn logs a structured message at warn level to the global structured logger
+func Warn(msg string, attrs ...slog.Attr) {
+	Log(slog.LevelWarn, msg, attrs...)
+}
+
+// Error logs a structured message at error level to the global structured logger
+func Error(msg string, attrs ...slog.Attr) {
+	Log(slog.LevelError, msg, attrs...)
+}
+
+// DebugC logs a structured message at debug level with the component attribute,
+// it returns immediately (without allocating) if debug logging is disabled
+func DebugC(component, msg string, attrs ...slog.Attr) {
+	if !DebugEnabled() {
+		return
+	}
+	Debug(msg, append([]slog.Attr{Component(component)}, attrs...)...)
+}
+
+// ErrorC logs a structured message at error level with the component attribute
+func ErrorC(component, msg string, attrs ...slog.Attr) {
+	Error(msg, append([]slog.Attr{Component(component)}, attrs...)...)
+}
+
+// Component returns the component attribute
+func Component(component string) slog.Attr {
+	return slog.String(ComponentKey, component)
+}
+
+// Err returns the error attribute
+func Err(err error) slog.Attr {
+	return slog.Any(ErrorKey, err)
+}
+
+// funcHandler is a slog.Handler that logs to function loggers
+type funcHandler struct {
+	std     Logger
+	stdNoop bool
+	err     Logger
+	attrs   []slog.Attr
+	groups  []string
+}
+
+// NewFuncHandler returns a slog.Handler that adapts function loggers:
+// records below error level are logged to std, the others to err.
+// A nil std (or err) logger means the global standard (or error)
+// function logger, resolved when the record is logged.
+//
+// The component attribute (if any) is logged as a "component:" prefix,
+// followed by the message and the remaining attributes as key=value pairs.
+//
+// Records below error level are disabled while the std logger
+// is DefaultLogger (the no-op logger).
+func NewFuncHandler(std, err Logger) slog.Handler {
+	return &funcHandler{
+		std:     std,
+		stdNoop: std != nil && isNoopLogger(std),
+		err:     err,
+	}
+}
+
+// Enabled implements slog.Handler interface
+func (h *funcHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if level >= slog.LevelError {
+		return true
+	}
+	if h.std == nil {
+		return !stdLoggerNoop.Load()
+	}
+	return !h.stdNoop
+}
+
+// Handle implements slog.Handler interface
+func (h *funcHandler) Handle(ctx context.Context, r slog.Record) error {
+	loggr := h.std
+	if r.Level >= slog.LevelError {
+		loggr = h.err
+		if loggr == nil {
+			loggr = GetErrLogger()
+		}
+	} else if loggr == nil {
+		loggr = GetLogger()
+	}
+
+	prefix := ""
+	var msgBuf strings.Builder
+	msgBuf.WriteString(r.Message)
+	for _, a := range h.attrs {
+		if a.Key == ComponentKey {
+			prefix = a.Value.String() + ":"
+			continue
+		}
+		appendAttr(&msgBuf, "", a)
+	}
+	group := strings.Join(h.groups, ".")
+	r.Attrs(func(a slog.Attr) bool {
+		if len(group) <= 0 && a.Key == ComponentKey {
+			prefix = a.Value.String() + ":"
+			return true
+		}
+		appendAttr(&msgBuf, group, a)
+		return true
+	})
+
+	if len(prefix) > 0 {
+		loggr(prefix, msgBuf.String())
+	} else {
+		loggr(msgBuf.String())
+	}
+	return nil
+}
+
+// appendAttr appends the attribute as " key=value"
+// (group attributes are flattened as " group.key=value")
+func appendAttr(buf *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	key := a.Key
+	if len(group) > 0 {
+		key = group + "." + key
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			appendAttr(buf, key, ga)
+		}
+		return
+	}
+
+	fmt.Fprintf(buf, " %s=", key)
+	val := a.Value.String()
+	if strings.ContainsAny(val, " \t\n\"=") {
+		fmt.Fprintf(buf, "%q", val)
+	} else {
+		buf.WriteString(val)
+	}
+}
+
+// WithAttrs implements slog.Handler interface
+func (h *funcHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	h2.attrs = append(h2.attrs, h.attrs...)
+	group := strings.Join(h.groups, ".")
+	for _, a := range attrs {
+		if len(group) > 0 {
+			a = slog.Group(group, a)
+		}
+		h2.attrs = append(h2.attrs, a)
+	}
+	return &h2
+}
+
+// WithGroup implements slog.Handler interface
+func (h *funcHandler) WithGroup(name string) slog.Handler {
+	if len(name) <= 0 {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(append([]string{}, h.groups...), name)
+	return &h2
+}
@@ -0,0 +1,96 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestFuncHandler(t *testing.T) {
+	stdOutput := ""
+	errOutput := ""
+	std := func(args ...any) {
+		stdOutput = fmt.Sprintln(args...)
+	}
+	errl := func(args ...any) {
+		errOutput = fmt.Sprintln(args...)
+	}
+
+	sl := slog.New(NewFuncHandler(std, errl))
+	sl.Debug("opened conn", Component("chanGroup.Open"), slog.String(DsnKey, "postgres://u---r@host"), slog.Uint64(GenerationKey, 3))
+	expOutput := "chanGroup.Open: opened conn dsn=postgres://u---r@host generation=3\n"
+	if stdOutput != expOutput {
+		t.Errorf("stdOutput should be %q, but stdOutput=%q", expOutput, stdOutput)
+	}
+	if errOutput != "" {
+		t.Errorf("errOutput should be empty, but errOutput=%q", errOutput)
+	}
+
+	stdOutput = ""
+	sl.With(Component("fsnotify.runLoop")).WithGroup("watch").Error("resync failed", slog.String(PathKey, "/tmp/my dsn.txt"), Err(errors.New("boom")))
+	expOutput = "fsnotify.runLoop: resync failed watch.path=\"/tmp/my dsn.txt\" watch.err=boom\n"
+	if errOutput != expOutput {
+		t.Errorf("errOutput should be %q, but errOutput=%q", expOutput, errOutput)
+	}
+	if stdOutput != "" {
+		t.Errorf("stdOutput should be empty, but stdOutput=%q", stdOutput)
+	}
+}
+
+func TestWithSlogLogger(t *testing.T) {
+	logCount := 0
+	WithLogger(func(args ...any) {
+		logCount = logCount + 1
+	})
+	defer WithLogger(nil)
+
+	var buf bytes.Buffer
+	WithSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer WithSlogLogger(nil)
+
+	Debug("structured", Component("mycomponent"), slog.String(NameKey, "fsnotify://postgres/tmp/dsn.txt"))
+	Logf("mylogmsgprefix:", "cube(%d)=%d", 3, (3 * 3 * 3))
+	ErrLogf("myerrmsgprefix:", "failed")
+
+	if logCount != 0 {
+		t.Errorf("function logger should not be called once slog logger is set, logCount=%d", logCount)
+	}
+	gotOutput := buf.String()
+	for _, expOutput := range []string{
+		`level=DEBUG msg=structured component=mycomponent name=fsnotify://postgres/tmp/dsn.txt`,
+		`level=DEBUG msg="cube(3)=27" component=mylogmsgprefix`,
+		`level=ERROR msg=failed component=myerrmsgprefix`,
+	} {
+		if !strings.Contains(gotOutput, expOutput) {
+			t.Errorf("output should contain %q, but output=%q", expOutput, gotOutput)
+		}
+	}
+
+	// Reverting to function loggers
+	WithSlogLogger(nil)
+	Debug("structured", Component("mycomponent"))
+	if logCount != 1 {
+		t.Errorf("logCount should be 1, logCount=%d", logCount)
+	}
+}
+
+func TestComponentLogging(t *testing.T) {
+	var buf bytes.Buffer
+	WithSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	defer WithSlogLogger(nil)
+
+	DebugC("poll.poll", "file changed", slog.String(PathKey, "/tmp/dsn.txt"))
+	ErrorC("poll.poll", "poll failed", slog.String(PathKey, "/tmp/dsn.txt"), Err(errors.New("boom")))
+
+	gotOutput := buf.String()
+	if strings.Contains(gotOutput, "file changed") {
+		t.Errorf("debug message should be discarded, but output=%q", gotOutput)
+	}
+	expOutput := `level=ERROR msg="poll failed" component=poll.poll path=/tmp/dsn.txt err=boom`
+	if !strings.Contains(gotOutput, expOutput) {
+		t.Errorf("output should contain %q, but output=%q", expOutput, gotOutput)
+	}
+}
@@ -0,0 +1,22 @@
+package hotload
+
+import (
+	"context"
+)
+
+// mergeContext returns a context that carries the values and deadline of ctx,
+// and that is canceled when either ctx or other is done.
+//
+// No goroutine is started: cancellation of other is propagated using
+// context.AfterFunc. The returned release func MUST be called once the merged
+// context is no longer used; it unregisters from other and cancels the merged context.
+func mergeContext(ctx, other context.Context) (context.Context, context.CancelFunc) {
+	merged, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(other, func() {
+		cancel(context.Cause(other))
+	})
+	return merged, func() {
+		stop()
+		cancel(context.Canceled)
+	}
+}
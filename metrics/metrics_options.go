@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type metricsOptions struct {
+	namespace   string
+	constLabels prometheus.Labels
+}
+
+type Option func(*metricsOptions)
+
+func newDefaultOptions() *metricsOptions {
+	return &metricsOptions{}
+}
+
+// WithNamespace is the option to prefix all hotload metric names
+// with the given namespace (eg: "myapp" results in "myapp_hotload_change_total")
+func WithNamespace(namespace string) Option {
+	return func(opts *metricsOptions) {
+		opts.namespace = namespace
+	}
+}
+
+// WithConstLabels is the option to add constant labels to all hotload metrics
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(opts *metricsOptions) {
+		if len(labels) <= 0 {
+			opts.constLabels = nil
+			return
+		}
+		opts.constLabels = make(prometheus.Labels, len(labels))
+		for k, v := range labels {
+			opts.constLabels[k] = v
+		}
+	}
+}
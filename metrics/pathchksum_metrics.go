@@ -3,6 +3,7 @@ package metrics
 import (
 	"errors"
 	"hash/crc64"
+	"log/slog"
 	"os"
 	"path"
 	"strings"
@@ -24,10 +25,7 @@ var (
 
 	HotloadPathChksumTimestampSecondsName         = "hotload_path_chksum_timestamp_seconds"
 	HotloadPathChksumTimestampSecondsHelp         = "Hotload path checksum last changed (unix timestamp), by path"
-	HotloadPathChksumTimestampSecondsGaugeFuncVec = gaugefuncvec.New(prometheus.GaugeOpts{
-		Name: HotloadPathChksumTimestampSecondsName,
-		Help: HotloadPathChksumTimestampSecondsHelp,
-	}, []string{PathKey})
+	HotloadPathChksumTimestampSecondsGaugeFuncVec = newHotloadPathChksumTimestampSecondsGaugeFuncVec(newDefaultOptions())
 
 	crc64Table = crc64.MakeTable(crc64.ECMA)
 
@@ -36,7 +34,15 @@ var (
 
 func init() {
 	defaultPathChksum = newPathChksum(DefaultFileHasher)
-	prometheus.MustRegister(HotloadPathChksumTimestampSecondsGaugeFuncVec)
+}
+
+func newHotloadPathChksumTimestampSecondsGaugeFuncVec(opts *metricsOptions) *gaugefuncvec.GaugeFuncVec {
+	return gaugefuncvec.New(prometheus.GaugeOpts{
+		Namespace:   opts.namespace,
+		Name:        HotloadPathChksumTimestampSecondsName,
+		Help:        HotloadPathChksumTimestampSecondsHelp,
+		ConstLabels: opts.constLabels,
+	}, []string{PathKey})
 }
 
 // AddToDefaultPathChksum adds a path to the global defaultPathChksum for checksum metrics
@@ -55,6 +61,7 @@ type pathRecord struct {
 	path        string
 	crc64       uint64
 	lastChanged int64
+	scraperFn   func() float64
 }
 
 // Define FileHasher type so we can mock it for unit-testing
@@ -64,7 +71,11 @@ type FileHasher func(filePath string) (uint64, error)
 func DefaultFileHasher(filePath string) (uint64, error) {
 	pathBytes, err := os.ReadFile(filePath)
 	if err != nil {
-		logger.ErrLogf("DefaultFileHasher", "ReadFile(%s) err=%s", filePath, err)
+		logger.Error("ReadFile failed",
+			logger.Component("DefaultFileHasher"),
+			slog.String(logger.PathKey, filePath),
+			logger.Err(err),
+		)
 		return 0, err
 	}
 
@@ -78,15 +89,8 @@ func newPathChksum(fileHasher FileHasher) *pathChksum {
 		panic("nil FileHasher")
 	}
 
-	enabledStr := strings.ToLower(strings.TrimSpace(os.Getenv(PathChksumMetricsEnableEnvVar)))
-	enabledFlg := false
-	switch enabledStr {
-	case "1", "true", "yes":
-		enabledFlg = true
-	}
-
 	pthm := &pathChksum{
-		enabled:    enabledFlg,
+		enabled:    envVarEnabled(PathChksumMetricsEnableEnvVar),
 		fileHasher: fileHasher,
 		paths:      make(map[string]*pathRecord),
 	}
@@ -115,7 +119,7 @@ func (pthm *pathChksum) addPath(pathStr string) error {
 	}
 	pthm.paths[pathStr] = pathRec
 
-	scraperFn := func() float64 {
+	pathRec.scraperFn = func() float64 {
 		if !pthm.enabled {
 			return float64(0)
 		}
@@ -123,7 +127,11 @@ func (pthm *pathChksum) addPath(pathStr string) error {
 		newCrc64, err := pthm.fileHasher(pathRec.path)
 		if err != nil {
 			// log error, but continue
-			logger.ErrLogf("PathChksum.scraper", "fileHasher(%s) err=%s", pathRec.path, err)
+			logger.Error("fileHasher failed",
+				logger.Component("PathChksum.scraper"),
+				slog.String(logger.PathKey, pathRec.path),
+				logger.Err(err),
+			)
 		} else if pathRec.crc64 != newCrc64 {
 			pathRec.crc64 = newCrc64
 			pathRec.lastChanged = time.Now().Unix()
@@ -134,12 +142,32 @@ func (pthm *pathChksum) addPath(pathStr string) error {
 
 	HotloadPathChksumTimestampSecondsGaugeFuncVec.MustRegister(
 		prometheus.Labels{PathKey: pathStr},
-		scraperFn,
+		pathRec.scraperFn,
 	)
 
 	return nil
 }
 
+// registerPaths registers all the paths already added
+// with a (newly created) gauge func vec
+func (pthm *pathChksum) registerPaths(gfv *gaugefuncvec.GaugeFuncVec) {
+	pthm.RLock()
+	defer pthm.RUnlock()
+
+	for pathStr, pathRec := range pthm.paths {
+		gfv.MustRegister(prometheus.Labels{PathKey: pathStr}, pathRec.scraperFn)
+	}
+}
+
+// envVarEnabled returns true if the env var is set to a truthy value
+func envVarEnabled(envVar string) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(envVar))) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
 // CleanPath cleans and trimspaces path strings
 func CleanPath(pathStr string) string {
 	return path.Clean(strings.TrimSpace(pathStr))
@@ -14,26 +14,53 @@ const (
 	StrategyKey = "strategy"
 	PathKey     = "path"
 	UrlKey      = "url"
+	OutcomeKey  = "outcome"
+
+	GenerationKey = "generation"
+	StateKey      = "state"
+	ReasonKey     = "reason"
+
+	// Switchover outcomes
+	SwitchoverApplied   = "applied"   // new value applied, conns switched over
+	SwitchoverUnchanged = "unchanged" // new value same as current value, ignored
+	SwitchoverRejected  = "rejected"  // new value invalid, ignored
+
+	// Path drift reasons
+	DriftOverflow = "overflow" // found by the full resync after an event overflow
+	DriftVerify   = "verify"   // found by the periodic integrity check
 )
 
 // SqlStmtsSummary is a prometheus metric to keep track of the number of times
 // a sql statement is called in a transaction by statement type per grpc service
 var SqlStmtsSummaryName = "transaction_sql_stmts"
-var SqlStmtsSummary = prometheus.NewSummaryVec(prometheus.SummaryOpts{
-	Name: SqlStmtsSummaryName,
-	Help: "The number of sql stmts called in a transaction by statement type per grpc service and method",
-}, []string{GRPCServiceKey, GRPCMethodKey, StatementKey})
+var SqlStmtsSummaryHelp = "The number of sql stmts called in a transaction by statement type per grpc service and method"
+var SqlStmtsSummary = newSqlStmtsSummary(newDefaultOptions())
+
+func newSqlStmtsSummary(opts *metricsOptions) *prometheus.SummaryVec {
+	return prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace:   opts.namespace,
+		Name:        SqlStmtsSummaryName,
+		Help:        SqlStmtsSummaryHelp,
+		ConstLabels: opts.constLabels,
+	}, []string{GRPCServiceKey, GRPCMethodKey, StatementKey})
+}
 
 // HotloadModtimeLatencyHistogram is modtime latency histogram (in seconds)
 // ie: each sample datapoint is time.Now().Sub(Modtime)
 var HotloadModtimeLatencyHistogramName = "hotload_modtime_latency_histogram"
 var HotloadModtimeLatencyHistogramHelp = "Hotload modtime latency histogram (seconds) by strategy and path"
 var HotloadModtimeLatencyHistogramDefBuckets = []float64{900, 1800, 2700, 3600, 4500, 5400, 7200, 10800, 14400, 28800, 86400}
-var HotloadModtimeLatencyHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
-	Name:    HotloadModtimeLatencyHistogramName,
-	Help:    HotloadModtimeLatencyHistogramHelp,
-	Buckets: HotloadModtimeLatencyHistogramDefBuckets,
-}, []string{StrategyKey, PathKey})
+var HotloadModtimeLatencyHistogram = newHotloadModtimeLatencyHistogram(newDefaultOptions())
+
+func newHotloadModtimeLatencyHistogram(opts *metricsOptions) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   opts.namespace,
+		Name:        HotloadModtimeLatencyHistogramName,
+		Help:        HotloadModtimeLatencyHistogramHelp,
+		ConstLabels: opts.constLabels,
+		Buckets:     HotloadModtimeLatencyHistogramDefBuckets,
+	}, []string{StrategyKey, PathKey})
+}
 
 func ObserveHotloadModtimeLatencyHistogram(strategy, path string, val float64) {
 	HotloadModtimeLatencyHistogram.WithLabelValues(strategy, path).Observe(val)
@@ -42,10 +69,16 @@ func ObserveHotloadModtimeLatencyHistogram(strategy, path string, val float64) {
 // HotloadChangeTotal is count of changes detected by hotload
 var HotloadChangeTotalName = "hotload_change_total"
 var HotloadChangeTotalHelp = "Hotload change total by url"
-var HotloadChangeTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
-	Name: HotloadChangeTotalName,
-	Help: HotloadChangeTotalHelp,
-}, []string{UrlKey})
+var HotloadChangeTotal = newHotloadChangeTotal(newDefaultOptions())
+
+func newHotloadChangeTotal(opts *metricsOptions) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opts.namespace,
+		Name:        HotloadChangeTotalName,
+		Help:        HotloadChangeTotalHelp,
+		ConstLabels: opts.constLabels,
+	}, []string{UrlKey})
+}
 
 func IncHotloadChangeTotal(url string) {
 	HotloadChangeTotal.WithLabelValues(url).Inc()
@@ -54,21 +87,138 @@ func IncHotloadChangeTotal(url string) {
 // HotloadLastChangedTimestampSeconds is timestamp when hotload last detected change (unix timestamp)
 var HotloadLastChangedTimestampSecondsName = "hotload_last_changed_timestamp_seconds"
 var HotloadLastChangedTimestampSecondsHelp = "Hotload last changed (unix timestamp), by url"
-var HotloadLastChangedTimestampSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-	Name: HotloadLastChangedTimestampSecondsName,
-	Help: HotloadLastChangedTimestampSecondsHelp,
-}, []string{UrlKey})
+var HotloadLastChangedTimestampSeconds = newHotloadLastChangedTimestampSeconds(newDefaultOptions())
+
+func newHotloadLastChangedTimestampSeconds(opts *metricsOptions) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   opts.namespace,
+		Name:        HotloadLastChangedTimestampSecondsName,
+		Help:        HotloadLastChangedTimestampSecondsHelp,
+		ConstLabels: opts.constLabels,
+	}, []string{UrlKey})
+}
 
 func SetHotloadLastChangedTimestampSeconds(url string, val float64) {
 	HotloadLastChangedTimestampSeconds.WithLabelValues(url).Set(val)
 }
 
+// HotloadSwitchoverLatencyHistogram is switchover latency histogram (in seconds)
+// ie: each sample datapoint is the time from the strategy emitting a new value
+// to the switchover being completed
+var HotloadSwitchoverLatencyHistogramName = "hotload_switchover_latency_seconds"
+var HotloadSwitchoverLatencyHistogramHelp = "Hotload switchover latency histogram (seconds) from strategy emission to switchover completion, by url"
+var HotloadSwitchoverLatencyHistogramDefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+var HotloadSwitchoverLatencyHistogram = newHotloadSwitchoverLatencyHistogram(newDefaultOptions())
+
+func newHotloadSwitchoverLatencyHistogram(opts *metricsOptions) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   opts.namespace,
+		Name:        HotloadSwitchoverLatencyHistogramName,
+		Help:        HotloadSwitchoverLatencyHistogramHelp,
+		ConstLabels: opts.constLabels,
+		Buckets:     HotloadSwitchoverLatencyHistogramDefBuckets,
+	}, []string{UrlKey})
+}
+
+func ObserveHotloadSwitchoverLatencyHistogram(url string, val float64) {
+	HotloadSwitchoverLatencyHistogram.WithLabelValues(url).Observe(val)
+}
+
+// HotloadSwitchoverTotal is count of switchovers by outcome (applied, unchanged, rejected)
+var HotloadSwitchoverTotalName = "hotload_switchover_total"
+var HotloadSwitchoverTotalHelp = "Hotload switchover total by url and outcome"
+var HotloadSwitchoverTotal = newHotloadSwitchoverTotal(newDefaultOptions())
+
+func newHotloadSwitchoverTotal(opts *metricsOptions) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opts.namespace,
+		Name:        HotloadSwitchoverTotalName,
+		Help:        HotloadSwitchoverTotalHelp,
+		ConstLabels: opts.constLabels,
+	}, []string{UrlKey, OutcomeKey})
+}
+
+func IncHotloadSwitchoverTotal(url, outcome string) {
+	HotloadSwitchoverTotal.WithLabelValues(url, outcome).Inc()
+}
+
+// HotloadGenerationConns is the number of managed conns of each live generation
+// (a generation is the set of conns opened with the same connection string)
+var HotloadGenerationConnsName = "hotload_generation_conns"
+var HotloadGenerationConnsHelp = "Hotload managed conns of each live generation, by url, generation and state"
+var HotloadGenerationConns = newHotloadGenerationConns(newDefaultOptions())
+
+func newHotloadGenerationConns(opts *metricsOptions) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   opts.namespace,
+		Name:        HotloadGenerationConnsName,
+		Help:        HotloadGenerationConnsHelp,
+		ConstLabels: opts.constLabels,
+	}, []string{UrlKey, GenerationKey, StateKey})
+}
+
+func SetHotloadGenerationConns(url, generation, state string, val float64) {
+	HotloadGenerationConns.WithLabelValues(url, generation, state).Set(val)
+}
+
+// DeleteHotloadGenerationConns deletes the generations of url,
+// so that generations which are no longer live are not reported
+func DeleteHotloadGenerationConns(url string) {
+	HotloadGenerationConns.DeletePartialMatch(prometheus.Labels{UrlKey: url})
+}
+
+// HotloadQuiesceHoldHistogram is quiesce hold histogram (in seconds)
+// ie: each sample datapoint is the time a caller was held while the
+// previous generation was quiescing (see switchoverMode=quiesce)
+var HotloadQuiesceHoldHistogramName = "hotload_quiesce_hold_seconds"
+var HotloadQuiesceHoldHistogramHelp = "Hotload quiesce hold histogram (seconds) of callers held during a quiesced switchover, by url"
+var HotloadQuiesceHoldHistogramDefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+var HotloadQuiesceHoldHistogram = newHotloadQuiesceHoldHistogram(newDefaultOptions())
+
+func newHotloadQuiesceHoldHistogram(opts *metricsOptions) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   opts.namespace,
+		Name:        HotloadQuiesceHoldHistogramName,
+		Help:        HotloadQuiesceHoldHistogramHelp,
+		ConstLabels: opts.constLabels,
+		Buckets:     HotloadQuiesceHoldHistogramDefBuckets,
+	}, []string{UrlKey})
+}
+
+func ObserveHotloadQuiesceHoldHistogram(url string, val float64) {
+	HotloadQuiesceHoldHistogram.WithLabelValues(url).Observe(val)
+}
+
+// HotloadPathDriftTotal is count of changes of watched paths found by a resync
+// instead of by an event (ie: the event was missed), by reason
+var HotloadPathDriftTotalName = "hotload_path_drift_total"
+var HotloadPathDriftTotalHelp = "Hotload changes of watched paths found by a resync (missed events), by strategy, path and reason"
+var HotloadPathDriftTotal = newHotloadPathDriftTotal(newDefaultOptions())
+
+func newHotloadPathDriftTotal(opts *metricsOptions) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   opts.namespace,
+		Name:        HotloadPathDriftTotalName,
+		Help:        HotloadPathDriftTotalHelp,
+		ConstLabels: opts.constLabels,
+	}, []string{StrategyKey, PathKey, ReasonKey})
+}
+
+func IncHotloadPathDriftTotal(strategy, path, reason string) {
+	HotloadPathDriftTotal.WithLabelValues(strategy, path, reason).Inc()
+}
+
 func GetCollectors() []prometheus.Collector {
 	return []prometheus.Collector{
 		SqlStmtsSummary,
 		HotloadModtimeLatencyHistogram,
 		HotloadChangeTotal,
 		HotloadLastChangedTimestampSeconds,
+		HotloadSwitchoverLatencyHistogram,
+		HotloadSwitchoverTotal,
+		HotloadGenerationConns,
+		HotloadQuiesceHoldHistogram,
+		HotloadPathDriftTotal,
 	}
 }
 
@@ -78,8 +228,9 @@ func ResetCollectors() {
 	HotloadModtimeLatencyHistogram.Reset()
 	HotloadChangeTotal.Reset()
 	HotloadLastChangedTimestampSeconds.Reset()
-}
-
-func init() {
-	prometheus.MustRegister(GetCollectors()...)
+	HotloadSwitchoverLatencyHistogram.Reset()
+	HotloadSwitchoverTotal.Reset()
+	HotloadGenerationConns.Reset()
+	HotloadQuiesceHoldHistogram.Reset()
+	HotloadPathDriftTotal.Reset()
 }
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/infobloxopen/hotload/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// AutoRegisterDisableEnvVar disables the init-time registration
+	// of the hotload metrics with the default prometheus registry
+	AutoRegisterDisableEnvVar = "HOTLOAD_METRICS_AUTO_REGISTER_DISABLE"
+)
+
+var (
+	setupMu sync.Mutex
+
+	// registerer currently holding the hotload collectors (nil if none)
+	registerer prometheus.Registerer
+)
+
+func init() {
+	if envVarEnabled(AutoRegisterDisableEnvVar) {
+		return
+	}
+
+	// Do not panic if the metrics are already registered by someone else,
+	// the application can still call Setup() to use its own registry.
+	err := registerCollectors(prometheus.DefaultRegisterer, allCollectors())
+	if err != nil {
+		logger.Error("auto-register with default registry failed",
+			logger.Component("metrics.init"),
+			logger.Err(err),
+		)
+		return
+	}
+	registerer = prometheus.DefaultRegisterer
+}
+
+// Setup recreates the hotload metrics using the given options
+// and registers them with reg (prometheus.DefaultRegisterer if nil).
+// The metrics previously registered, either by a prior call to Setup
+// or by the init-time auto-registration, are unregistered first.
+// On error, the previous metrics remain in place.
+//
+// Setup should be called at program start-up,
+// before any hotload connections are opened.
+// To prevent the init-time auto-registration with the default registry,
+// set the HOTLOAD_METRICS_AUTO_REGISTER_DISABLE env var to "true".
+func Setup(reg prometheus.Registerer, opts ...Option) error {
+	defOpts := newDefaultOptions()
+	for _, opt := range opts {
+		opt(defOpts)
+	}
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+
+	setupMu.Lock()
+	defer setupMu.Unlock()
+
+	newSummary := newSqlStmtsSummary(defOpts)
+	newHistogram := newHotloadModtimeLatencyHistogram(defOpts)
+	newChangeTotal := newHotloadChangeTotal(defOpts)
+	newLastChanged := newHotloadLastChangedTimestampSeconds(defOpts)
+	newSwitchoverLatency := newHotloadSwitchoverLatencyHistogram(defOpts)
+	newSwitchoverTotal := newHotloadSwitchoverTotal(defOpts)
+	newGenerationConns := newHotloadGenerationConns(defOpts)
+	newQuiesceHold := newHotloadQuiesceHoldHistogram(defOpts)
+	newPathDrift := newHotloadPathDriftTotal(defOpts)
+	newPathChksum := newHotloadPathChksumTimestampSecondsGaugeFuncVec(defOpts)
+	defaultPathChksum.registerPaths(newPathChksum)
+
+	prevCollectors := allCollectors()
+	if registerer != nil {
+		unregisterCollectors(registerer, prevCollectors)
+	}
+
+	newCollectors := []prometheus.Collector{
+		newSummary,
+		newHistogram,
+		newChangeTotal,
+		newLastChanged,
+		newSwitchoverLatency,
+		newSwitchoverTotal,
+		newGenerationConns,
+		newQuiesceHold,
+		newPathDrift,
+		newPathChksum,
+	}
+	if err := registerCollectors(reg, newCollectors); err != nil {
+		if registerer != nil {
+			// Best-effort restore of previous metrics
+			if rerr := registerCollectors(registerer, prevCollectors); rerr != nil {
+				logger.Error("restore previous registration failed",
+					logger.Component("metrics.Setup"),
+					logger.Err(rerr),
+				)
+				registerer = nil
+			}
+		}
+		return err
+	}
+
+	SqlStmtsSummary = newSummary
+	HotloadModtimeLatencyHistogram = newHistogram
+	HotloadChangeTotal = newChangeTotal
+	HotloadLastChangedTimestampSeconds = newLastChanged
+	HotloadSwitchoverLatencyHistogram = newSwitchoverLatency
+	HotloadSwitchoverTotal = newSwitchoverTotal
+	HotloadGenerationConns = newGenerationConns
+	HotloadQuiesceHoldHistogram = newQuiesceHold
+	HotloadPathDriftTotal = newPathDrift
+	HotloadPathChksumTimestampSecondsGaugeFuncVec = newPathChksum
+	registerer = reg
+
+	return nil
+}
+
+// allCollectors returns all hotload collectors,
+// including the path checksum collector
+func allCollectors() []prometheus.Collector {
+	return append(GetCollectors(), HotloadPathChksumTimestampSecondsGaugeFuncVec)
+}
+
+// registerCollectors registers all collectors with reg.
+// If any registration fails, the collectors already registered
+// are unregistered and the error is returned.
+func registerCollectors(reg prometheus.Registerer, collectors []prometheus.Collector) error {
+	for i, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			unregisterCollectors(reg, collectors[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+func unregisterCollectors(reg prometheus.Registerer, collectors []prometheus.Collector) {
+	for _, c := range collectors {
+		reg.Unregister(c)
+	}
+}
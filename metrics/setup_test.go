@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSetup(t *testing.T) {
+	t.Cleanup(func() {
+		if err := Setup(prometheus.DefaultRegisterer); err != nil {
+			t.Errorf("Setup(DefaultRegisterer) cleanup err=%v", err)
+		}
+	})
+
+	// Auto-registered with default registry at init-time
+	err := prometheus.Register(HotloadChangeTotal)
+	if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+		t.Errorf("expecting AlreadyRegisteredError, but got err=%v", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	err = Setup(reg, WithNamespace("myapp"), WithConstLabels(prometheus.Labels{"team": "db"}))
+	if err != nil {
+		t.Fatalf("Setup(reg) err=%v", err)
+	}
+
+	// Unregistered from default registry
+	if prometheus.DefaultRegisterer.Unregister(HotloadChangeTotal) {
+		t.Errorf("HotloadChangeTotal should not be registered with default registry")
+	}
+
+	IncHotloadChangeTotal("fsnotify://postgres/tmp/mydsn.txt")
+	err = testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP myapp_hotload_change_total Hotload change total by url
+# TYPE myapp_hotload_change_total counter
+myapp_hotload_change_total{team="db",url="fsnotify://postgres/tmp/mydsn.txt"} 1
+`), "myapp_hotload_change_total")
+	if err != nil {
+		t.Errorf("GatherAndCompare err=%v", err)
+	}
+
+	// Registering with a registry that already has a conflicting metric fails,
+	// and the previous metrics remain in place
+	conflictReg := prometheus.NewRegistry()
+	conflictReg.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{
+		Name: SqlStmtsSummaryName,
+		Help: "conflicting metric",
+	}))
+	prevChangeTotal := HotloadChangeTotal
+	err = Setup(conflictReg)
+	if err == nil {
+		t.Errorf("Setup(conflictReg) should have failed")
+	}
+	if HotloadChangeTotal != prevChangeTotal {
+		t.Errorf("HotloadChangeTotal should not have been replaced")
+	}
+	if !reg.Unregister(HotloadChangeTotal) {
+		t.Errorf("HotloadChangeTotal should still be registered with reg")
+	}
+	reg.MustRegister(HotloadChangeTotal)
+}
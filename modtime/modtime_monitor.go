@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"log/slog"
 	"path"
 	"strings"
 	"sync"
@@ -22,10 +23,9 @@ var (
 
 // ModTimeMonitor monitors the modtimes for a set of paths in a filesystem
 type ModTimeMonitor struct {
-	sync.RWMutex // used to synchronize changes to the set of paths being monitored
-	log          logger.Logger
-	errlog       logger.Logger
-	statFS       fs.StatFS // use io/fs.FS so we can mock FileSystem for unit-tests
+	sync.RWMutex              // used to synchronize changes to the set of paths being monitored
+	slogLogger   *slog.Logger // nil means use global structured logger
+	statFS       fs.StatFS    // use io/fs.FS so we can mock FileSystem for unit-tests
 	checkIntv    time.Duration
 	paths        map[pathKey]*pathRecord
 }
@@ -63,11 +63,10 @@ func NewModTimeMonitor(ctx context.Context, opts ...Option) *ModTimeMonitor {
 	}
 
 	mtm := &ModTimeMonitor{
-		log:       defOpts.log,
-		errlog:    defOpts.errlog,
-		statFS:    defOpts.statFS,
-		checkIntv: defOpts.checkIntv,
-		paths:     make(map[pathKey]*pathRecord),
+		slogLogger: defOpts.structuredLogger(),
+		statFS:     defOpts.statFS,
+		checkIntv:  defOpts.checkIntv,
+		paths:      make(map[pathKey]*pathRecord),
 	}
 	go mtm.runLoop(ctx)
 	return mtm
@@ -148,10 +147,18 @@ func (mtm *ModTimeMonitor) checkPathModTimes(ctx context.Context, nowTime time.T
 		fInfo, err := mtm.statFS.Stat(unrooted)
 		if err != nil {
 			// log error, but continue
-			mtm.errlogf("checkPathModTimes", "Stat(%s) err=%s", pkey, err)
+			mtm.logger().Error("Stat failed",
+				logger.Component("checkPathModTimes"),
+				slog.String("key", pkey.String()),
+				logger.Err(err),
+			)
 		} else {
 			newTime := fInfo.ModTime()
-			mtm.logf("checkPathModTimes", "Stat(%s) ModTime=%s", pkey, newTime)
+			mtm.logger().Debug("Stat",
+				logger.Component("checkPathModTimes"),
+				slog.String("key", pkey.String()),
+				slog.Time("modTime", newTime),
+			)
 			pathRec.modTime.Store(newTime)
 		}
 
@@ -165,7 +172,7 @@ func (mtm *ModTimeMonitor) checkPathModTimes(ctx context.Context, nowTime time.T
 // periodically checks its paths' modtimes in a loop.
 // Terminated when context is done (canceled).
 func (mtm *ModTimeMonitor) runLoop(ctx context.Context) {
-	mtm.log("ModTimeMonitor.runLoop: started")
+	mtm.logger().Debug("started", logger.Component("ModTimeMonitor.runLoop"))
 
 	checkTicker := time.NewTicker(mtm.checkIntv)
 	defer checkTicker.Stop()
@@ -182,17 +189,14 @@ loop:
 		}
 	}
 
-	mtm.log("ModTimeMonitor.runLoop: terminated")
+	mtm.logger().Debug("terminated", logger.Component("ModTimeMonitor.runLoop"))
 }
 
-func (mtm *ModTimeMonitor) logf(prefix, format string, args ...any) {
-	logMsg := fmt.Sprintf(format, args...)
-	mtm.log(prefix, logMsg)
-}
-
-func (mtm *ModTimeMonitor) errlogf(prefix, format string, args ...any) {
-	logMsg := fmt.Sprintf(format, args...)
-	mtm.errlog(prefix, logMsg)
+func (mtm *ModTimeMonitor) logger() *slog.Logger {
+	if mtm.slogLogger != nil {
+		return mtm.slogLogger
+	}
+	return logger.GetSlogLogger()
 }
 
 // CleanPath cleans and trimspaces path strings
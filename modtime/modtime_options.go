@@ -2,6 +2,7 @@ package modtime
 
 import (
 	"io/fs"
+	"log/slog"
 	"os"
 	"time"
 
@@ -18,17 +19,17 @@ var (
 )
 
 type mtmOptions struct {
-	log       logger.Logger
-	errlog    logger.Logger
-	statFS    fs.StatFS
-	checkIntv time.Duration
+	log        logger.Logger
+	errlog     logger.Logger
+	slogLogger *slog.Logger
+	statFS     fs.StatFS
+	checkIntv  time.Duration
 }
 
 type Option func(*mtmOptions)
 
 func newDefaultOptions() *mtmOptions {
 	opts := &mtmOptions{
-		log:       logger.GetLogger(),
 		statFS:    DefaultStatFS,
 		checkIntv: DefaultCheckInterval,
 	}
@@ -36,25 +37,40 @@ func newDefaultOptions() *mtmOptions {
 }
 
 // WithLogger is the option to set the Logger
+// (nil means the global standard logger)
 func WithLogger(log logger.Logger) Option {
 	return func(opts *mtmOptions) {
-		if log == nil {
-			opts.log = logger.GetLogger()
-		} else {
-			opts.log = log
-		}
+		opts.log = log
 	}
 }
 
-// WithErrLogger is the option to set the Logger
+// WithErrLogger is the option to set the error Logger
+// (nil means the global error logger)
 func WithErrLogger(errlog logger.Logger) Option {
 	return func(opts *mtmOptions) {
-		if errlog == nil {
-			opts.errlog = logger.GetErrLogger()
-		} else {
-			opts.errlog = errlog
-		}
+		opts.errlog = errlog
+	}
+}
+
+// WithSlogLogger is the option to set the structured logger,
+// which takes precedence over WithLogger and WithErrLogger
+// (nil means the global structured logger)
+func WithSlogLogger(l *slog.Logger) Option {
+	return func(opts *mtmOptions) {
+		opts.slogLogger = l
+	}
+}
+
+// structuredLogger returns the structured logger for the options,
+// or nil to use the global structured logger
+func (opts *mtmOptions) structuredLogger() *slog.Logger {
+	if opts.slogLogger != nil {
+		return opts.slogLogger
+	}
+	if opts.log != nil || opts.errlog != nil {
+		return slog.New(logger.NewFuncHandler(opts.log, opts.errlog))
 	}
+	return nil
 }
 
 // WithStatFS is the option to set the Stat FileSystem
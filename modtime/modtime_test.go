@@ -219,7 +219,7 @@ func TestConcurrency(t *testing.T) {
 	)
 
 	modTimeUpdaterLoop := func(ctx context.Context, t *testing.T, pathStr string, updateIntv time.Duration) {
-		mtm.log(fmt.Sprintf("modTimeUpdaterLoop(%s) started", pathStr))
+		mtm.logger().Debug(fmt.Sprintf("modTimeUpdaterLoop(%s) started", pathStr))
 		updateTicker := time.NewTicker(updateIntv)
 		defer updateTicker.Stop()
 	loop:
@@ -237,11 +237,11 @@ func TestConcurrency(t *testing.T) {
 				}
 			}
 		}
-		mtm.log(fmt.Sprintf("modTimeUpdaterLoop(%s) terminated", pathStr))
+		mtm.logger().Debug(fmt.Sprintf("modTimeUpdaterLoop(%s) terminated", pathStr))
 	}
 
 	modTimeReaderLoop := func(ctx context.Context, t *testing.T, pathStr string, readIntv time.Duration) {
-		mtm.log(fmt.Sprintf("modTimeReaderLoop(%s) started", pathStr))
+		mtm.logger().Debug(fmt.Sprintf("modTimeReaderLoop(%s) started", pathStr))
 		readTicker := time.NewTicker(readIntv)
 		defer readTicker.Stop()
 	loop:
@@ -258,7 +258,7 @@ func TestConcurrency(t *testing.T) {
 				}
 			}
 		}
-		mtm.log(fmt.Sprintf("modTimeReaderLoop(%s) terminated", pathStr))
+		mtm.logger().Debug(fmt.Sprintf("modTimeReaderLoop(%s) terminated", pathStr))
 	}
 
 	// Add paths to monitor
@@ -0,0 +1,108 @@
+package hotload
+
+import (
+	"log/slog"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/infobloxopen/hotload/logger"
+)
+
+const closeWindow = "closeWindow"
+const applyDelay = "applyDelay"
+
+// randInt63n is the source of the random delays (replaced in tests)
+var randInt63n = rand.Int63n
+
+// randDuration returns a random duration in [0, max)
+func randDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(randInt63n(int64(max)))
+}
+
+// delayApply waits a random delay (up to the apply delay) before a new value is applied,
+// so that the processes sharing the same location do not all switch over at the same time.
+// Values received meanwhile supersede the new value.
+// Returns the latest value, and false if the update channel was closed meanwhile.
+func (cg *chanGroup) delayApply(newValue string) (string, bool) {
+	delay := randDuration(cg.applyDelay)
+	if delay <= 0 {
+		return newValue, true
+	}
+	logger.DebugC("chanGroup.delayApply", "delaying new conn dsn",
+		slog.String(logger.NameKey, cg.name),
+		slog.Duration("delay", delay),
+	)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return newValue, true
+		case <-cg.parentCtx.Done():
+			return newValue, true
+		case v, ok := <-cg.newValChan:
+			if !ok {
+				return newValue, false
+			}
+			logger.DebugC("chanGroup.delayApply", "new conn dsn superseded while delayed",
+				slog.String(logger.NameKey, cg.name),
+			)
+			newValue = v
+		}
+	}
+}
+
+// pace calls fn for each conn at a random time within the close window (in random order),
+// then calls done (if not nil). It returns immediately if the parent context is done.
+func (cg *chanGroup) pace(conns []*managedConn, fn func(*managedConn), done func()) {
+	if done != nil {
+		defer done()
+	}
+
+	offsets := make([]time.Duration, len(conns))
+	for i := range offsets {
+		offsets[i] = randDuration(cg.closeWindow)
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+	conns = append([]*managedConn{}, conns...)
+	rand.Shuffle(len(conns), func(i, j int) { conns[i], conns[j] = conns[j], conns[i] })
+
+	start := time.Now()
+	for i, c := range conns {
+		if cg.parentCtx.Err() == nil {
+			timer := time.NewTimer(time.Until(start.Add(offsets[i])))
+			select {
+			case <-timer.C:
+			case <-cg.parentCtx.Done():
+				timer.Stop()
+			}
+		}
+		fn(c)
+	}
+}
+
+// retireConn cancels the conn, waits for its in-flight operations,
+// then resets and closes it
+func (cg *chanGroup) retireConn(c *managedConn) {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	cg.waitConnsIdle([]*managedConn{c})
+	logger.DebugC("chanGroup.retireConn", "reset/close conn", slog.String(logger.NameKey, cg.name),
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
+	c.Reset(true)
+	// ignore errors from close
+	c.Close()
+}
+
+// resetConn resets (but does not close) the conn
+func (cg *chanGroup) resetConn(c *managedConn) {
+	c.Reset(true)
+}
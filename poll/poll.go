@@ -0,0 +1,303 @@
+// Package poll implements a hotload strategy that periodically stats and hashes
+// a config file, for filesystems where fsnotify events are not reliably
+// delivered (eg: NFS, CIFS, some FUSE mounts or container bind mounts).
+//
+// Import it to register the "poll" strategy:
+//
+//	import _ "github.com/infobloxopen/hotload/poll"
+//
+//	db, err := sql.Open("hotload", "poll://postgres/tmp/myconfig.txt?interval=10s")
+package poll
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infobloxopen/hotload"
+	"github.com/infobloxopen/hotload/internal"
+	"github.com/infobloxopen/hotload/internal/backoff"
+	"github.com/infobloxopen/hotload/internal/fanout"
+	"github.com/infobloxopen/hotload/logger"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	hotload.RegisterStrategy("poll", NewStrategy())
+}
+
+const intervalParam = "interval"
+
+// ErrInvalidInterval is returned by Watch when the interval query param is invalid
+var ErrInvalidInterval = errors.New("invalid poll interval")
+
+// NewStrategy implements a hotload strategy that monitors config changes
+// in a file by polling it.
+func NewStrategy(opts ...Option) *Strategy {
+	defOpts := newDefaultOptions()
+	for _, opt := range opts {
+		opt(defOpts)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Strategy{
+		ctx:           ctx,
+		cancel:        cancel,
+		statFS:        defOpts.statFS,
+		interval:      defOpts.interval,
+		fullReadEvery: defOpts.fullReadEvery,
+		polls:         make(map[string]*pathPoll),
+	}
+	s.watches = fanout.New("poll", s.onCloseWatch)
+	return s
+}
+
+// Strategy implements the hotload Strategy interface by periodically
+// stat-ing and hashing the watched files.
+type Strategy struct {
+	mu sync.Mutex
+	// ctx is the lifetime of the strategy, the paths are polled until it is done
+	ctx           context.Context
+	cancel        context.CancelFunc
+	statFS        fs.StatFS // use io/fs.FS so we can mock FileSystem for unit-tests
+	interval      time.Duration
+	fullReadEvery int
+	watches       *fanout.Watches
+	polls         map[string]*pathPoll
+}
+
+// pathPoll is the polling state of a watched path
+type pathPoll struct {
+	cancel context.CancelFunc
+	// intervals are the polling intervals requested by the queries of the path,
+	// the path is polled at the shortest one
+	intervals map[string]time.Duration
+	// wake wakes up the polling loop when the intervals changed
+	wake chan struct{}
+
+	// only accessed by the polling loop (once started)
+	sum [sha256.Size]byte
+	// size and modTime are the stat of the last file read,
+	// the file is not read again until one of them changes,
+	// or until it was skipped fullReadEvery times
+	size    int64
+	modTime time.Time
+	skipped int
+	failing bool
+}
+
+// Watch implements the hotload.Strategy interface.
+// The path is polled at the interval set by the interval query param
+// (eg: "interval=10s"), or at the strategy's default interval.
+func (s *Strategy) Watch(ctx context.Context, pth string, pathQry string) (value string, values <-chan string, err error) {
+	pth = path.Clean(pth)
+	pathQry = strings.TrimSpace(pathQry)
+	interval, err := s.parseInterval(pathQry)
+	if err != nil {
+		return "", nil, err
+	}
+
+	value, values, err = s.watches.Watch(pth, pathQry, func() (string, error) {
+		fInfo, err := s.statConfigFile(pth)
+		if err != nil {
+			return "", err
+		}
+		val, sum, err := s.readConfigFile(pth)
+		if err != nil {
+			return "", err
+		}
+		// the polling outlives the Watch call, it stops with the path or the strategy
+		pollCtx, cancel := context.WithCancel(s.ctx)
+		pp := &pathPoll{
+			cancel:    cancel,
+			intervals: make(map[string]time.Duration),
+			wake:      make(chan struct{}, 1),
+			sum:       sum,
+			size:      fInfo.Size(),
+			modTime:   fInfo.ModTime(),
+		}
+		s.mu.Lock()
+		s.polls[pth] = pp
+		s.mu.Unlock()
+		go s.pollLoop(pollCtx, pth, pp)
+		return val, nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pp, ok := s.polls[pth]; ok {
+		pp.intervals[pathQry] = interval
+		pp.wakeUp()
+	}
+	return value, values, nil
+}
+
+// CloseWatch implements the hotload.Strategy interface.
+// Closes the specified watch by closing its update channel,
+// the path is no longer polled once all its watches are closed.
+func (s *Strategy) CloseWatch(pth string, pathQry string) error {
+	pth = path.Clean(pth)
+	pathQry = strings.TrimSpace(pathQry)
+	s.watches.CloseWatch(pth, pathQry)
+	return nil
+}
+
+// onCloseWatch forgets the interval of the closed query,
+// and stops polling the path once its last query is closed
+func (s *Strategy) onCloseWatch(pth, pathQry string, last bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pp, ok := s.polls[pth]
+	if !ok {
+		return
+	}
+	delete(pp.intervals, pathQry)
+	if last {
+		pp.cancel()
+		delete(s.polls, pth)
+		logger.DebugC("poll.processWatchClosure", "stopped polling path", slog.String(logger.PathKey, pth))
+		return
+	}
+	pp.wakeUp()
+}
+
+// Close implements the hotload.Strategy interface.
+// Closes this strategy by stopping all the polling
+// and closing all the update channels.
+func (s *Strategy) Close() {
+	s.mu.Lock()
+	polls := s.polls
+	s.polls = make(map[string]*pathPoll)
+	s.mu.Unlock()
+	for _, pp := range polls {
+		pp.cancel()
+	}
+	s.cancel()
+	s.watches.Close()
+}
+
+func (s *Strategy) parseInterval(pathQry string) (time.Duration, error) {
+	vs, err := url.ParseQuery(pathQry)
+	if err != nil {
+		return 0, errors.Wrapf(err, "could not parse query %v", pathQry)
+	}
+	return backoff.ParseDuration(vs, intervalParam, s.interval, ErrInvalidInterval)
+}
+
+// statConfigFile stats the file, which must not be a directory
+func (s *Strategy) statConfigFile(pth string) (fs.FileInfo, error) {
+	// When using fs.FS, paths must be unrooted
+	// See https://pkg.go.dev/io/fs#ValidPath
+	fInfo, err := s.statFS.Stat(internal.UnrootedPath(pth))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not stat %v", pth)
+	}
+	if fInfo.IsDir() {
+		return nil, errors.Errorf("could not read %v: is a directory", pth)
+	}
+	return fInfo, nil
+}
+
+// readConfigFile reads the trimmed content of the file and its hash
+func (s *Strategy) readConfigFile(pth string) (string, [sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	bs, err := fs.ReadFile(s.statFS, internal.UnrootedPath(pth))
+	if err != nil {
+		return "", sum, errors.Wrapf(err, "could not read %v", pth)
+	}
+	val := strings.TrimSpace(string(bs))
+	return val, sha256.Sum256([]byte(val)), nil
+}
+
+// pollInterval returns the shortest interval requested by the queries of the path
+func (s *Strategy) pollInterval(pp *pathPoll) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	interval := time.Duration(0)
+	for _, d := range pp.intervals {
+		if interval <= 0 || d < interval {
+			interval = d
+		}
+	}
+	if interval <= 0 {
+		interval = s.interval
+	}
+	return interval
+}
+
+// pollLoop polls the path until ctx is done (the path is no longer watched)
+func (s *Strategy) pollLoop(ctx context.Context, pth string, pp *pathPoll) {
+	logger.DebugC("poll.pollLoop", "started", slog.String(logger.PathKey, pth))
+	for {
+		interval := s.pollInterval(pp)
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.DebugC("poll.pollLoop", "terminated", slog.String(logger.PathKey, pth))
+			return
+		case <-pp.wake:
+			// the interval changed, restart the timer
+			timer.Stop()
+		case <-timer.C:
+			s.poll(pth, pp)
+		}
+	}
+}
+
+// poll reads the file if its size or modtime changed, or every fullReadEvery polls
+// (eg: the file was rewritten within the modtime granularity),
+// and sends its value to the watches if its hash changed
+func (s *Strategy) poll(pth string, pp *pathPoll) {
+	fInfo, err := s.statConfigFile(pth)
+	if err == nil && !pp.failing && fInfo.Size() == pp.size && fInfo.ModTime().Equal(pp.modTime) {
+		pp.skipped++
+		if pp.skipped < s.fullReadEvery {
+			return
+		}
+	}
+	pp.skipped = 0
+	var val string
+	var sum [sha256.Size]byte
+	if err == nil {
+		val, sum, err = s.readConfigFile(pth)
+	}
+	if err != nil {
+		if !pp.failing {
+			// only log the first failure, until the file can be read again
+			logger.ErrorC("poll.poll", "poll failed",
+				slog.String(logger.PathKey, pth),
+				logger.Err(err),
+			)
+		}
+		pp.failing = true
+		return
+	}
+	if pp.failing {
+		logger.DebugC("poll.poll", "poll recovered", slog.String(logger.PathKey, pth))
+		pp.failing = false
+	}
+	pp.size = fInfo.Size()
+	pp.modTime = fInfo.ModTime()
+	if sum == pp.sum {
+		return
+	}
+	logger.DebugC("poll.poll", "file changed", slog.String(logger.PathKey, pth))
+	pp.sum = sum
+	s.watches.Set(pth, val)
+}
+
+func (pp *pathPoll) wakeUp() {
+	select {
+	case pp.wake <- struct{}{}:
+	default:
+	}
+}
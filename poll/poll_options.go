@@ -0,0 +1,73 @@
+package poll
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+var (
+	// DefaultStatFS is the default Stat FileSystem to poll paths,
+	// which is the host Unix filesystem rooted at "/"
+	DefaultStatFS = os.DirFS("/").(fs.StatFS)
+
+	// DefaultInterval is the default interval for polling paths
+	// (when the hotload connection string has no interval query param)
+	DefaultInterval = time.Second * 10
+
+	// DefaultFullReadEvery is the default number of polls after which a path is
+	// read and hashed again, even if its size and modtime did not change
+	DefaultFullReadEvery = 10
+)
+
+type pollOptions struct {
+	statFS   fs.StatFS
+	interval time.Duration
+	// fullReadEvery is the number of polls between two full reads of a path
+	fullReadEvery int
+}
+
+type Option func(*pollOptions)
+
+func newDefaultOptions() *pollOptions {
+	return &pollOptions{
+		statFS:        DefaultStatFS,
+		interval:      DefaultInterval,
+		fullReadEvery: DefaultFullReadEvery,
+	}
+}
+
+// WithStatFS is the option to set the Stat FileSystem
+func WithStatFS(statFS fs.StatFS) Option {
+	return func(opts *pollOptions) {
+		if statFS == nil {
+			opts.statFS = DefaultStatFS
+		} else {
+			opts.statFS = statFS
+		}
+	}
+}
+
+// WithInterval is the option to set the default polling interval
+func WithInterval(interval time.Duration) Option {
+	return func(opts *pollOptions) {
+		if interval <= 0 {
+			opts.interval = DefaultInterval
+		} else {
+			opts.interval = interval
+		}
+	}
+}
+
+// WithFullReadEvery is the option to set the number of polls after which
+// a path is read again even if its size and modtime did not change
+// (eg: 1 reads the path at every poll)
+func WithFullReadEvery(n int) Option {
+	return func(opts *pollOptions) {
+		if n <= 0 {
+			opts.fullReadEvery = DefaultFullReadEvery
+		} else {
+			opts.fullReadEvery = n
+		}
+	}
+}
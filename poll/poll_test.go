@@ -0,0 +1,146 @@
+package poll
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing/fstest"
+	"time"
+
+	"github.com/infobloxopen/hotload/internal"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Strategy", func() {
+	const pth = "/etc/hotload/dsn.txt"
+
+	var mfs *internal.SafeMapFS
+	var s *Strategy
+
+	writeFile := func(content string) {
+		mfs.UpsertMapFile(pth, &fstest.MapFile{Data: []byte(content), ModTime: time.Now()})
+	}
+
+	BeforeEach(func() {
+		mfs = internal.NewSafeMapFS()
+		s = NewStrategy(WithStatFS(mfs), WithInterval(time.Hour))
+		DeferCleanup(s.Close)
+	})
+
+	It("Should return the trimmed value and send the changes", func(ctx context.Context) {
+		writeFile(" \t a \r\n")
+		value, values, err := s.Watch(ctx, pth, "interval=10ms")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("a"))
+
+		writeFile("b")
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should not send when only the modtime or whitespace changed", func(ctx context.Context) {
+		writeFile("a")
+		_, values, err := s.Watch(ctx, pth, "interval=10ms")
+		Expect(err).ToNot(HaveOccurred())
+
+		writeFile("a\n")
+		Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+	}, NodeTimeout(5*time.Second))
+
+	It("Should not read the file again while its size and modtime are unchanged", func(ctx context.Context) {
+		s = NewStrategy(WithStatFS(mfs), WithInterval(time.Hour), WithFullReadEvery(1000))
+		DeferCleanup(s.Close)
+		modTime := time.Now()
+		mfs.UpsertMapFile(pth, &fstest.MapFile{Data: []byte("a"), ModTime: modTime})
+		_, values, err := s.Watch(ctx, pth, "interval=10ms")
+		Expect(err).ToNot(HaveOccurred())
+
+		mfs.UpsertMapFile(pth, &fstest.MapFile{Data: []byte("b"), ModTime: modTime})
+		Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+
+		mfs.UpsertMapFile(pth, &fstest.MapFile{Data: []byte("b"), ModTime: modTime.Add(time.Second)})
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should read the file again every fullReadEvery polls", func(ctx context.Context) {
+		s = NewStrategy(WithStatFS(mfs), WithInterval(time.Hour), WithFullReadEvery(5))
+		DeferCleanup(s.Close)
+		modTime := time.Now()
+		mfs.UpsertMapFile(pth, &fstest.MapFile{Data: []byte("a"), ModTime: modTime})
+		_, values, err := s.Watch(ctx, pth, "interval=10ms")
+		Expect(err).ToNot(HaveOccurred())
+
+		// same size and modtime: only found by the full read
+		mfs.UpsertMapFile(pth, &fstest.MapFile{Data: []byte("b"), ModTime: modTime})
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should keep polling after the Watch ctx is done", func(ctx context.Context) {
+		writeFile("a")
+		watchCtx, cancel := context.WithCancel(ctx)
+		_, values, err := s.Watch(watchCtx, pth, "interval=10ms")
+		Expect(err).ToNot(HaveOccurred())
+		cancel()
+
+		writeFile("b")
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should keep watching while the file is missing", func(ctx context.Context) {
+		writeFile("a")
+		_, values, err := s.Watch(ctx, pth, "interval=10ms")
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = mfs.RemoveMapFile(pth)
+		Expect(err).ToNot(HaveOccurred())
+		Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+
+		writeFile("b")
+		Eventually(values).Should(Receive(Equal("b")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should fan out to the watches of the same path, at the shortest interval", func(ctx context.Context) {
+		writeFile("a")
+		_, values1, err := s.Watch(ctx, pth, "")
+		Expect(err).ToNot(HaveOccurred())
+		qry := url.Values{intervalParam: []string{"10ms"}, "forceKill": []string{"true"}}.Encode()
+		_, values2, err := s.Watch(ctx, pth, qry)
+		Expect(err).ToNot(HaveOccurred())
+
+		writeFile("b")
+		Eventually(values1).Should(Receive(Equal("b")))
+		Eventually(values2).Should(Receive(Equal("b")))
+
+		// back to the default (1h) interval once the 10ms watch is closed
+		Expect(s.CloseWatch(pth, qry)).To(Succeed())
+		Eventually(values2).Should(BeClosed())
+		writeFile("c")
+		Consistently(values1, 100*time.Millisecond).ShouldNot(Receive())
+	}, NodeTimeout(5*time.Second))
+
+	It("Should stop polling once the last watch is closed", func(ctx context.Context) {
+		writeFile("a")
+		_, values, err := s.Watch(ctx, pth, "interval=10ms")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(s.CloseWatch(pth, "interval=10ms")).To(Succeed())
+		Eventually(values).Should(BeClosed())
+		Eventually(func() int {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return len(s.polls)
+		}).Should(BeZero())
+	}, NodeTimeout(5*time.Second))
+
+	It("Should fail to watch a missing file", func(ctx context.Context) {
+		_, _, err := s.Watch(ctx, pth, "")
+		Expect(err).To(HaveOccurred())
+		Expect(s.polls).To(BeEmpty())
+	})
+
+	It("Should fail to watch with an invalid interval", func(ctx context.Context) {
+		writeFile("a")
+		_, _, err := s.Watch(ctx, pth, "interval=-1s")
+		Expect(errors.Is(err, ErrInvalidInterval)).To(BeTrue())
+	})
+})
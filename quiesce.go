@@ -0,0 +1,196 @@
+package hotload
+
+import (
+	"context"
+	"database/sql/driver"
+	"log/slog"
+	"time"
+
+	"github.com/infobloxopen/hotload/logger"
+	"github.com/infobloxopen/hotload/metrics"
+)
+
+// DefaultQuiesceTimeout is the default maximum time a quiesced switchover
+// waits for the transactions of the previous generation to finish
+const DefaultQuiesceTimeout = 5 * time.Second
+
+const switchoverMode = "switchoverMode"
+const quiesceTimeout = "quiesceTimeout"
+
+const (
+	// switchoverGraceful (the default) lets the previous generation serve new work while draining
+	switchoverGraceful = "graceful"
+	// switchoverQuiesce holds new work until the transactions of the previous generation finished
+	switchoverQuiesce = "quiesce"
+)
+
+// quiesceGate is set on a generation being quiesced, new work on its conns
+// (and new conns) is held until done is closed
+type quiesceGate struct {
+	name string
+	done chan struct{}
+}
+
+// wait waits until the gate is opened or ctx is done,
+// and reports how long the caller was held
+func (g *quiesceGate) wait(ctx context.Context) {
+	select {
+	case <-g.done:
+		// opened already, the caller was not held
+		return
+	default:
+	}
+	start := time.Now()
+	select {
+	case <-g.done:
+	case <-ctx.Done():
+	}
+	metrics.ObserveHotloadQuiesceHoldHistogram(g.name, time.Since(start).Seconds())
+}
+
+// quiesce holds new work on the active generation, and waits for its open transactions
+// to finish, for up to the quiesce timeout. Returns the gate to open once the switchover
+// is completed, or nil if the switchover mode is not quiesce.
+func (cg *chanGroup) quiesce() *quiesceGate {
+	if cg.switchoverMode != switchoverQuiesce {
+		return nil
+	}
+	cg.mu.RLock()
+	gen := cg.active()
+	cg.mu.RUnlock()
+
+	gate := &quiesceGate{name: cg.name, done: make(chan struct{})}
+	gen.gate.Store(gate)
+
+	timeout := cg.quiesceTimeout
+	if timeout <= 0 {
+		timeout = DefaultQuiesceTimeout
+	}
+	ctx, cancel := context.WithTimeout(cg.parentCtx, timeout)
+	defer cancel()
+
+	logger.DebugC("chanGroup.quiesce", "holding new work, waiting for open transactions",
+		slog.String(logger.NameKey, cg.name),
+		slog.String(logger.DsnKey, gen.redactVal),
+		slog.Uint64(logger.GenerationKey, gen.id),
+	)
+	if !gen.waitTxIdle(ctx) {
+		logger.ErrorC("chanGroup.quiesce", "timed out waiting for open transactions",
+			slog.String(logger.NameKey, cg.name),
+			slog.String(logger.DsnKey, gen.redactVal),
+			slog.Uint64(logger.GenerationKey, gen.id),
+			slog.Duration("timeout", timeout),
+			slog.Int("openTxs", gen.openTxs()),
+		)
+	}
+	return gate
+}
+
+// holdOpen holds a new conn while the active generation is quiesced,
+// returns the caller's ctx error if it is done first
+func (cg *chanGroup) holdOpen(ctx context.Context) error {
+	cg.mu.RLock()
+	gate := cg.active().gate.Load()
+	cg.mu.RUnlock()
+	if gate != nil {
+		gate.wait(ctx)
+	}
+	return ctx.Err()
+}
+
+// hold holds new work (outside of a transaction) while the conn's generation is quiesced.
+// Returns nil if the work was not held, otherwise the caller's ctx error or an
+// ErrBadConn rotation error, so that database/sql retries the work on a new conn.
+func (c *managedConn) hold(ctx context.Context) error {
+	if c.gen == nil || c.inTx.Load() {
+		return nil
+	}
+	gate := c.gen.gate.Load()
+	if gate == nil {
+		return nil
+	}
+	gate.wait(ctx)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	logger.DebugC("managedConn.hold", "generation quiesced",
+		slog.String(logger.DsnKey, c.redactDsn),
+		slog.Uint64(logger.GenerationKey, c.generation),
+	)
+	return c.rotationErr(driver.ErrBadConn)
+}
+
+// holdBeginTx registers the transaction about to begin on the conn, unless the conn's
+// generation is quiesced: the registration is then undone and the transaction is held
+// like new work (see hold). The transaction is registered before the quiesce gate is
+// checked, so that a quiesce either holds it or waits for it to end.
+func (c *managedConn) holdBeginTx(ctx context.Context) error {
+	for {
+		c.beginTx()
+		if c.gen == nil || c.gen.gate.Load() == nil {
+			return nil
+		}
+		c.endTx()
+		if err := c.hold(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// beginTx records a transaction started on the conn
+func (c *managedConn) beginTx() {
+	if c.inTx.CompareAndSwap(false, true) && c.gen != nil {
+		c.gen.beginTx()
+	}
+}
+
+// endTx records the end (commit or rollback) of the transaction started on the conn
+func (c *managedConn) endTx() {
+	if c.inTx.CompareAndSwap(true, false) && c.gen != nil {
+		c.gen.endTx()
+	}
+}
+
+func (g *generation) beginTx() {
+	g.txMu.Lock()
+	g.txs++
+	g.txMu.Unlock()
+}
+
+func (g *generation) endTx() {
+	g.txMu.Lock()
+	defer g.txMu.Unlock()
+	g.txs--
+	if g.txs <= 0 && g.txIdle != nil {
+		close(g.txIdle)
+		g.txIdle = nil
+	}
+}
+
+func (g *generation) openTxs() int {
+	g.txMu.Lock()
+	defer g.txMu.Unlock()
+	return g.txs
+}
+
+// waitTxIdle waits until there are no open transactions on the generation,
+// returns false if ctx is done first
+func (g *generation) waitTxIdle(ctx context.Context) bool {
+	g.txMu.Lock()
+	if g.txs <= 0 {
+		g.txMu.Unlock()
+		return true
+	}
+	if g.txIdle == nil {
+		g.txIdle = make(chan struct{})
+	}
+	idle := g.txIdle
+	g.txMu.Unlock()
+
+	select {
+	case <-idle:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
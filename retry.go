@@ -0,0 +1,164 @@
+package hotload
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/infobloxopen/hotload/logger"
+)
+
+const (
+	// DefaultMaxRetries is the default count of retries by RunInTx
+	DefaultMaxRetries = 3
+
+	// DefaultInitialBackoff is the default backoff before the first retry by RunInTx
+	DefaultInitialBackoff = 10 * time.Millisecond
+
+	// DefaultMaxBackoff is the default maximum backoff between retries by RunInTx
+	DefaultMaxBackoff = time.Second
+)
+
+type retryOptions struct {
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// RetryOption is an option for RunInTx
+type RetryOption func(*retryOptions)
+
+func newDefaultRetryOptions() *retryOptions {
+	return &retryOptions{
+		maxRetries:     DefaultMaxRetries,
+		initialBackoff: DefaultInitialBackoff,
+		maxBackoff:     DefaultMaxBackoff,
+	}
+}
+
+// WithMaxRetries is the option to set the retry budget
+// (count of retries after the first attempt, zero disables retries)
+func WithMaxRetries(maxRetries int) RetryOption {
+	return func(opts *retryOptions) {
+		if maxRetries < 0 {
+			opts.maxRetries = DefaultMaxRetries
+		} else {
+			opts.maxRetries = maxRetries
+		}
+	}
+}
+
+// WithBackoff is the option to set the backoff between retries.
+// The backoff starts at initial and doubles after each retry, up to max.
+func WithBackoff(initial, max time.Duration) RetryOption {
+	return func(opts *retryOptions) {
+		if initial <= 0 {
+			initial = DefaultInitialBackoff
+		}
+		if max < initial {
+			max = initial
+		}
+		opts.initialBackoff = initial
+		opts.maxBackoff = max
+	}
+}
+
+// RunInTx runs fn in a transaction, committing if fn returns nil
+// and rolling back otherwise.
+//
+// If the transaction fails because hotload canceled or closed its connection
+// during a switchover (ie: the error matches ErrConnectionRotated), the whole
+// transaction is retried on a fresh connection from the new generation,
+// up to the retry budget (see WithMaxRetries and WithBackoff).
+// Errors that do not come from a rotation are never retried.
+// Because fn may be called more than once, it should not have
+// side-effects outside of the transaction.
+//
+// Errors from Commit are never retried, even when they match ErrConnectionRotated:
+// the connection may have been closed after the database applied the commit,
+// so the outcome of the transaction is unknown and retrying it could apply it twice.
+// The caller decides how to handle that ambiguity (eg: by checking the result).
+func RunInTx(ctx context.Context, db *sql.DB, txOpts *sql.TxOptions, fn func(*sql.Tx) error, opts ...RetryOption) error {
+	retryOpts := newDefaultRetryOptions()
+	for _, opt := range opts {
+		opt(retryOpts)
+	}
+
+	backoff := retryOpts.initialBackoff
+	for attempt := 0; ; attempt++ {
+		err := runTxOnce(ctx, db, txOpts, fn)
+		var commitErr *commitError
+		if errors.As(err, &commitErr) {
+			return commitErr.err
+		}
+		if err == nil || !errors.Is(err, ErrConnectionRotated) {
+			return err
+		}
+		if attempt >= retryOpts.maxRetries {
+			logger.Error("retries exhausted",
+				logger.Component("hotload.RunInTx"),
+				slog.Int("attempts", attempt+1),
+				logger.Err(err),
+			)
+			return err
+		}
+
+		logger.Debug("attempt failed due to rotation, retrying",
+			logger.Component("hotload.RunInTx"),
+			slog.Int("attempt", attempt+1),
+			slog.Duration("backoff", backoff),
+			logger.Err(err),
+		)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > retryOpts.maxBackoff {
+			backoff = retryOpts.maxBackoff
+		}
+	}
+}
+
+func runTxOnce(ctx context.Context, db *sql.DB, txOpts *sql.TxOptions, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, txOpts)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		// ignore errors from rollback, the original error is more relevant
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return &commitError{err: err}
+	}
+	return nil
+}
+
+// commitError tags the errors from Commit, which RunInTx does not retry
+type commitError struct {
+	err error
+}
+
+func (e *commitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *commitError) Unwrap() error {
+	return e.err
+}
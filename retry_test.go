@@ -0,0 +1,179 @@
+package hotload
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/infobloxopen/hotload/metrics"
+)
+
+// closableDriverConn is a mock driver conn that fails with
+// driver.ErrBadConn once it has been closed
+type closableDriverConn struct {
+	mockDriverConn
+	closed atomic.Bool
+}
+
+func (c *closableDriverConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+func (c *closableDriverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.closed.Load() {
+		return nil, driver.ErrBadConn
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *closableDriverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return closableTx{conn: c}, nil
+}
+
+// closableTx is a mock driver tx whose Commit fails with
+// driver.ErrBadConn once its conn has been closed
+type closableTx struct {
+	conn *closableDriverConn
+}
+
+func (t closableTx) Commit() error {
+	if t.conn.closed.Load() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (closableTx) Rollback() error {
+	return nil
+}
+
+type closableDriver struct{}
+
+func (closableDriver) Open(name string) (driver.Conn, error) {
+	return &closableDriverConn{}, nil
+}
+
+// chanGroupConnector opens conns from a chanGroup
+type chanGroupConnector struct {
+	cg *chanGroup
+}
+
+func (c chanGroupConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.cg.Open(ctx)
+}
+
+func (c chanGroupConnector) Driver() driver.Driver {
+	return closableDriver{}
+}
+
+var _ = Describe("RunInTx", func() {
+	var cg *chanGroup
+	var db *sql.DB
+	var fastRetry RetryOption
+
+	var cancel context.CancelFunc
+
+	BeforeEach(func() {
+		var pctx context.Context
+		pctx, cancel = context.WithCancel(context.Background())
+		cg = newChanGroup(pctx, "fsnotify://postgres/tmp/mydsn.txt", "1st-dsn", make(chan string), &driverInstance{driver: closableDriver{}})
+		cg.forceKill = true
+		db = sql.OpenDB(chanGroupConnector{cg: cg})
+		fastRetry = WithBackoff(time.Millisecond, time.Millisecond)
+	})
+
+	AfterEach(func() {
+		db.Close()
+		cancel()
+
+		// Committed transactions are observed in the sql stmts summary
+		metrics.ResetCollectors()
+	})
+
+	It("Should commit without retrying when there are no errors", func() {
+		calls := 0
+		err := RunInTx(context.Background(), db, nil, func(tx *sql.Tx) error {
+			calls++
+			_, err := tx.ExecContext(context.Background(), "INSERT 1")
+			return err
+		}, fastRetry)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(calls).To(Equal(1))
+	})
+
+	It("Should retry on a new generation when the conn is rotated during the transaction", func() {
+		calls := 0
+		var generations []uint64
+		err := RunInTx(context.Background(), db, nil, func(tx *sql.Tx) error {
+			calls++
+			cg.mu.RLock()
+			generations = append(generations, cg.active().id)
+			cg.mu.RUnlock()
+			if calls == 1 {
+				cg.processNewValue("2nd-dsn")
+			}
+			_, err := tx.ExecContext(context.Background(), "INSERT 1")
+			return err
+		}, fastRetry)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(calls).To(Equal(2))
+		Expect(generations).To(Equal([]uint64{0, 1}))
+	})
+
+	It("Should not retry a commit failing because of a rotation", func() {
+		calls := 0
+		err := RunInTx(context.Background(), db, nil, func(tx *sql.Tx) error {
+			calls++
+			_, err := tx.ExecContext(context.Background(), "INSERT 1")
+			if calls == 1 {
+				cg.processNewValue("2nd-dsn")
+			}
+			return err
+		}, fastRetry)
+		Expect(err).To(MatchError(ErrConnectionRotated))
+		Expect(err).To(MatchError(driver.ErrBadConn))
+		Expect(calls).To(Equal(1), "the commit may have been applied")
+	})
+
+	It("Should not retry errors that do not come from a rotation", func() {
+		calls := 0
+		appErr := errors.New("application error")
+		err := RunInTx(context.Background(), db, nil, func(tx *sql.Tx) error {
+			calls++
+			return appErr
+		}, fastRetry)
+		Expect(err).To(MatchError(appErr))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("Should stop retrying when the retry budget is exhausted", func() {
+		calls := 0
+		rotErr := &RotationError{Err: driver.ErrBadConn}
+		err := RunInTx(context.Background(), db, nil, func(tx *sql.Tx) error {
+			calls++
+			return rotErr
+		}, fastRetry, WithMaxRetries(2))
+		Expect(err).To(MatchError(ErrConnectionRotated))
+		Expect(calls).To(Equal(3))
+	})
+
+	It("Should stop retrying when the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := RunInTx(ctx, db, nil, func(tx *sql.Tx) error {
+			calls++
+			cancel()
+			return &RotationError{Err: driver.ErrBadConn}
+		}, WithBackoff(time.Minute, time.Minute))
+		Expect(err).To(MatchError(ErrConnectionRotated))
+		Expect(err).To(MatchError(context.Canceled))
+		Expect(calls).To(Equal(1))
+	})
+})
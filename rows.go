@@ -0,0 +1,87 @@
+package hotload
+
+import (
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// managedRows wraps the rows returned by a managed conn's QueryContext,
+// to release the merged context once the rows are closed
+// (the underlying driver may keep using the context while reading the rows).
+//
+// The optional driver.Rows interfaces are forwarded to the underlying rows,
+// falling back to the same defaults that database/sql uses.
+type managedRows struct {
+	driver.Rows
+	release     func()
+	releaseOnce sync.Once
+}
+
+func newManagedRows(rows driver.Rows, release func()) *managedRows {
+	return &managedRows{Rows: rows, release: release}
+}
+
+// Close closes the underlying rows and releases the merged context
+func (r *managedRows) Close() error {
+	err := r.Rows.Close()
+	r.releaseOnce.Do(r.release)
+	return err
+}
+
+func (r *managedRows) HasNextResultSet() bool {
+	rows, ok := r.Rows.(driver.RowsNextResultSet)
+	if !ok {
+		return false
+	}
+	return rows.HasNextResultSet()
+}
+
+func (r *managedRows) NextResultSet() error {
+	rows, ok := r.Rows.(driver.RowsNextResultSet)
+	if !ok {
+		return io.EOF
+	}
+	return rows.NextResultSet()
+}
+
+func (r *managedRows) ColumnTypeScanType(index int) reflect.Type {
+	rows, ok := r.Rows.(driver.RowsColumnTypeScanType)
+	if !ok {
+		return reflect.TypeOf(new(any)).Elem()
+	}
+	return rows.ColumnTypeScanType(index)
+}
+
+func (r *managedRows) ColumnTypeDatabaseTypeName(index int) string {
+	rows, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName)
+	if !ok {
+		return ""
+	}
+	return rows.ColumnTypeDatabaseTypeName(index)
+}
+
+func (r *managedRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	rows, ok := r.Rows.(driver.RowsColumnTypeLength)
+	if !ok {
+		return 0, false
+	}
+	return rows.ColumnTypeLength(index)
+}
+
+func (r *managedRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	rows, ok := r.Rows.(driver.RowsColumnTypeNullable)
+	if !ok {
+		return false, false
+	}
+	return rows.ColumnTypeNullable(index)
+}
+
+func (r *managedRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	rows, ok := r.Rows.(driver.RowsColumnTypePrecisionScale)
+	if !ok {
+		return 0, 0, false
+	}
+	return rows.ColumnTypePrecisionScale(index)
+}
@@ -0,0 +1,98 @@
+package hotload
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infobloxopen/hotload/internal"
+	"github.com/infobloxopen/hotload/logger"
+	"github.com/infobloxopen/hotload/metrics"
+)
+
+var (
+	ErrEmptyValue = errors.New("hotload: empty connection string")
+
+	// emitTimes holds the time a strategy started emitting
+	// the pending value on an update channel (<-chan string -> time.Time)
+	emitTimes sync.Map
+)
+
+// MarkEmitted records the time a strategy started emitting a new value
+// on the update channel returned by Strategy.Watch.
+// Strategies should call it immediately before sending on the channel,
+// so that hotload can measure the switchover latency from emission
+// to switchover completion. It is optional; if not called,
+// the latency is measured from when hotload received the value.
+func MarkEmitted(updateChan <-chan string) {
+	emitTimes.Store(updateChan, time.Now())
+}
+
+// emittedTime returns (and forgets) the time the pending value
+// on the update channel was emitted, or defTime if unknown.
+func emittedTime(updateChan <-chan string, defTime time.Time) time.Time {
+	v, ok := emitTimes.LoadAndDelete(updateChan)
+	if !ok {
+		return defTime
+	}
+	return v.(time.Time)
+}
+
+// ForgetEmitted forgets any emission time recorded for the update channel.
+// Strategies that call MarkEmitted should call it when they close the channel
+// or give up sending the value, so that its emission time is not kept forever.
+func ForgetEmitted(updateChan <-chan string) {
+	emitTimes.Delete(updateChan)
+}
+
+// validateNewValue checks that a new value can be switched over to
+func (cg *chanGroup) validateNewValue(newValue string) error {
+	if len(strings.TrimSpace(newValue)) <= 0 {
+		return ErrEmptyValue
+	}
+	if cg.sqlDriver != nil {
+		if _, err := mergeConnStringOptions(newValue, cg.sqlDriver.options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// acceptNewValue returns true if the new value changed and is valid,
+// otherwise counts the switchover as unchanged or rejected
+func (cg *chanGroup) acceptNewValue(newValue string) bool {
+	cg.mu.RLock()
+	prevValue := cg.value
+	prevRedactVal := cg.redactVal
+	cg.mu.RUnlock()
+
+	newRedactVal := internal.RedactUrl(newValue)
+	logger.DebugC("chanGroup.processNewValue", "new conn dsn", slog.String(logger.NameKey, cg.name),
+		slog.String("prevDsn", prevRedactVal),
+		slog.String(logger.DsnKey, newRedactVal),
+	)
+
+	if newValue == prevValue {
+		// next update is the same, just ignore it
+		logger.DebugC("chanGroup.processNewValue", "conn dsn not changed",
+			slog.String(logger.NameKey, cg.name),
+		)
+		metrics.IncHotloadSwitchoverTotal(cg.name, metrics.SwitchoverUnchanged)
+		return false
+	}
+
+	if err := cg.validateNewValue(newValue); err != nil {
+		// next update is invalid, keep using the current value
+		logger.ErrorC("chanGroup.processNewValue", "rejected new conn dsn",
+			slog.String(logger.NameKey, cg.name),
+			slog.String(logger.DsnKey, newRedactVal),
+			logger.Err(err),
+		)
+		metrics.IncHotloadSwitchoverTotal(cg.name, metrics.SwitchoverRejected)
+		return false
+	}
+	logger.DebugC("chanGroup.processNewValue", "conn dsn changed", slog.String(logger.NameKey, cg.name))
+	return true
+}
@@ -0,0 +1,74 @@
+package tokenauth
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/rds/auth"
+	"github.com/infobloxopen/hotload/internal/awsconfig"
+	"github.com/pkg/errors"
+)
+
+const (
+	regionParam = "region"
+
+	// rdsTokenLifetime is the lifetime of the RDS IAM auth tokens (set by auth.BuildAuthToken)
+	rdsTokenLifetime = time.Minute * 15
+	rdsDefaultPort   = "5432"
+)
+
+// ErrMissingRegion is returned by the RDSIAMProvider when no region is set
+var ErrMissingRegion = errors.New("missing region")
+
+// RDSIAMProvider is a TokenProvider generating AWS RDS IAM auth tokens, which are
+// computed locally by the AWS SDK presigning a "connect" request, and expire after
+// 15 minutes. The region is the region query param, if set.
+type RDSIAMProvider struct {
+	// Credentials provides the AWS credentials (by default, of the AWS SDK default
+	// config: env vars, shared config files, web identity, container or instance role)
+	Credentials aws.CredentialsProvider
+	// Region is the default region (by default, of the AWS SDK default config)
+	Region string
+
+	awsConfig awsconfig.Loader
+}
+
+// Token implements the TokenProvider interface.
+func (p *RDSIAMProvider) Token(ctx context.Context, req TokenRequest) (Token, error) {
+	region := req.Params.Get(regionParam)
+	if region == "" {
+		region = p.Region
+	}
+	credentials := p.Credentials
+	if region == "" || credentials == nil {
+		cfg, err := p.awsConfig.Load(ctx)
+		if err != nil {
+			return Token{}, err
+		}
+		if region == "" {
+			region = cfg.Region
+		}
+		if credentials == nil {
+			credentials = cfg.Credentials
+		}
+	}
+	if region == "" {
+		return Token{}, ErrMissingRegion
+	}
+
+	port := req.Port
+	if port == "" {
+		port = rdsDefaultPort
+	}
+	now := time.Now()
+	token, err := auth.BuildAuthToken(ctx, net.JoinHostPort(req.Host, port), region, req.User, credentials)
+	if err != nil {
+		return Token{}, err
+	}
+	return Token{
+		Value:     token,
+		ExpiresAt: now.Add(rdsTokenLifetime),
+	}, nil
+}
@@ -0,0 +1,312 @@
+// Package tokenauth implements hotload strategies for short-lived auth tokens used as
+// database passwords (eg: AWS RDS IAM authentication). The strategy reads a base
+// connection string from the file at the path, injects a token generated by its
+// TokenProvider as the password, and sends a new connection string with a new token
+// a margin before the token expires.
+//
+// Import it to register the "rdsiam" strategy, generating RDS IAM auth tokens:
+//
+//	import _ "github.com/infobloxopen/hotload/tokenauth"
+//
+//	db, err := sql.Open("hotload", "rdsiam://postgres/etc/app/base-dsn?region=us-east-1&refreshMargin=5m")
+package tokenauth
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/infobloxopen/hotload"
+	"github.com/infobloxopen/hotload/internal/backoff"
+	"github.com/infobloxopen/hotload/internal/fanout"
+	"github.com/infobloxopen/hotload/internal/pqdsn"
+	"github.com/infobloxopen/hotload/logger"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	hotload.RegisterStrategy("rdsiam", NewStrategy(&RDSIAMProvider{}))
+}
+
+const (
+	refreshMarginParam = "refreshMargin"
+
+	// maxRetryDelay is the longest delay between the retries of failing refreshes
+	maxRetryDelay = time.Minute
+)
+
+var (
+	// ErrInvalidRefreshMargin is returned by Watch when the refreshMargin query param is invalid
+	ErrInvalidRefreshMargin = errors.New("invalid refreshMargin")
+	// ErrMissingUser is returned when the base connection string has no user
+	ErrMissingUser = errors.New("missing user in base connection string")
+)
+
+// TokenProvider generates the short-lived auth tokens injected as the password
+// of the base connection string
+type TokenProvider interface {
+	// Token returns a new token for the connection described by the request
+	Token(ctx context.Context, req TokenRequest) (Token, error)
+}
+
+// TokenProviderFunc is an adapter to use a function as a TokenProvider
+type TokenProviderFunc func(ctx context.Context, req TokenRequest) (Token, error)
+
+// Token calls f(ctx, req)
+func (f TokenProviderFunc) Token(ctx context.Context, req TokenRequest) (Token, error) {
+	return f(ctx, req)
+}
+
+// TokenRequest describes the connection a token is requested for
+type TokenRequest struct {
+	// Host, Port, User and DBName are from the base connection string
+	Host   string
+	Port   string
+	User   string
+	DBName string
+	// Params are the query params of the hotload connection string
+	Params url.Values
+}
+
+// Token is a short-lived auth token
+type Token struct {
+	Value string
+	// ExpiresAt is when the token expires (zero if it does not)
+	ExpiresAt time.Time
+}
+
+// NewStrategy implements a hotload strategy that injects the tokens of the provider
+// as the password of the base connection string.
+func NewStrategy(provider TokenProvider, opts ...Option) *Strategy {
+	defOpts := newDefaultOptions()
+	for _, opt := range opts {
+		opt(defOpts)
+	}
+	s := &Strategy{
+		provider:      provider,
+		refreshMargin: defOpts.refreshMargin,
+		refreshes:     make(map[string]*tokenRefresh),
+	}
+	s.watches = fanout.New("tokenauth", s.onCloseWatch)
+	return s
+}
+
+// Strategy implements the hotload Strategy interface by refreshing
+// the tokens before they expire.
+type Strategy struct {
+	mu            sync.Mutex
+	provider      TokenProvider
+	refreshMargin time.Duration
+	watches       *fanout.Watches
+	refreshes     map[string]*tokenRefresh
+}
+
+// tokenRefresh is the refresh state of a watched base connection string file
+type tokenRefresh struct {
+	// key identifies the watch of the path with the query
+	key           string
+	pth           string
+	params        url.Values
+	refreshMargin time.Duration
+	cancel        context.CancelFunc
+
+	// only accessed by the refresh loop (once started)
+	issuedAt  time.Time
+	expiresAt time.Time
+	value     string
+	failures  int
+}
+
+// Watch implements the hotload.Strategy interface.
+func (s *Strategy) Watch(ctx context.Context, pth string, pathQry string) (value string, values <-chan string, err error) {
+	pth = path.Clean(pth)
+	pathQry = strings.TrimSpace(pathQry)
+	params, err := url.ParseQuery(pathQry)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "could not parse query %v", pathQry)
+	}
+	refreshMargin, err := backoff.ParseDuration(params, refreshMarginParam, s.refreshMargin, ErrInvalidRefreshMargin)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the params may configure the provider, so each query has its own tokens
+	key := pth + "?" + pathQry
+	return s.watches.Watch(key, pathQry, func() (string, error) {
+		tr := &tokenRefresh{key: key, pth: pth, params: params, refreshMargin: refreshMargin}
+		if err := s.refresh(ctx, tr); err != nil {
+			return "", err
+		}
+		refreshCtx, cancel := context.WithCancel(ctx)
+		tr.cancel = cancel
+		s.mu.Lock()
+		s.refreshes[key] = tr
+		s.mu.Unlock()
+		go s.refreshLoop(refreshCtx, tr)
+		return tr.value, nil
+	})
+}
+
+// CloseWatch implements the hotload.Strategy interface.
+// Closes the specified watch by closing its update channel,
+// and stops refreshing its tokens.
+func (s *Strategy) CloseWatch(pth string, pathQry string) error {
+	pth = path.Clean(pth)
+	pathQry = strings.TrimSpace(pathQry)
+	s.watches.CloseWatch(pth+"?"+pathQry, pathQry)
+	return nil
+}
+
+// onCloseWatch stops refreshing the tokens once the last query is closed
+func (s *Strategy) onCloseWatch(key, pathQry string, last bool) {
+	if !last {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tr, ok := s.refreshes[key]
+	if !ok {
+		return
+	}
+	tr.cancel()
+	delete(s.refreshes, key)
+	logger.DebugC("tokenauth.processWatchClosure", "stopped refreshing tokens", slog.String(logger.PathKey, tr.pth))
+}
+
+// Close implements the hotload.Strategy interface.
+// Closes this strategy by stopping all the refreshes
+// and closing all the update channels.
+func (s *Strategy) Close() {
+	s.mu.Lock()
+	refreshes := s.refreshes
+	s.refreshes = make(map[string]*tokenRefresh)
+	s.mu.Unlock()
+	for _, tr := range refreshes {
+		tr.cancel()
+	}
+	s.watches.Close()
+}
+
+// refresh reads the base connection string (so its changes are applied at the next
+// refresh), and injects a new token as its password
+func (s *Strategy) refresh(ctx context.Context, tr *tokenRefresh) error {
+	bs, err := os.ReadFile(tr.pth)
+	if err != nil {
+		return errors.Wrapf(err, "could not read %v", tr.pth)
+	}
+	base := strings.TrimSpace(string(bs))
+
+	var req TokenRequest
+	var u *url.URL
+	var pairs pqdsn.Pairs
+	if strings.Contains(base, "://") {
+		// URL connection string (eg: "postgres://app@db:5432/app?sslmode=require")
+		if u, err = url.Parse(base); err != nil {
+			return errors.Wrap(err, "could not parse base connection string")
+		}
+		req = TokenRequest{
+			Host:   u.Hostname(),
+			Port:   u.Port(),
+			User:   u.User.Username(),
+			DBName: strings.TrimPrefix(u.Path, "/"),
+		}
+	} else {
+		// key/value connection string (eg: "host=db port=5432 user=app dbname=app")
+		if pairs, err = pqdsn.Parse(base); err != nil {
+			return errors.Wrap(err, "could not parse base connection string")
+		}
+		req = TokenRequest{
+			Host:   pairs.Get("host"),
+			Port:   pairs.Get("port"),
+			User:   pairs.Get("user"),
+			DBName: pairs.Get("dbname"),
+		}
+	}
+	if req.User == "" {
+		return ErrMissingUser
+	}
+	req.Params = tr.params
+
+	now := time.Now()
+	token, err := s.provider.Token(ctx, req)
+	if err != nil {
+		return err
+	}
+	if u != nil {
+		u.User = url.UserPassword(req.User, token.Value)
+		tr.value = u.String()
+	} else {
+		tr.value = pairs.Set("password", token.Value).String()
+	}
+	tr.issuedAt = now
+	tr.expiresAt = token.ExpiresAt
+	return nil
+}
+
+// nextDelay returns the delay until the token is refreshed, refreshMargin before
+// it expires (or halfway through its lifetime if it is shorter than refreshMargin)
+func (tr *tokenRefresh) nextDelay(now time.Time) time.Duration {
+	if tr.failures > 0 {
+		return backoff.Delay(time.Second, maxRetryDelay, tr.failures-1)
+	}
+	lifetime := tr.expiresAt.Sub(tr.issuedAt)
+	refreshAt := tr.expiresAt.Add(-tr.refreshMargin)
+	if tr.refreshMargin >= lifetime {
+		refreshAt = tr.issuedAt.Add(lifetime / 2)
+	}
+	return max(refreshAt.Sub(now), 0)
+}
+
+// refreshLoop refreshes the tokens until ctx is done (the path is no longer watched)
+func (s *Strategy) refreshLoop(ctx context.Context, tr *tokenRefresh) {
+	logger.DebugC("tokenauth.refreshLoop", "started", slog.String(logger.PathKey, tr.pth))
+	for {
+		if tr.expiresAt.IsZero() && tr.failures == 0 {
+			// the token does not expire
+			<-ctx.Done()
+			logger.DebugC("tokenauth.refreshLoop", "terminated", slog.String(logger.PathKey, tr.pth))
+			return
+		}
+		timer := time.NewTimer(tr.nextDelay(time.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.DebugC("tokenauth.refreshLoop", "terminated", slog.String(logger.PathKey, tr.pth))
+			return
+		case <-timer.C:
+			s.step(ctx, tr)
+		}
+	}
+}
+
+// step refreshes the token, and sends the new connection string
+func (s *Strategy) step(ctx context.Context, tr *tokenRefresh) {
+	prevValue := tr.value
+	if err := s.refresh(ctx, tr); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		if tr.failures == 0 {
+			// only log the first failure, until a token is refreshed
+			logger.ErrorC("tokenauth.step", "failed to refresh token",
+				slog.String(logger.PathKey, tr.pth),
+				logger.Err(err),
+			)
+		}
+		tr.failures++
+		return
+	}
+	tr.failures = 0
+	logger.DebugC("tokenauth.step", "refreshed token",
+		slog.String(logger.PathKey, tr.pth),
+		slog.Time("expiresAt", tr.expiresAt),
+	)
+	if tr.value != prevValue {
+		s.watches.Set(tr.key, tr.value)
+	}
+}
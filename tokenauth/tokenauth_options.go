@@ -0,0 +1,30 @@
+package tokenauth
+
+import "time"
+
+// DefaultRefreshMargin is the default margin before the expiry of a token
+// to refresh it (when the hotload connection string has no refreshMargin query param)
+var DefaultRefreshMargin = time.Minute * 5
+
+type tokenOptions struct {
+	refreshMargin time.Duration
+}
+
+type Option func(*tokenOptions)
+
+func newDefaultOptions() *tokenOptions {
+	return &tokenOptions{
+		refreshMargin: DefaultRefreshMargin,
+	}
+}
+
+// WithRefreshMargin is the option to set the default margin before the expiry of a token to refresh it
+func WithRefreshMargin(refreshMargin time.Duration) Option {
+	return func(opts *tokenOptions) {
+		if refreshMargin < 0 {
+			opts.refreshMargin = DefaultRefreshMargin
+		} else {
+			opts.refreshMargin = refreshMargin
+		}
+	}
+}
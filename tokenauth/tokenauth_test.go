@@ -0,0 +1,162 @@
+package tokenauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Strategy", func() {
+	var pth string
+	var tokens atomic.Int32
+	var lifetime time.Duration
+	var lastReq atomic.Pointer[TokenRequest]
+	var s *Strategy
+
+	writeFile := func(content string) {
+		Expect(os.WriteFile(pth, []byte(content), 0o600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		pth = filepath.Join(GinkgoT().TempDir(), "base-dsn")
+		tokens.Store(0)
+		lifetime = time.Hour
+		s = NewStrategy(TokenProviderFunc(func(ctx context.Context, req TokenRequest) (Token, error) {
+			lastReq.Store(&req)
+			n := tokens.Add(1)
+			return Token{Value: fmt.Sprintf("t'%d", n), ExpiresAt: time.Now().Add(lifetime)}, nil
+		}))
+		DeferCleanup(s.Close)
+	})
+
+	It("Should inject the token as the password of a key/value connection string", func(ctx context.Context) {
+		writeFile("host=db port=5433 user=app password=old dbname=app\n")
+		value, _, err := s.Watch(ctx, pth, "region=us-east-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal(`host=db port=5433 user=app password='t\'1' dbname=app`))
+		Expect(*lastReq.Load()).To(Equal(TokenRequest{
+			Host: "db", Port: "5433", User: "app", DBName: "app",
+			Params: url.Values{"region": []string{"us-east-1"}},
+		}))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should inject the token as the password of a URL connection string", func(ctx context.Context) {
+		writeFile("postgres://app@db:5433/app?sslmode=require")
+		value, _, err := s.Watch(ctx, pth, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("postgres://app:t%271@db:5433/app?sslmode=require"))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should send a new token a margin before the token expires", func(ctx context.Context) {
+		lifetime = 400 * time.Millisecond
+		writeFile("host=db user=app")
+		value, values, err := s.Watch(ctx, pth, "refreshMargin=300ms")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(ContainSubstring(`'t\'1'`))
+
+		start := time.Now()
+		Eventually(values).Should(Receive(ContainSubstring(`'t\'2'`)))
+		Expect(time.Since(start)).To(BeNumerically("<", 300*time.Millisecond))
+
+		// the base connection string changes are applied at the next refresh
+		writeFile("host=db2 user=app")
+		Eventually(values).Should(Receive(HavePrefix("host=db2 ")))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should stop refreshing once the watch is closed", func(ctx context.Context) {
+		lifetime = 100 * time.Millisecond
+		writeFile("host=db user=app")
+		_, values, err := s.Watch(ctx, pth, "refreshMargin=50ms")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(s.CloseWatch(pth, "refreshMargin=50ms")).To(Succeed())
+		Eventually(values).Should(BeClosed())
+		n := tokens.Load()
+		Consistently(tokens.Load, 200*time.Millisecond).Should(Equal(n))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should fail to watch a base connection string without user", func(ctx context.Context) {
+		writeFile("host=db")
+		_, _, err := s.Watch(ctx, pth, "")
+		Expect(errors.Is(err, ErrMissingUser)).To(BeTrue())
+	})
+
+	It("Should fail to watch with an invalid refresh margin", func(ctx context.Context) {
+		writeFile("host=db user=app")
+		_, _, err := s.Watch(ctx, pth, "refreshMargin=soon")
+		Expect(errors.Is(err, ErrInvalidRefreshMargin)).To(BeTrue())
+	})
+
+	DescribeTable("nextDelay",
+		func(tr *tokenRefresh, want time.Duration) {
+			tr.issuedAt = time.Unix(0, 0)
+			Expect(tr.nextDelay(time.Unix(0, 0))).To(Equal(want))
+		},
+		Entry("margin", &tokenRefresh{refreshMargin: 5 * time.Minute, expiresAt: time.Unix(900, 0)}, 10*time.Minute),
+		Entry("margin longer than the lifetime", &tokenRefresh{refreshMargin: time.Hour, expiresAt: time.Unix(900, 0)}, 450*time.Second),
+		Entry("failing", &tokenRefresh{failures: 2}, 2*time.Second),
+	)
+})
+
+var _ = Describe("RDSIAMProvider", func() {
+	It("Should generate a presigned connect token", func(ctx context.Context) {
+		p := &RDSIAMProvider{
+			Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", "SESSION"),
+			Region:      "us-west-2",
+		}
+		token, err := p.Token(ctx, TokenRequest{Host: "db.example.com", User: "app"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token.ExpiresAt).To(BeTemporally("~", time.Now().Add(15*time.Minute), time.Second))
+		Expect(token.Value).To(HavePrefix("db.example.com:5432?Action=connect&DBUser=app&X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Credential=AKID%2F"))
+
+		u, err := url.Parse("https://" + token.Value)
+		Expect(err).ToNot(HaveOccurred())
+		vs := u.Query()
+		Expect(vs.Get("X-Amz-Credential")).To(HaveSuffix("/us-west-2/rds-db/aws4_request"))
+		Expect(vs.Get("X-Amz-Expires")).To(Equal("900"))
+		Expect(vs.Get("X-Amz-SignedHeaders")).To(Equal("host"))
+		Expect(vs.Get("X-Amz-Security-Token")).To(Equal("SESSION"))
+		Expect(vs.Get("X-Amz-Signature")).To(HaveLen(64))
+		Expect(strings.HasSuffix(u.RawQuery, "&X-Amz-Signature="+vs.Get("X-Amz-Signature"))).To(BeTrue())
+	})
+
+	It("Should use the region query param", func(ctx context.Context) {
+		p := &RDSIAMProvider{
+			Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		}
+		token, err := p.Token(ctx, TokenRequest{Host: "db", Port: "3306", User: "app", Params: url.Values{"region": []string{"eu-west-1"}}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token.Value).To(HavePrefix("db:3306?"))
+		Expect(token.Value).To(ContainSubstring("%2Feu-west-1%2Frds-db%2F"))
+	})
+
+	It("Should use the region and credentials of the AWS default config", func(ctx context.Context) {
+		GinkgoT().Setenv("AWS_CONFIG_FILE", "/dev/null")
+		GinkgoT().Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+		GinkgoT().Setenv("AWS_REGION", "ap-south-1")
+		GinkgoT().Setenv("AWS_ACCESS_KEY_ID", "ENVKID")
+		GinkgoT().Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+		token, err := (&RDSIAMProvider{}).Token(ctx, TokenRequest{Host: "db", User: "app"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(token.Value).To(ContainSubstring("X-Amz-Credential=ENVKID%2F"))
+		Expect(token.Value).To(ContainSubstring("%2Fap-south-1%2Frds-db%2F"))
+	})
+
+	It("Should fail without region", func(ctx context.Context) {
+		GinkgoT().Setenv("AWS_CONFIG_FILE", "/dev/null")
+		GinkgoT().Setenv("AWS_REGION", "")
+		GinkgoT().Setenv("AWS_DEFAULT_REGION", "")
+		_, err := (&RDSIAMProvider{}).Token(ctx, TokenRequest{Host: "db", User: "app"})
+		Expect(errors.Is(err, ErrMissingRegion)).To(BeTrue())
+	})
+})
@@ -3,6 +3,7 @@ package hotload
 import (
 	"context"
 	"database/sql/driver"
+	"log/slog"
 
 	"github.com/infobloxopen/hotload/logger"
 	"github.com/infobloxopen/hotload/metrics"
@@ -17,19 +18,29 @@ type managedTx struct {
 }
 
 func (t *managedTx) Commit() error {
-	var log = logger.GetLogger()
-	log("managedTx.Commit")
+	t.conn.beginOp()
+	defer t.conn.endOp()
+	logger.DebugC("managedTx.Commit", "commit",
+		slog.String(logger.DsnKey, t.conn.redactDsn),
+		slog.Uint64(logger.GenerationKey, t.conn.generation),
+	)
 	err := t.tx.Commit()
+	t.conn.endTx()
 	t.cleanup()
-	return err
+	return t.conn.ctxErr(t.ctx, err)
 }
 
 func (t *managedTx) Rollback() error {
-	var log = logger.GetLogger()
-	log("managedTx.Rollback")
+	t.conn.beginOp()
+	defer t.conn.endOp()
+	logger.DebugC("managedTx.Rollback", "rollback",
+		slog.String(logger.DsnKey, t.conn.redactDsn),
+		slog.Uint64(logger.GenerationKey, t.conn.generation),
+	)
 	err := t.tx.Rollback()
+	t.conn.endTx()
 	t.cleanup()
-	return err
+	return t.conn.ctxErr(t.ctx, err)
 }
 
 func observeSQLStmtsSummary(ctx context.Context, execStmtsCounter, queryStmtsCounter int64) {
@@ -52,29 +63,27 @@ type promLabelKeyType struct{}
 var promLabelKey = promLabelKeyType{}
 
 func ContextWithExecLabels(ctx context.Context, labels map[string]string) context.Context {
-	var log = logger.GetLogger()
 	if labels == nil {
-		log("ContextWithExecLabels called with nil label set")
+		logger.Debug("called with nil label set", logger.Component("ContextWithExecLabels"))
 		return ctx
 	}
 	return context.WithValue(ctx, promLabelKey, labels)
 }
 
 func GetExecLabelsFromContext(ctx context.Context) map[string]string {
-	var log = logger.GetLogger()
 	if ctx == nil {
-		log("No context provided, returning")
+		logger.Debug("no context provided, returning", logger.Component("GetExecLabelsFromContext"))
 		return nil
 	}
 
 	value := ctx.Value(promLabelKey)
 	if value == nil {
-		log("No value for promLabelKey, returning")
+		logger.Debug("no value for promLabelKey, returning", logger.Component("GetExecLabelsFromContext"))
 		return nil
 	}
 	labelMap, ok := value.(map[string]string)
 	if !ok {
-		log("Bad value type used for promLabelKey, conversion error")
+		logger.Debug("bad value type used for promLabelKey, conversion error", logger.Component("GetExecLabelsFromContext"))
 		return nil
 	}
 
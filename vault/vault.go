@@ -0,0 +1,520 @@
+// Package vault implements a hotload strategy for the dynamic credentials of the
+// HashiCorp Vault database secrets engine. The strategy reads the credentials at the
+// path, builds the connection string from a template, and renews the lease with a
+// Vault API LifetimeWatcher while it can.
+// Before the lease reaches its max TTL (or once it can no longer be renewed), new
+// credentials are read and sent, so hotload switches over before the previous
+// database user is revoked.
+//
+// Import it to register the "vault" strategy:
+//
+//	import _ "github.com/infobloxopen/hotload/vault"
+//
+//	db, err := sql.Open("hotload", "vault://postgres/database/creds/app?templateFile=/etc/app/dsn.tmpl&k8sRole=app")
+package vault
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/api/auth/kubernetes"
+	"github.com/infobloxopen/hotload"
+	"github.com/infobloxopen/hotload/internal/backoff"
+	"github.com/infobloxopen/hotload/internal/fanout"
+	"github.com/infobloxopen/hotload/logger"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	hotload.RegisterStrategy("vault", NewStrategy())
+}
+
+const (
+	addrParam         = "addr"
+	namespaceParam    = "namespace"
+	templateParam     = "template"
+	templateFileParam = "templateFile"
+	tokenFileParam    = "tokenFile"
+	k8sRoleParam      = "k8sRole"
+	k8sMountParam     = "k8sMount"
+	k8sTokenFileParam = "k8sTokenFile"
+	caFileParam       = "caFile"
+	rotateBeforeParam = "rotateBefore"
+
+	defaultK8sMount = "kubernetes"
+
+	// maxRetryDelay is the longest delay between the retries of failing requests
+	maxRetryDelay = time.Minute
+)
+
+// leaseParams are the query params configuring the credentials, the watches
+// of a path with the same leaseParams share the same credentials
+var leaseParams = []string{
+	addrParam, namespaceParam, templateParam, templateFileParam, tokenFileParam,
+	k8sRoleParam, k8sMountParam, k8sTokenFileParam, caFileParam, rotateBeforeParam,
+}
+
+var (
+	// ErrVault is returned when a request to Vault fails
+	ErrVault = errors.New("vault request failed")
+	// ErrMissingTemplate is returned by Watch when neither the template nor the templateFile query param is set
+	ErrMissingTemplate = errors.New("missing template or templateFile query param")
+	// ErrMissingAuth is returned by Watch when neither the tokenFile nor the k8sRole query param is set
+	ErrMissingAuth = errors.New("missing tokenFile or k8sRole query param")
+	// ErrInvalidRotateBefore is returned by Watch when the rotateBefore query param is invalid
+	ErrInvalidRotateBefore = errors.New("invalid rotateBefore")
+	// ErrInvalidCAFile is returned by Watch when the caFile query param has no certificate
+	ErrInvalidCAFile = errors.New("invalid CA file")
+)
+
+// TemplateData is the data of the connection string template
+// (eg: "host=db user={{.Username}} password={{.Password}}")
+type TemplateData struct {
+	Username string
+	Password string
+	// Data is all the data of the credentials
+	Data map[string]any
+}
+
+// NewStrategy implements a hotload strategy that reads dynamic database
+// credentials from Vault.
+func NewStrategy(opts ...Option) *Strategy {
+	defOpts := newDefaultOptions()
+	for _, opt := range opts {
+		opt(defOpts)
+	}
+	s := &Strategy{
+		address: defOpts.address,
+		client:  defOpts.client,
+		timeout: defOpts.timeout,
+		leases:  make(map[string]*lease),
+	}
+	s.watches = fanout.New("vault", s.onCloseWatch)
+	return s
+}
+
+// Strategy implements the hotload Strategy interface by reading
+// and renewing Vault leases.
+type Strategy struct {
+	mu      sync.Mutex
+	address string
+	client  *http.Client // nil for the Vault API default client
+	timeout time.Duration
+	watches *fanout.Watches
+	leases  map[string]*lease
+}
+
+// leaseConfig is the credentials path and config of a watch
+type leaseConfig struct {
+	path         string
+	addr         string
+	namespace    string
+	template     string
+	templateFile string
+	tokenFile    string
+	k8sRole      string
+	k8sMount     string
+	k8sTokenFile string
+	caFile       string
+	rotateBefore time.Duration
+	// key identifies the credentials of the path with this config
+	key string
+}
+
+// lease is the state of the credentials of a watched path config
+type lease struct {
+	cfg    *leaseConfig
+	tmpl   *template.Template
+	client *api.Client
+	cancel context.CancelFunc
+
+	// only accessed by the renewal loop (once started)
+	secret *api.Secret
+	ttl    time.Duration
+	// renewing is set while the lease is renewed by a LifetimeWatcher,
+	// until Vault grants less than its TTL (close to its max TTL)
+	renewing  bool
+	expiresAt time.Time
+	value     string
+	failures  int
+	// tokenExpiry is the expiry of the token of the Kubernetes auth method
+	tokenExpiry time.Time
+}
+
+// Watch implements the hotload.Strategy interface.
+func (s *Strategy) Watch(ctx context.Context, pth string, pathQry string) (value string, values <-chan string, err error) {
+	pathQry = strings.TrimSpace(pathQry)
+	cfg, err := s.parseConfig(pth, pathQry)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return s.watches.Watch(cfg.key, pathQry, func() (string, error) {
+		l, err := s.newLease(cfg)
+		if err != nil {
+			return "", err
+		}
+		if err := l.readCreds(ctx); err != nil {
+			return "", err
+		}
+		renewCtx, cancel := context.WithCancel(ctx)
+		l.cancel = cancel
+		s.mu.Lock()
+		s.leases[cfg.key] = l
+		s.mu.Unlock()
+		go s.renewLoop(renewCtx, l)
+		return l.value, nil
+	})
+}
+
+// CloseWatch implements the hotload.Strategy interface.
+// Closes the specified watch by closing its update channel, the lease is
+// no longer renewed once all its watches are closed (it is not revoked,
+// as the connections using the credentials may still be draining).
+func (s *Strategy) CloseWatch(pth string, pathQry string) error {
+	pathQry = strings.TrimSpace(pathQry)
+	cfg, err := s.parseConfig(pth, pathQry)
+	if err != nil {
+		return err
+	}
+	s.watches.CloseWatch(cfg.key, pathQry)
+	return nil
+}
+
+// onCloseWatch stops renewing the lease once its last query is closed
+func (s *Strategy) onCloseWatch(key, pathQry string, last bool) {
+	if !last {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.leases[key]
+	if !ok {
+		return
+	}
+	l.cancel()
+	delete(s.leases, key)
+	logger.DebugC("vault.processWatchClosure", "stopped renewing lease", slog.String(logger.PathKey, l.cfg.path))
+}
+
+// Close implements the hotload.Strategy interface.
+// Closes this strategy by stopping all the renewals
+// and closing all the update channels.
+func (s *Strategy) Close() {
+	s.mu.Lock()
+	leases := s.leases
+	s.leases = make(map[string]*lease)
+	s.mu.Unlock()
+	for _, l := range leases {
+		l.cancel()
+	}
+	s.watches.Close()
+}
+
+// parseConfig parses the credentials path and the config (the query params)
+func (s *Strategy) parseConfig(pth, pathQry string) (*leaseConfig, error) {
+	vs, err := url.ParseQuery(pathQry)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse query %v", pathQry)
+	}
+	cfg := &leaseConfig{
+		path:         strings.Trim(pth, "/"),
+		addr:         vs.Get(addrParam),
+		namespace:    vs.Get(namespaceParam),
+		template:     vs.Get(templateParam),
+		templateFile: vs.Get(templateFileParam),
+		tokenFile:    vs.Get(tokenFileParam),
+		k8sRole:      vs.Get(k8sRoleParam),
+		k8sMount:     vs.Get(k8sMountParam),
+		k8sTokenFile: vs.Get(k8sTokenFileParam),
+		caFile:       vs.Get(caFileParam),
+	}
+	if cfg.addr == "" {
+		cfg.addr = s.address
+	}
+	if cfg.k8sMount == "" {
+		cfg.k8sMount = defaultK8sMount
+	}
+	if cfg.k8sTokenFile == "" {
+		cfg.k8sTokenFile = DefaultK8sTokenFile
+	}
+	if cfg.template == "" && cfg.templateFile == "" {
+		return nil, ErrMissingTemplate
+	}
+	if cfg.tokenFile == "" && cfg.k8sRole == "" {
+		return nil, ErrMissingAuth
+	}
+	if cfg.rotateBefore, err = backoff.ParseDuration(vs, rotateBeforeParam, cfg.rotateBefore, ErrInvalidRotateBefore); err != nil {
+		return nil, err
+	}
+
+	keyVals := make(url.Values)
+	for _, param := range leaseParams {
+		if v, ok := vs[param]; ok {
+			keyVals[param] = v
+		}
+	}
+	cfg.key = cfg.path + "?" + keyVals.Encode()
+	return cfg, nil
+}
+
+// newLease parses the template and creates the Vault client of the config
+func (s *Strategy) newLease(cfg *leaseConfig) (*lease, error) {
+	text := cfg.template
+	if cfg.templateFile != "" {
+		bs, err := os.ReadFile(cfg.templateFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read %v", cfg.templateFile)
+		}
+		text = string(bs)
+	}
+	tmpl, err := template.New("dsn").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse template")
+	}
+
+	vaultCfg := api.DefaultConfig()
+	if vaultCfg.Error != nil {
+		return nil, vaultCfg.Error
+	}
+	vaultCfg.Address = cfg.addr
+	vaultCfg.Timeout = s.timeout
+	// the renewal loop retries with a backoff, and a retried read
+	// of dynamic credentials would issue another lease
+	vaultCfg.MaxRetries = 0
+	if cfg.caFile != "" {
+		if err := vaultCfg.ConfigureTLS(&api.TLSConfig{CACert: cfg.caFile}); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidCAFile, cfg.caFile, err)
+		}
+	} else if s.client != nil {
+		vaultCfg.HttpClient = s.client
+	}
+	client, err := api.NewClient(vaultCfg)
+	if err != nil {
+		return nil, err
+	}
+	// authenticated by the token file or the Kubernetes auth method, not VAULT_TOKEN
+	client.ClearToken()
+	if cfg.namespace != "" {
+		client.SetNamespace(cfg.namespace)
+	}
+
+	return &lease{
+		cfg:    cfg,
+		tmpl:   tmpl,
+		client: client,
+	}, nil
+}
+
+// login sets the token of the client: read from the token file (before each read,
+// so a token renewed by a Vault agent is used), or of the Kubernetes auth method
+// (logging in again once it expired)
+func (l *lease) login(ctx context.Context) error {
+	if l.cfg.tokenFile != "" {
+		bs, err := os.ReadFile(l.cfg.tokenFile)
+		if err != nil {
+			return errors.Wrapf(err, "could not read %v", l.cfg.tokenFile)
+		}
+		l.client.SetToken(strings.TrimSpace(string(bs)))
+		return nil
+	}
+
+	if l.client.Token() != "" && (l.tokenExpiry.IsZero() || time.Now().Before(l.tokenExpiry)) {
+		return nil
+	}
+	jwt, err := os.ReadFile(l.cfg.k8sTokenFile)
+	if err != nil {
+		return errors.Wrapf(err, "could not read %v", l.cfg.k8sTokenFile)
+	}
+	k8sAuth, err := kubernetes.NewKubernetesAuth(l.cfg.k8sRole,
+		kubernetes.WithServiceAccountToken(strings.TrimSpace(string(jwt))),
+		kubernetes.WithMountPath(l.cfg.k8sMount),
+	)
+	if err != nil {
+		return err
+	}
+	sec, err := l.client.Auth().Login(ctx, k8sAuth)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrVault, err)
+	}
+	l.tokenExpiry = time.Time{}
+	if sec.Auth.LeaseDuration > 0 {
+		// log in again a bit before the token expires
+		d := time.Duration(sec.Auth.LeaseDuration) * time.Second
+		l.tokenExpiry = time.Now().Add(d - d/10)
+	}
+	return nil
+}
+
+// read reads the credentials at the path (eg: "database/creds/app"). A Kubernetes
+// auth method token refused by Vault (eg: revoked) is discarded, and the read is
+// retried once with a new token.
+func (l *lease) read(ctx context.Context) (*api.Secret, error) {
+	if err := l.login(ctx); err != nil {
+		return nil, err
+	}
+	sec, err := l.client.Logical().ReadWithContext(ctx, l.cfg.path)
+	var respErr *api.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden && l.cfg.tokenFile == "" {
+		l.client.ClearToken()
+		if err := l.login(ctx); err != nil {
+			return nil, err
+		}
+		sec, err = l.client.Logical().ReadWithContext(ctx, l.cfg.path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrVault, err)
+	}
+	if sec == nil {
+		return nil, fmt.Errorf("%w: no credentials at %s", ErrVault, l.cfg.path)
+	}
+	return sec, nil
+}
+
+// readCreds reads new credentials, and builds the connection string
+func (l *lease) readCreds(ctx context.Context) error {
+	sec, err := l.read(ctx)
+	if err != nil {
+		return err
+	}
+	data := TemplateData{Data: sec.Data}
+	data.Username, _ = sec.Data["username"].(string)
+	data.Password, _ = sec.Data["password"].(string)
+	var buf bytes.Buffer
+	if err := l.tmpl.Execute(&buf, data); err != nil {
+		return errors.Wrap(err, "could not execute template")
+	}
+
+	l.secret = sec
+	l.ttl = time.Duration(sec.LeaseDuration) * time.Second
+	l.renewing = sec.Renewable && sec.LeaseID != "" && l.ttl > 0
+	l.expiresAt = time.Now().Add(l.ttl)
+	l.value = strings.TrimSpace(buf.String())
+	return nil
+}
+
+// nextDelay returns the delay until new credentials are read: rotateBefore the expiry
+// of a lease that is no longer renewed (by default a third of its TTL), or the retry
+// delay once reading failed. Returns false when there is nothing to read again
+// (not a lease, eg: static credentials).
+func (l *lease) nextDelay(now time.Time) (time.Duration, bool) {
+	if l.failures > 0 {
+		return backoff.Delay(time.Second, maxRetryDelay, l.failures-1), true
+	}
+	if l.ttl <= 0 {
+		return 0, false
+	}
+	remaining := l.expiresAt.Sub(now)
+	rotateBefore := l.cfg.rotateBefore
+	if rotateBefore <= 0 {
+		rotateBefore = l.ttl / 3
+	}
+	return max(remaining-rotateBefore, 0), true
+}
+
+// renewLoop renews the lease, then reads new credentials before it expires,
+// until ctx is done (the path is no longer watched)
+func (s *Strategy) renewLoop(ctx context.Context, l *lease) {
+	logger.DebugC("vault.renewLoop", "started", slog.String(logger.PathKey, l.cfg.path))
+	for {
+		if l.renewing {
+			s.renew(ctx, l)
+		}
+		// without timer, the nil timerC blocks until ctx is done
+		var timer *time.Timer
+		var timerC <-chan time.Time
+		if delay, ok := l.nextDelay(time.Now()); ok {
+			timer = time.NewTimer(delay)
+			timerC = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			logger.DebugC("vault.renewLoop", "terminated", slog.String(logger.PathKey, l.cfg.path))
+			return
+		case <-timerC:
+			s.rotate(ctx, l)
+		}
+	}
+}
+
+// renew renews the lease with a LifetimeWatcher, until Vault grants less than its TTL
+// (the lease is close to its max TTL), the watcher gives up (eg: the renewals failed
+// until the lease is about to expire), or ctx is done
+func (s *Strategy) renew(ctx context.Context, l *lease) {
+	l.renewing = false
+	watcher, err := l.client.NewLifetimeWatcher(&api.LifetimeWatcherInput{
+		Secret:    l.secret,
+		Increment: int(l.ttl / time.Second),
+	})
+	if err != nil {
+		logger.ErrorC("vault.renew", "failed to watch lease",
+			slog.String(logger.PathKey, l.cfg.path),
+			logger.Err(err),
+		)
+		return
+	}
+	go watcher.Start()
+	defer watcher.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-watcher.DoneCh():
+			if err != nil {
+				// read new credentials rather than letting the lease expire
+				logger.ErrorC("vault.renew", "failed to renew lease, reading new credentials",
+					slog.String(logger.PathKey, l.cfg.path),
+					logger.Err(err),
+				)
+			}
+			return
+		case out := <-watcher.RenewCh():
+			d := time.Duration(out.Secret.LeaseDuration) * time.Second
+			l.expiresAt = out.RenewedAt.Add(d)
+			logger.DebugC("vault.renew", "renewed lease",
+				slog.String(logger.PathKey, l.cfg.path),
+				slog.Duration("leaseDuration", d),
+			)
+			if !out.Secret.Renewable || d < l.ttl {
+				return
+			}
+		}
+	}
+}
+
+// rotate reads new credentials and sends them
+func (s *Strategy) rotate(ctx context.Context, l *lease) {
+	prevValue := l.value
+	if err := l.readCreds(ctx); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		if l.failures == 0 {
+			// only log the first failure, until new credentials are read
+			logger.ErrorC("vault.rotate", "failed to read credentials",
+				slog.String(logger.PathKey, l.cfg.path),
+				logger.Err(err),
+			)
+		}
+		l.failures++
+		return
+	}
+	l.failures = 0
+	logger.DebugC("vault.rotate", "read new credentials", slog.String(logger.PathKey, l.cfg.path))
+	if l.value != prevValue {
+		s.watches.Set(l.cfg.key, l.value)
+	}
+}
@@ -0,0 +1,73 @@
+package vault
+
+import (
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	// DefaultAddress is the default Vault address (when the hotload connection
+	// string has no addr query param), VAULT_ADDR if set
+	DefaultAddress = defaultAddress()
+
+	// DefaultK8sTokenFile is the default service account token file
+	// used to log in with the Kubernetes auth method
+	DefaultK8sTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// DefaultTimeout is the default timeout of the requests to Vault
+	DefaultTimeout = time.Second * 10
+)
+
+func defaultAddress() string {
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		return addr
+	}
+	return "https://127.0.0.1:8200"
+}
+
+type vaultOptions struct {
+	address string
+	client  *http.Client
+	timeout time.Duration
+}
+
+type Option func(*vaultOptions)
+
+func newDefaultOptions() *vaultOptions {
+	return &vaultOptions{
+		address: DefaultAddress,
+		timeout: DefaultTimeout,
+	}
+}
+
+// WithAddress is the option to set the default Vault address
+func WithAddress(address string) Option {
+	return func(opts *vaultOptions) {
+		if address == "" {
+			opts.address = DefaultAddress
+		} else {
+			opts.address = address
+		}
+	}
+}
+
+// WithHTTPClient is the option to set the HTTP client of the requests to Vault
+// (by default, the Vault API client; ignored by the watches with the caFile
+// query param, which use their own client)
+func WithHTTPClient(client *http.Client) Option {
+	return func(opts *vaultOptions) {
+		opts.client = client
+	}
+}
+
+// WithTimeout is the option to set the timeout of the requests to Vault
+func WithTimeout(timeout time.Duration) Option {
+	return func(opts *vaultOptions) {
+		if timeout <= 0 {
+			opts.timeout = DefaultTimeout
+		} else {
+			opts.timeout = timeout
+		}
+	}
+}
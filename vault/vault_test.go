@@ -0,0 +1,225 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// fakeVault is a fake of the Vault API issuing database credentials
+// ("database/creds/app") and logging in with the Kubernetes auth method
+type fakeVault struct {
+	mu sync.Mutex
+	// ttl and maxTTL are the lease durations of the credentials
+	ttl    time.Duration
+	maxTTL time.Duration
+	creds  int
+	renews int
+	logins int
+	tokens map[string]bool
+	leases map[string]time.Time
+}
+
+func newFakeVault(ttl, maxTTL time.Duration) *fakeVault {
+	return &fakeVault{
+		ttl:    ttl,
+		maxTTL: maxTTL,
+		tokens: map[string]bool{"root-token": true},
+		leases: make(map[string]time.Time),
+	}
+}
+
+func (fv *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	writeJSON := func(status int, v any) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	writeErrors := func(status int, errs ...string) {
+		writeJSON(status, map[string]any{"errors": errs})
+	}
+	var body map[string]any
+	json.NewDecoder(r.Body).Decode(&body)
+
+	if (r.Method == http.MethodPost || r.Method == http.MethodPut) && r.URL.Path == "/v1/auth/kubernetes/login" {
+		if body["role"] != "app" || body["jwt"] != "sa-jwt" {
+			writeErrors(http.StatusBadRequest, "invalid role or jwt")
+			return
+		}
+		fv.logins++
+		token := fmt.Sprintf("k8s-token-%d", fv.logins)
+		fv.tokens[token] = true
+		writeJSON(http.StatusOK, api.Secret{Auth: &api.SecretAuth{ClientToken: token, LeaseDuration: 3600}})
+		return
+	}
+	if !fv.tokens[r.Header.Get("X-Vault-Token")] {
+		writeErrors(http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/database/creds/app":
+		fv.creds++
+		leaseID := fmt.Sprintf("database/creds/app/%d", fv.creds)
+		fv.leases[leaseID] = time.Now().Add(fv.maxTTL)
+		writeJSON(http.StatusOK, api.Secret{
+			LeaseID:       leaseID,
+			LeaseDuration: int(fv.ttl / time.Second),
+			Renewable:     true,
+			Data: map[string]any{
+				"username": fmt.Sprintf("u%d", fv.creds),
+				"password": fmt.Sprintf("p%d", fv.creds),
+			},
+		})
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/sys/leases/renew":
+		leaseID, _ := body["lease_id"].(string)
+		maxExpiry, ok := fv.leases[leaseID]
+		if !ok {
+			writeErrors(http.StatusBadRequest, "lease not found")
+			return
+		}
+		fv.renews++
+		increment := time.Duration(body["increment"].(float64)) * time.Second
+		d := min(increment, time.Until(maxExpiry))
+		writeJSON(http.StatusOK, api.Secret{LeaseID: leaseID, LeaseDuration: int(d / time.Second), Renewable: true})
+	default:
+		writeErrors(http.StatusNotFound)
+	}
+}
+
+func (fv *fakeVault) stats() (creds, renews, logins int) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	return fv.creds, fv.renews, fv.logins
+}
+
+const (
+	credsPath = "/database/creds/app"
+	dsnTmpl   = "host=db user={{.Username}} password={{.Password}}"
+)
+
+var _ = Describe("Strategy", func() {
+	var fv *fakeVault
+	var s *Strategy
+	var tokenFile string
+
+	query := func(params ...string) string {
+		vs := url.Values{templateParam: []string{dsnTmpl}, tokenFileParam: []string{tokenFile}}
+		for i := 0; i+1 < len(params); i += 2 {
+			vs.Set(params[i], params[i+1])
+		}
+		return vs.Encode()
+	}
+
+	BeforeEach(func() {
+		fv = newFakeVault(time.Hour, 24*time.Hour)
+		srv := httptest.NewServer(fv)
+		DeferCleanup(srv.Close)
+		s = NewStrategy(WithAddress(srv.URL))
+		DeferCleanup(s.Close)
+		tokenFile = filepath.Join(GinkgoT().TempDir(), "token")
+		Expect(os.WriteFile(tokenFile, []byte("root-token\n"), 0o600)).To(Succeed())
+	})
+
+	It("Should build the connection string from the credentials", func(ctx context.Context) {
+		value, _, err := s.Watch(ctx, credsPath, query())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("host=db user=u1 password=p1"))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should read the template file", func(ctx context.Context) {
+		templateFile := filepath.Join(GinkgoT().TempDir(), "dsn.tmpl")
+		Expect(os.WriteFile(templateFile, []byte("postgres://{{.Username}}:{{.Password | urlquery}}@db/app\n"), 0o600)).To(Succeed())
+		vs := url.Values{templateFileParam: []string{templateFile}, tokenFileParam: []string{tokenFile}}
+		value, _, err := s.Watch(ctx, credsPath, vs.Encode())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("postgres://u1:p1@db/app"))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should renew the lease, then send new credentials before the max TTL", func(ctx context.Context) {
+		fv.ttl, fv.maxTTL = time.Second, 2*time.Second
+		_, values, err := s.Watch(ctx, credsPath, query())
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(values, 3*time.Second).Should(Receive(Equal("host=db user=u2 password=p2")))
+		_, renews, _ := fv.stats()
+		Expect(renews).To(BeNumerically(">=", 1))
+	}, NodeTimeout(10*time.Second))
+
+	It("Should log in with the Kubernetes auth method", func(ctx context.Context) {
+		jwtFile := filepath.Join(GinkgoT().TempDir(), "jwt")
+		Expect(os.WriteFile(jwtFile, []byte("sa-jwt\n"), 0o600)).To(Succeed())
+		vs := url.Values{
+			templateParam:     []string{dsnTmpl},
+			k8sRoleParam:      []string{"app"},
+			k8sTokenFileParam: []string{jwtFile},
+		}
+		value, _, err := s.Watch(ctx, credsPath, vs.Encode())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value).To(Equal("host=db user=u1 password=p1"))
+		_, _, logins := fv.stats()
+		Expect(logins).To(Equal(1))
+	}, NodeTimeout(5*time.Second))
+
+	It("Should fan out to the watches of the same path config, and stop renewing once they are closed", func(ctx context.Context) {
+		value1, values1, err := s.Watch(ctx, credsPath, query())
+		Expect(err).ToNot(HaveOccurred())
+		value2, values2, err := s.Watch(ctx, credsPath, query("forceKill", "true"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(value2).To(Equal(value1))
+		creds, _, _ := fv.stats()
+		Expect(creds).To(Equal(1))
+
+		Expect(s.CloseWatch(credsPath, query())).To(Succeed())
+		Expect(s.CloseWatch(credsPath, query("forceKill", "true"))).To(Succeed())
+		Eventually(values1).Should(BeClosed())
+		Eventually(values2).Should(BeClosed())
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		Expect(s.leases).To(BeEmpty())
+	}, NodeTimeout(5*time.Second))
+
+	It("Should fail to watch when Vault refuses the token", func(ctx context.Context) {
+		Expect(os.WriteFile(tokenFile, []byte("bad-token"), 0o600)).To(Succeed())
+		_, _, err := s.Watch(ctx, credsPath, query())
+		Expect(errors.Is(err, ErrVault)).To(BeTrue())
+		Expect(s.leases).To(BeEmpty())
+	}, NodeTimeout(5*time.Second))
+
+	DescribeTable("Should fail to watch invalid params",
+		func(qry string, wantErr error) {
+			_, _, err := s.Watch(context.Background(), credsPath, qry)
+			Expect(errors.Is(err, wantErr)).To(BeTrue())
+		},
+		Entry("no template", "tokenFile=/tmp/token", ErrMissingTemplate),
+		Entry("no auth", "template=x", ErrMissingAuth),
+		Entry("rotateBefore", "template=x&tokenFile=/tmp/token&rotateBefore=-1m", ErrInvalidRotateBefore),
+	)
+
+	DescribeTable("nextDelay",
+		func(l *lease, want time.Duration, wantOK bool) {
+			l.cfg = &leaseConfig{}
+			delay, ok := l.nextDelay(time.Unix(0, 0))
+			Expect(ok).To(Equal(wantOK))
+			Expect(delay).To(Equal(want))
+		},
+		Entry("no longer renewed", &lease{ttl: time.Hour, expiresAt: time.Unix(3600, 0)}, 40*time.Minute, true),
+		Entry("close to expiry", &lease{ttl: time.Hour, expiresAt: time.Unix(600, 0)}, time.Duration(0), true),
+		Entry("not a lease", &lease{}, time.Duration(0), false),
+		Entry("failing", &lease{ttl: time.Hour, failures: 3}, 4*time.Second, true),
+		Entry("failing for long", &lease{ttl: time.Hour, failures: 100}, maxRetryDelay, true),
+	)
+})
@@ -0,0 +1,126 @@
+package hotload
+
+import (
+	"context"
+	"database/sql/driver"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/infobloxopen/hotload/internal"
+	"github.com/infobloxopen/hotload/logger"
+)
+
+// DefaultWarmTimeout is the default maximum time a dsn change waits
+// for the warm conns to be opened and verified, before switching over
+const DefaultWarmTimeout = 5 * time.Second
+
+// warmConn is a verified conn opened with a new value, before switching over to it
+type warmConn struct {
+	conn      driver.Conn
+	dsn       string
+	redactDsn string
+	openedAt  time.Time
+}
+
+// warmUp opens and verifies the configured number of warm conns with the new value,
+// for up to the warm timeout. The conns that failed (or were too slow) are discarded,
+// the switchover is not prevented even if none succeeded.
+func (cg *chanGroup) warmUp(newValue string) []warmConn {
+	n := cg.warmConns
+	if n <= 0 || cg.sqlDriver == nil {
+		return nil
+	}
+	dsn, err := mergeConnStringOptions(newValue, cg.sqlDriver.options)
+	if err != nil {
+		return nil
+	}
+	redactDsn := internal.RedactUrl(dsn)
+
+	timeout := cg.warmTimeout
+	if timeout <= 0 {
+		timeout = DefaultWarmTimeout
+	}
+	ctx, cancel := context.WithTimeout(cg.parentCtx, timeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	done := false
+	warmed := make([]warmConn, 0, n)
+	pending := n
+	allDone := make(chan struct{})
+	for i := 0; i < n; i++ {
+		go func() {
+			conn, err := cg.dialVerified(ctx, dsn)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				logger.ErrorC("chanGroup.warmUp", "failed to open warm conn",
+					slog.String(logger.NameKey, cg.name),
+					slog.String(logger.DsnKey, redactDsn),
+					logger.Err(err),
+				)
+			case done:
+				// too late, the switchover did not wait for it
+				conn.Close()
+			default:
+				warmed = append(warmed, warmConn{conn: conn, dsn: dsn, redactDsn: redactDsn, openedAt: time.Now()})
+			}
+			pending--
+			if pending == 0 && !done {
+				close(allDone)
+			}
+		}()
+	}
+
+	select {
+	case <-allDone:
+	case <-ctx.Done():
+		logger.ErrorC("chanGroup.warmUp", "timed out opening warm conns",
+			slog.String(logger.NameKey, cg.name),
+			slog.String(logger.DsnKey, redactDsn),
+			slog.Duration("timeout", timeout),
+		)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	done = true
+	logger.DebugC("chanGroup.warmUp", "opened warm conns", slog.String(logger.NameKey, cg.name),
+		slog.String(logger.DsnKey, redactDsn),
+		slog.Int("warmConns", len(warmed)),
+	)
+	return warmed
+}
+
+// dialVerified opens a conn with dsn and verifies it (using driver.Pinger or driver.Validator)
+func (cg *chanGroup) dialVerified(ctx context.Context, dsn string) (driver.Conn, error) {
+	var conn driver.Conn
+	var err error
+	if dc, ok := cg.sqlDriver.driver.(driver.DriverContext); ok {
+		var connector driver.Connector
+		connector, err = dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		conn, err = connector.Connect(ctx)
+	} else {
+		conn, err = cg.sqlDriver.driver.Open(dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p, ok := conn.(driver.Pinger); ok {
+		err = p.Ping(ctx)
+	} else if v, ok := conn.(driver.Validator); ok && !v.IsValid() {
+		err = driver.ErrBadConn
+	}
+	if err != nil {
+		// ignore errors from close
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}